@@ -0,0 +1,140 @@
+package admin
+
+import (
+	"encoding/json"
+	"net/http"
+	"runtime"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	"github.com/spf13/viper"
+	"go.opentelemetry.io/otel/trace"
+
+	"goexpert-lab-2-observabilidade/internal/apperr"
+	"goexpert-lab-2-observabilidade/internal/config"
+)
+
+// maxErrorSamples bounds the recent errors kept for diagnostics.
+const maxErrorSamples = 20
+
+type errorSample struct {
+	Time    time.Time `json:"time"`
+	Type    string    `json:"type"`
+	Status  int       `json:"status"`
+	Message string    `json:"message"`
+	Path    string    `json:"path"`
+	TraceID string    `json:"trace_id,omitempty"`
+}
+
+// ErrorSamples is a small ring of the latest errors returned to clients.
+type ErrorSamples struct {
+	mu      sync.Mutex
+	samples []errorSample
+	next    int
+}
+
+// RecentErrors are the errors returned to clients, reported by /diagnostics.
+var RecentErrors = &ErrorSamples{}
+
+// Record keeps err, returned for r, as the latest sample.
+func (e *ErrorSamples) Record(r *http.Request, err *apperr.Error) {
+	sample := errorSample{
+		Time:    time.Now(),
+		Type:    err.Type,
+		Status:  err.Status,
+		Message: err.Error(),
+		Path:    r.URL.Path,
+	}
+	if sc := trace.SpanContextFromContext(r.Context()); sc.HasTraceID() {
+		sample.TraceID = sc.TraceID().String()
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if len(e.samples) < maxErrorSamples {
+		e.samples = append(e.samples, sample)
+		return
+	}
+	e.samples[e.next] = sample
+	e.next = (e.next + 1) % maxErrorSamples
+}
+
+// list returns the samples, newest first.
+func (e *ErrorSamples) list() []errorSample {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	out := make([]errorSample, 0, len(e.samples))
+	for i := len(e.samples) - 1; i >= 0; i-- {
+		out = append(out, e.samples[(e.next+i)%len(e.samples)])
+	}
+	return out
+}
+
+// Diagnostics gathers everything an operator needs to triage into one
+// snapshot; subsystems register a section each.
+type Diagnostics struct {
+	mu       sync.Mutex
+	sections map[string]func() any
+}
+
+// NewDiagnostics builds the snapshot with the build info, RecentErrors and
+// the values of configKeys, the settings worth seeing at a glance while
+// triaging.
+func NewDiagnostics(configKeys ...string) *Diagnostics {
+	d := &Diagnostics{sections: make(map[string]func() any)}
+	d.Register("build", buildInfo)
+	d.Register("config", func() any { return configSummary(configKeys) })
+	d.Register("recent_errors", func() any { return RecentErrors.list() })
+	return d
+}
+
+// Register adds a named section computed at request time.
+func (d *Diagnostics) Register(name string, fn func() any) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.sections[name] = fn
+}
+
+// ServeHTTP writes the snapshot as JSON.
+func (d *Diagnostics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	d.mu.Lock()
+	snapshot := make(map[string]any, len(d.sections)+1)
+	for name, fn := range d.sections {
+		snapshot[name] = fn()
+	}
+	d.mu.Unlock()
+	snapshot["generated_at"] = time.Now()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(snapshot)
+}
+
+func buildInfo() any {
+	info := map[string]string{"go_version": runtime.Version()}
+	bi, ok := debug.ReadBuildInfo()
+	if !ok {
+		return info
+	}
+	info["module"] = bi.Main.Path
+	info["version"] = Version()
+	for _, s := range bi.Settings {
+		switch s.Key {
+		case "vcs.revision", "vcs.time", "vcs.modified":
+			info[s.Key] = s.Value
+		}
+	}
+	return info
+}
+
+func configSummary(keys []string) any {
+	summary := make(map[string]any, len(keys))
+	config.Read(func() {
+		for _, key := range keys {
+			summary[key] = viper.Get(key)
+		}
+	})
+	return summary
+}
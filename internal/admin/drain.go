@@ -0,0 +1,75 @@
+package admin
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// drainPollInterval is how often /drain checks for remaining in-flight requests.
+const drainPollInterval = 50 * time.Millisecond
+
+// Drainer counts in-flight API requests and holds the readiness flag, so a
+// Kubernetes preStop hook can take the pod out of rotation and wait for the
+// requests it is still serving before SIGTERM arrives.
+type Drainer struct {
+	timeout  time.Duration // longest /drain waits for in-flight requests
+	draining atomic.Bool
+	inFlight atomic.Int64
+}
+
+// NewDrainer builds a Drainer whose /drain waits up to timeout.
+func NewDrainer(timeout time.Duration) *Drainer {
+	return &Drainer{timeout: timeout}
+}
+
+// Middleware counts the requests in flight; mount it outermost on the API.
+func (d *Drainer) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		d.inFlight.Add(1)
+		defer d.inFlight.Add(-1)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Start flips readiness to failing; it can't be undone.
+func (d *Drainer) Start() {
+	d.draining.Store(true)
+}
+
+// Draining reports whether draining has started.
+func (d *Drainer) Draining() bool {
+	return d.draining.Load()
+}
+
+type drainReport struct {
+	Drained  bool  `json:"drained"`
+	InFlight int64 `json:"in_flight"`
+}
+
+// ServeHTTP starts draining and waits, up to the drain timeout, for the
+// in-flight requests to finish.
+func (d *Drainer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	d.Start()
+
+	timeout := time.NewTimer(d.timeout)
+	defer timeout.Stop()
+	ticker := time.NewTicker(drainPollInterval)
+	defer ticker.Stop()
+
+	for d.inFlight.Load() > 0 {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-timeout.C:
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusGatewayTimeout)
+			json.NewEncoder(w).Encode(drainReport{InFlight: d.inFlight.Load()})
+			return
+		case <-ticker.C:
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(drainReport{Drained: true})
+}
@@ -0,0 +1,53 @@
+package admin
+
+import (
+	"encoding/json"
+	"net/http"
+	"runtime/debug"
+	"time"
+)
+
+// version is set at build time with -ldflags
+// "-X goexpert-lab-2-observabilidade/internal/admin.version=..."; without it
+// the module version or VCS revision from the build info is reported.
+var version string
+
+// Version is the version of the running build.
+func Version() string {
+	if version != "" {
+		return version
+	}
+	bi, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "unknown"
+	}
+	if bi.Main.Version != "" && bi.Main.Version != "(devel)" {
+		return bi.Main.Version
+	}
+	for _, s := range bi.Settings {
+		if s.Key == "vcs.revision" {
+			return s.Value
+		}
+	}
+	return bi.Main.Version
+}
+
+type livenessReport struct {
+	Status  string  `json:"status"`
+	Uptime  float64 `json:"uptime_seconds"`
+	Version string  `json:"version"`
+}
+
+// Liveness answers the liveness probe, with the uptime since started: it
+// checks nothing beyond the process serving requests, so a wedged instance
+// fails it and gets restarted.
+func Liveness(started time.Time) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(livenessReport{
+			Status:  "ok",
+			Uptime:  time.Since(started).Seconds(),
+			Version: Version(),
+		})
+	}
+}
@@ -0,0 +1,18 @@
+package admin
+
+import (
+	"net/http/pprof"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// RegisterPprof mounts the net/http/pprof handlers on r; only done on the
+// admin listener and when ENABLE_PPROF is set, as profiles expose internals
+// and CPU profiling has a cost.
+func RegisterPprof(r chi.Router) {
+	r.HandleFunc("/debug/pprof/*", pprof.Index)
+	r.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	r.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	r.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	r.HandleFunc("/debug/pprof/trace", pprof.Trace)
+}
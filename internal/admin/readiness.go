@@ -0,0 +1,124 @@
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+type dependencyStatus struct {
+	Status    string  `json:"status"`
+	Error     string  `json:"error,omitempty"`
+	LatencyMS float64 `json:"latency_ms"`
+}
+
+type readinessReport struct {
+	Status       string                      `json:"status"`
+	CheckedAt    time.Time                   `json:"checked_at"`
+	Dependencies map[string]dependencyStatus `json:"dependencies"`
+}
+
+// Readiness answers the readiness probe by checking that every dependency is
+// reachable. Results are cached for ttl, so however often the probe runs the
+// dependencies see at most one check per ttl.
+type Readiness struct {
+	client  *http.Client
+	targets map[string]string // dependency name -> base URL
+	ttl     time.Duration
+	timeout time.Duration
+	drain   *Drainer
+
+	mu      sync.Mutex
+	checked time.Time
+	results map[string]dependencyStatus
+}
+
+// NewReadiness builds the probe of targets, each checked with a HEAD of its
+// base URL, failing while drain is draining.
+func NewReadiness(client *http.Client, targets map[string]string, ttl, timeout time.Duration, drain *Drainer) *Readiness {
+	return &Readiness{client: client, targets: targets, ttl: ttl, timeout: timeout, drain: drain}
+}
+
+// check probes a dependency; any response below 500 means it is reachable.
+func (rd *Readiness) check(baseURL string) dependencyStatus {
+	// detached from the probe request: the result is shared through the cache
+	ctx := context.Background()
+	if rd.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, rd.timeout)
+		defer cancel()
+	}
+
+	start := time.Now()
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, baseURL, nil)
+	if err == nil {
+		var resp *http.Response
+		if resp, err = rd.client.Do(req); err == nil {
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+			if resp.StatusCode >= http.StatusInternalServerError {
+				err = fmt.Errorf("%s responded with status %d", baseURL, resp.StatusCode)
+			}
+		}
+	}
+
+	st := dependencyStatus{Status: "up", LatencyMS: float64(time.Since(start).Microseconds()) / 1000}
+	if err != nil {
+		st.Status = "down"
+		st.Error = err.Error()
+	}
+	return st
+}
+
+// report returns the cached results, checking the dependencies again
+// concurrently once they are older than ttl.
+func (rd *Readiness) report() readinessReport {
+	rd.mu.Lock()
+	defer rd.mu.Unlock()
+
+	if rd.results == nil || time.Since(rd.checked) >= rd.ttl {
+		results := make(map[string]dependencyStatus, len(rd.targets))
+		var mu sync.Mutex
+		var wg sync.WaitGroup
+		for name, baseURL := range rd.targets {
+			wg.Add(1)
+			go func(name, baseURL string) {
+				defer wg.Done()
+				st := rd.check(baseURL)
+				mu.Lock()
+				results[name] = st
+				mu.Unlock()
+			}(name, baseURL)
+		}
+		wg.Wait()
+		rd.results, rd.checked = results, time.Now()
+	}
+
+	report := readinessReport{Status: "ready", CheckedAt: rd.checked, Dependencies: rd.results}
+	for _, st := range rd.results {
+		if st.Status != "up" {
+			report.Status = "not_ready"
+		}
+	}
+	return report
+}
+
+// ServeHTTP answers 200 when ready and 503 when draining or when any
+// dependency is down, with the per-dependency status as JSON.
+func (rd *Readiness) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	status := http.StatusOK
+	report := readinessReport{Status: "draining"}
+	if rd.drain.Draining() {
+		status = http.StatusServiceUnavailable
+	} else if report = rd.report(); report.Status != "ready" {
+		status = http.StatusServiceUnavailable
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(report)
+}
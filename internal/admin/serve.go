@@ -0,0 +1,34 @@
+package admin
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"goexpert-lab-2-observabilidade/internal/logging"
+)
+
+// Serve runs listen, an srv.ListenAndServe variant, and exits the process
+// if the listener fails for any reason other than a graceful shutdown.
+func Serve(name string, listen func() error) {
+	if err := listen(); !errors.Is(err, http.ErrServerClosed) {
+		logging.Fatal(name+" listener stopped", err)
+	}
+}
+
+// DrainServer shuts srv down gracefully. It first waits for delay, giving load
+// balancers time to stop routing new requests, then stops accepting
+// connections and waits up to timeout for in-flight requests, closing the
+// remaining connections after that.
+func DrainServer(srv *http.Server, delay, timeout time.Duration) {
+	time.Sleep(delay)
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		slog.Error("graceful shutdown timed out, closing connections", "addr", srv.Addr, "error", err)
+		srv.Close()
+	}
+}
@@ -0,0 +1,115 @@
+package admin
+
+import (
+	"encoding/json"
+	"math/rand"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"goexpert-lab-2-observabilidade/internal/middleware"
+)
+
+// reservoirSize bounds the latency samples kept for the p95 estimate.
+const reservoirSize = 2048
+
+// StartTime is when the process started, for /healthz and /stats.
+var StartTime = time.Now()
+
+// uptimeGauge is the uptime as a gauge; the start time itself is exported by
+// the process collector, as process_start_time_seconds.
+var uptimeGauge = prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+	Name: "app_uptime_seconds",
+	Help: "Seconds since the service started.",
+}, func() float64 { return time.Since(StartTime).Seconds() })
+
+// Collectors returns the metrics of the package, for the registry of a service.
+func Collectors() []prometheus.Collector {
+	return []prometheus.Collector{uptimeGauge}
+}
+
+// Stats keeps since-start counters for the /stats snapshot.
+type Stats struct {
+	mu       sync.Mutex
+	requests uint64
+	errors   uint64
+	seen     uint64
+	samples  []float64 // reservoir of request latencies, in seconds
+}
+
+// StatsSnapshot is what /stats reports.
+type StatsSnapshot struct {
+	StartedAt      time.Time `json:"started_at"`
+	UptimeSeconds  float64   `json:"uptime_seconds"`
+	RequestsServed uint64    `json:"requests_served"`
+	Errors         uint64    `json:"errors"`
+	ErrorRate      float64   `json:"error_rate"`
+	P95LatencyMs   float64   `json:"p95_latency_ms"`
+}
+
+// NewStats builds an empty Stats.
+func NewStats() *Stats {
+	return &Stats{samples: make([]float64, 0, reservoirSize)}
+}
+
+// Middleware counts every request but WebSocket upgrades, treating 5xx
+// responses as errors.
+func (s *Stats) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &middleware.StatusRecorder{ResponseWriter: w, Status: http.StatusOK}
+		next.ServeHTTP(rec, r)
+		if middleware.IsWebSocketUpgrade(r) {
+			return
+		}
+		s.observe(time.Since(start), rec.Status >= http.StatusInternalServerError)
+	})
+}
+
+func (s *Stats) observe(dur time.Duration, failed bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.requests++
+	if failed {
+		s.errors++
+	}
+
+	s.seen++
+	if len(s.samples) < reservoirSize {
+		s.samples = append(s.samples, dur.Seconds())
+	} else if i := rand.Int63n(int64(s.seen)); i < reservoirSize {
+		s.samples[i] = dur.Seconds()
+	}
+}
+
+// Snapshot returns the counters so far, with the p95 of the sampled latencies.
+func (s *Stats) Snapshot() StatsSnapshot {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	snap := StatsSnapshot{
+		StartedAt:      StartTime,
+		UptimeSeconds:  time.Since(StartTime).Seconds(),
+		RequestsServed: s.requests,
+		Errors:         s.errors,
+	}
+	if s.requests > 0 {
+		snap.ErrorRate = float64(s.errors) / float64(s.requests)
+	}
+	if len(s.samples) > 0 {
+		sorted := append([]float64(nil), s.samples...)
+		sort.Float64s(sorted)
+		snap.P95LatencyMs = sorted[(len(sorted)*95-1)/100] * 1000
+	}
+	return snap
+}
+
+// ServeHTTP writes the snapshot as JSON.
+func (s *Stats) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.Snapshot())
+}
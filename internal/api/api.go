@@ -0,0 +1,220 @@
+// Package api holds the request and response bodies of the temperature API,
+// shared by service-a and service-b so both ends of the hop agree on them,
+// and how both services write them and their errors.
+package api
+
+import (
+	"errors"
+	"math"
+	"strconv"
+	"time"
+)
+
+// TemperatureRequest is the body of POST /v1/temperature on both services.
+type TemperatureRequest struct {
+	CEP     string `json:"cep"`
+	Country string `json:"country,omitempty"` // ISO 3166-1 alpha-2, DefaultCountry when empty
+}
+
+// CacheHeader tells, HIT or MISS, whether a temperature response came from a
+// cache, or STALE when it came from an expired entry because the weather
+// couldn't be refreshed in time; Temperature.Cached and Stale carry it
+// between the services.
+const CacheHeader = "X-Cache"
+
+// AgeHeader is the standard Age header, the seconds a cached temperature has
+// been in a cache, which clients can use to decide whether it is too stale.
+const AgeHeader = "Age"
+
+// Temperature is the city of a CEP and its current temperature in each scale.
+type Temperature struct {
+	City  string  `json:"city"`
+	TempC float64 `json:"temp_C"`
+	TempF float64 `json:"temp_F"`
+	TempK float64 `json:"temp_K"`
+
+	// Condition is the weather as described by WeatherAPI, e.g. "Partly cloudy".
+	Condition string `json:"condition,omitempty"`
+
+	// Timezone is the IANA time zone of the city, e.g. "America/Sao_Paulo",
+	// and LocalTime the time of the report there, as RFC 3339.
+	Timezone  string `json:"timezone,omitempty"`
+	LocalTime string `json:"local_time,omitempty"`
+
+	// ObservedAt is when WeatherAPI last updated the weather, and RetrievedAt
+	// when service-b fetched it, both as RFC 3339 in UTC. A cached response is
+	// as old as RetrievedAt.
+	ObservedAt  string `json:"observed_at,omitempty"`
+	RetrievedAt string `json:"retrieved_at,omitempty"`
+
+	// Cached and Stale are sent in CacheHeader rather than in the body.
+	Cached bool `json:"-"`
+	Stale  bool `json:"-"`
+
+	// Details are only sent when asked for, with ?detail=full on service-b.
+	Details *WeatherDetails `json:"details,omitempty"`
+
+	// Address is the ViaCEP address of the CEP, with ?include=address on service-b.
+	Address *Address `json:"address,omitempty"`
+}
+
+// CacheStatus is the CacheHeader value for t.
+func (t Temperature) CacheStatus() string {
+	switch {
+	case t.Stale:
+		return "STALE"
+	case t.Cached:
+		return "HIT"
+	}
+	return "MISS"
+}
+
+// Age is the AgeHeader value for t: the seconds since RetrievedAt, or "" when
+// t didn't come from a cache.
+func (t Temperature) Age() string {
+	retrieved, err := time.Parse(time.RFC3339, t.RetrievedAt)
+	if !t.Cached || err != nil {
+		return ""
+	}
+	return strconv.FormatInt(max(int64(time.Since(retrieved).Seconds()), 0), 10)
+}
+
+// Address is what ViaCEP knows about a CEP besides its city.
+type Address struct {
+	Street       string `json:"street,omitempty"` // logradouro; empty for CEPs covering a whole city
+	Neighborhood string `json:"neighborhood,omitempty"`
+	UF           string `json:"uf"`
+	IBGECode     string `json:"ibge_code,omitempty"` // the IBGE code of the city, from ViaCEP only
+}
+
+// WeatherDetails are the current conditions beyond the temperature.
+type WeatherDetails struct {
+	Humidity   int     `json:"humidity"` // relative, in percent
+	FeelsLikeC float64 `json:"feels_like_C"`
+	FeelsLikeF float64 `json:"feels_like_F"`
+	WindKph    float64 `json:"wind_kph"`
+	WindDegree int     `json:"wind_degree"` // where the wind comes from, clockwise from north
+	WindDir    string  `json:"wind_dir"`    // the same as a compass point, e.g. "SSW"
+	IconURL    string  `json:"icon_url,omitempty"`
+}
+
+// Forecast is the daily forecast for the city of a CEP, starting today.
+type Forecast struct {
+	City string        `json:"city"`
+	Days []ForecastDay `json:"days"`
+}
+
+// ForecastDay is the expected temperature range and weather of one day.
+type ForecastDay struct {
+	Date     string  `json:"date"` // YYYY-MM-DD, in the city's time zone
+	MinTempC float64 `json:"min_temp_C"`
+	MaxTempC float64 `json:"max_temp_C"`
+	MinTempF float64 `json:"min_temp_F"`
+	MaxTempF float64 `json:"max_temp_F"`
+
+	// Condition is the day's weather as described by WeatherAPI, e.g. "Patchy rain nearby".
+	Condition string `json:"condition,omitempty"`
+}
+
+// AirQuality is the current air quality in the city of a CEP.
+type AirQuality struct {
+	City string  `json:"city"`
+	PM25 float64 `json:"pm2_5"` // fine particulate matter, in µg/m³
+	PM10 float64 `json:"pm10"`  // coarse particulate matter, in µg/m³
+
+	// AQI is the US EPA index, from 1 (good) to 6 (hazardous), and Category its name.
+	AQI      int    `json:"aqi"`
+	Category string `json:"category"`
+}
+
+// Astronomy is the sun and moon schedule of a day in the city of a CEP.
+// Times are HH:MM in the city's time zone, and empty when the event does not
+// happen that day, e.g. no moonrise.
+type Astronomy struct {
+	City     string `json:"city"`
+	Date     string `json:"date"` // YYYY-MM-DD
+	Sunrise  string `json:"sunrise,omitempty"`
+	Sunset   string `json:"sunset,omitempty"`
+	Moonrise string `json:"moonrise,omitempty"`
+	Moonset  string `json:"moonset,omitempty"`
+
+	// MoonPhase is as named by WeatherAPI, e.g. "Waxing Crescent", and
+	// MoonIllumination the lit share of the moon, in percent.
+	MoonPhase        string `json:"moon_phase"`
+	MoonIllumination int    `json:"moon_illumination"`
+}
+
+// History is the weather of a past day in the city of a CEP.
+type History struct {
+	City     string  `json:"city"`
+	Date     string  `json:"date"` // YYYY-MM-DD
+	MinTempC float64 `json:"min_temp_C"`
+	MaxTempC float64 `json:"max_temp_C"`
+	AvgTempC float64 `json:"avg_temp_C"`
+
+	// Condition is the day's weather as described by WeatherAPI; stored
+	// observations don't have one.
+	Condition string `json:"condition,omitempty"`
+
+	// Source is where the data comes from: "weatherapi", or "observations"
+	// when it was rebuilt from the temperatures served that day.
+	Source string        `json:"source"`
+	Hours  []HistoryHour `json:"hours"`
+}
+
+// HistoryHour is a temperature reading of the day.
+type HistoryHour struct {
+	Time  string  `json:"time"` // HH:MM
+	TempC float64 `json:"temp_C"`
+}
+
+// ErrNonFinite is returned by Validate for values JSON cannot carry.
+var ErrNonFinite = errors.New("response contains a non-finite number")
+
+func (t Temperature) Validate() error {
+	if !Finite(t.TempC, t.TempF, t.TempK) {
+		return ErrNonFinite
+	}
+	if d := t.Details; d != nil && !Finite(d.FeelsLikeC, d.FeelsLikeF, d.WindKph) {
+		return ErrNonFinite
+	}
+	return nil
+}
+
+func (f Forecast) Validate() error {
+	for _, d := range f.Days {
+		if !Finite(d.MinTempC, d.MaxTempC, d.MinTempF, d.MaxTempF) {
+			return ErrNonFinite
+		}
+	}
+	return nil
+}
+
+func (a AirQuality) Validate() error {
+	if !Finite(a.PM25, a.PM10) {
+		return ErrNonFinite
+	}
+	return nil
+}
+
+func (h History) Validate() error {
+	if !Finite(h.MinTempC, h.MaxTempC, h.AvgTempC) {
+		return ErrNonFinite
+	}
+	for _, hour := range h.Hours {
+		if !Finite(hour.TempC) {
+			return ErrNonFinite
+		}
+	}
+	return nil
+}
+
+// Finite reports whether every value can be represented in JSON.
+func Finite(values ...float64) bool {
+	for _, v := range values {
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,62 @@
+package api
+
+import (
+	"errors"
+	"math"
+	"testing"
+)
+
+func TestFinite(t *testing.T) {
+	tests := []struct {
+		name   string
+		values []float64
+		want   bool
+	}{
+		{"none", nil, true},
+		{"finite", []float64{0, -273.15, 1e308}, true},
+		{"nan", []float64{1, math.NaN()}, false},
+		{"positive infinity", []float64{math.Inf(1)}, false},
+		{"negative infinity", []float64{2, math.Inf(-1), 3}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Finite(tt.values...); got != tt.want {
+				t.Errorf("Finite(%v) = %v, want %v", tt.values, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	nan, inf := math.NaN(), math.Inf(1)
+	tests := []struct {
+		name    string
+		v       interface{ Validate() error }
+		wantErr bool
+	}{
+		{"temperature", Temperature{TempC: 25, TempF: 77, TempK: 298.15}, false},
+		{"temperature nan", Temperature{TempC: nan}, true},
+		{"temperature infinite kelvin", Temperature{TempK: inf}, true},
+		{"temperature details", Temperature{Details: &WeatherDetails{FeelsLikeC: 20, WindKph: 10}}, false},
+		{"temperature details nan", Temperature{Details: &WeatherDetails{WindKph: nan}}, true},
+		{"forecast", Forecast{Days: []ForecastDay{{MinTempC: 18, MaxTempC: 30}}}, false},
+		{"forecast no days", Forecast{}, false},
+		{"forecast infinite day", Forecast{Days: []ForecastDay{{}, {MaxTempF: -inf}}}, true},
+		{"air quality", AirQuality{PM25: 12.5, PM10: 20}, false},
+		{"air quality nan", AirQuality{PM10: nan}, true},
+		{"history", History{MinTempC: 18, MaxTempC: 30, AvgTempC: 24, Hours: []HistoryHour{{TempC: 22}}}, false},
+		{"history nan average", History{AvgTempC: nan}, true},
+		{"history infinite hour", History{Hours: []HistoryHour{{TempC: inf}}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.v.Validate()
+			if tt.wantErr != (err != nil) {
+				t.Fatalf("Validate() = %v, want error %v", err, tt.wantErr)
+			}
+			if err != nil && !errors.Is(err, ErrNonFinite) {
+				t.Errorf("Validate() = %v, want ErrNonFinite", err)
+			}
+		})
+	}
+}
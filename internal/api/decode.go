@@ -0,0 +1,84 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"strings"
+
+	"goexpert-lab-2-observabilidade/internal/apperr"
+)
+
+// DecodeJSON strictly decodes a JSON request body into v: bodies over the
+// limit set with http.MaxBytesReader, unknown fields and trailing data are
+// rejected. Decoder errors are kept as the cause, for logs, and reported to
+// the client as field-level messages instead.
+func DecodeJSON(r *http.Request, v any) error {
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+
+	err := dec.Decode(v)
+	if err == nil && dec.More() {
+		err = errTrailingData
+	}
+	if err == nil {
+		return nil
+	}
+
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return apperr.ErrBodyTooLarge.Wrap(err).WithFields(apperr.FieldError{
+			Field: "body", Message: fmt.Sprintf("must be at most %d bytes", tooLarge.Limit),
+		})
+	}
+	return apperr.ErrInvalidBody.Wrap(err).WithFields(decodeFieldError(err))
+}
+
+var errTrailingData = errors.New("unexpected data after the JSON value")
+
+// decodeFieldError turns a json decoder error into a message for the client.
+func decodeFieldError(err error) apperr.FieldError {
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	switch {
+	case errors.As(err, &typeErr) && typeErr.Field != "":
+		return apperr.FieldError{Field: typeErr.Field, Message: "must be " + jsonKind(typeErr.Type)}
+	case errors.As(err, &typeErr):
+		return apperr.FieldError{Field: "body", Message: "must be " + jsonKind(typeErr.Type)}
+	case errors.As(err, &syntaxErr):
+		return apperr.FieldError{Field: "body", Message: fmt.Sprintf("is not valid JSON (at byte %d)", syntaxErr.Offset)}
+	case errors.Is(err, io.EOF):
+		return apperr.FieldError{Field: "body", Message: "must not be empty"}
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		return apperr.FieldError{Field: "body", Message: "is truncated"}
+	case errors.Is(err, errTrailingData):
+		return apperr.FieldError{Field: "body", Message: "must hold a single JSON object"}
+	}
+	// the decoder has no typed error for unknown fields
+	if name, ok := strings.CutPrefix(err.Error(), "json: unknown field "); ok {
+		return apperr.FieldError{Field: strings.Trim(name, `"`), Message: "is not allowed"}
+	}
+	return apperr.FieldError{Field: "body", Message: "is invalid"}
+}
+
+// jsonKind names the JSON type a Go type decodes from, with its article.
+func jsonKind(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.String:
+		return "a string"
+	case reflect.Bool:
+		return "a boolean"
+	case reflect.Slice, reflect.Array:
+		return "an array"
+	case reflect.Map, reflect.Struct:
+		return "an object"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return "a number"
+	}
+	return "a " + t.String()
+}
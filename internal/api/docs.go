@@ -0,0 +1,27 @@
+package api
+
+import (
+	"bytes"
+	_ "embed"
+	"html/template"
+	"net/http"
+)
+
+// docsPage is Swagger UI pointed at /openapi.json; its assets are loaded
+// from a pinned swagger-ui-dist release on unpkg.
+//
+//go:embed docs.html
+var docsPage string
+
+var docsTemplate = template.Must(template.New("docs").Parse(docsPage))
+
+// Docs serves the interactive API docs of the service named service,
+// registered when ENABLE_DOCS is set.
+func Docs(service string) http.HandlerFunc {
+	var page bytes.Buffer
+	docsTemplate.Execute(&page, service)
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write(page.Bytes())
+	}
+}
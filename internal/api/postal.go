@@ -0,0 +1,76 @@
+package api
+
+import (
+	"regexp"
+	"slices"
+	"strings"
+
+	"goexpert-lab-2-observabilidade/internal/apperr"
+)
+
+// DefaultCountry is the country of postal codes sent without one, whose
+// postal codes are CEPs.
+const DefaultCountry = "BR"
+
+// postalFormat is the format of the postal codes of a country, once
+// normalized, with the rule told to clients that break it.
+type postalFormat struct {
+	pattern *regexp.Regexp
+	rule    string
+}
+
+var fiveDigits = postalFormat{regexp.MustCompile(`^\d{5}$`), "must be 5 digits"}
+
+// postalFormats are the countries postal codes are looked up for, by ISO
+// 3166-1 alpha-2 code: Brazil, with ViaCEP and the other CEP providers, and
+// the countries with single-format codes on Zippopotam.us.
+var postalFormats = map[string]postalFormat{
+	"BR": {regexp.MustCompile(`^\d{8}$`), "must be 8 digits"},
+	"DE": fiveDigits,
+	"ES": fiveDigits,
+	"FR": fiveDigits,
+	"IT": fiveDigits,
+	"MX": fiveDigits,
+	"US": fiveDigits,
+}
+
+// Countries lists the supported countries, sorted.
+func Countries() []string {
+	countries := make([]string, 0, len(postalFormats))
+	for c := range postalFormats {
+		countries = append(countries, c)
+	}
+	slices.Sort(countries)
+	return countries
+}
+
+// NormalizeCountry uppercases a country code, which defaults to DefaultCountry.
+func NormalizeCountry(country string) string {
+	if country = strings.ToUpper(strings.TrimSpace(country)); country == "" {
+		return DefaultCountry
+	}
+	return country
+}
+
+// ValidateCountry reports a normalized country postal codes aren't looked up
+// for as an invalid country parameter, for requests with several codes of
+// one country to be refused as a whole.
+func ValidateCountry(country string) error {
+	if _, ok := postalFormats[country]; !ok {
+		return apperr.ErrInvalidParam.WithFields(apperr.FieldError{Field: "country", Message: "must be one of " + strings.Join(Countries(), ", ")})
+	}
+	return nil
+}
+
+// ValidatePostalCode checks a normalized postal code against the format of
+// its normalized country, reporting unsupported countries as ValidateCountry
+// does and malformed codes as ErrInvalidZip with the rule of the country.
+func ValidatePostalCode(country, code string) error {
+	if err := ValidateCountry(country); err != nil {
+		return err
+	}
+	if format := postalFormats[country]; !format.pattern.MatchString(code) {
+		return apperr.ErrInvalidZip.WithFields(apperr.FieldError{Field: "cep", Message: format.rule})
+	}
+	return nil
+}
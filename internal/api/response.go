@@ -0,0 +1,49 @@
+package api
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+
+	"goexpert-lab-2-observabilidade/internal/admin"
+	"goexpert-lab-2-observabilidade/internal/apperr"
+	"goexpert-lab-2-observabilidade/internal/logging"
+)
+
+// Validator is implemented by response types that can detect values JSON cannot carry.
+type Validator interface {
+	Validate() error
+}
+
+// Error answers with err through apperr.WriteError, keeps a sample for
+// diagnostics and logs it, sampled per error type.
+func Error(w http.ResponseWriter, r *http.Request, err error) {
+	e := apperr.WriteError(w, r, err)
+	admin.RecentErrors.Record(r, e)
+	if ok, suppressed := logging.ErrorSampler.Allow(e.Type); ok {
+		slog.WarnContext(r.Context(), "request failed",
+			"type", e.Type, "status", e.Status, "error", e.Error(), "suppressed", suppressed)
+	}
+}
+
+// WriteJSON validates and encodes v fully before writing anything, so a broken
+// value never leaves a truncated body behind; it is answered through Error
+// as an ErrBadUpstreamPayload instead, the values having come from upstream.
+func WriteJSON(w http.ResponseWriter, r *http.Request, status int, v any) {
+	if val, ok := v.(Validator); ok {
+		if err := val.Validate(); err != nil {
+			Error(w, r, apperr.ErrBadUpstreamPayload.Wrap(err))
+			return
+		}
+	}
+
+	body, err := json.Marshal(v)
+	if err != nil {
+		Error(w, r, apperr.ErrBadUpstreamPayload.Wrap(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(append(body, '\n'))
+}
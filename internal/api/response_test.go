@@ -0,0 +1,115 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"goexpert-lab-2-observabilidade/internal/apperr"
+)
+
+// failingEncoder fails to marshal, like a value json can't encode.
+type failingEncoder struct{}
+
+func (failingEncoder) MarshalJSON() ([]byte, error) {
+	return nil, errors.New("encoder failed")
+}
+
+func TestWriteJSON(t *testing.T) {
+	if err := apperr.SetFormat(apperr.FormatProblem); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { apperr.SetFormat(apperr.FormatText) })
+
+	tests := []struct {
+		name     string
+		status   int
+		v        any
+		wantBody string
+	}{
+		{"valid", http.StatusOK, Temperature{City: "Rio de Janeiro", TempC: 25, TempF: 77, TempK: 298}, ""},
+		{"status kept", http.StatusCreated, map[string]string{"id": "1"}, `{"id":"1"}` + "\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			WriteJSON(rec, httptest.NewRequest(http.MethodGet, "/", nil), tt.status, tt.v)
+
+			if rec.Code != tt.status {
+				t.Errorf("status = %d, want %d", rec.Code, tt.status)
+			}
+			if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+				t.Errorf("Content-Type = %q, want application/json", ct)
+			}
+			if tt.wantBody != "" && rec.Body.String() != tt.wantBody {
+				t.Errorf("body = %q, want %q", rec.Body.String(), tt.wantBody)
+			}
+			if !json.Valid(rec.Body.Bytes()) {
+				t.Errorf("body %q is not valid JSON", rec.Body.String())
+			}
+		})
+	}
+}
+
+func TestWriteJSONBadPayload(t *testing.T) {
+	if err := apperr.SetFormat(apperr.FormatProblem); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { apperr.SetFormat(apperr.FormatText) })
+
+	tests := []struct {
+		name       string
+		v          any
+		lang       string
+		wantLang   string
+		wantTitle  string
+		wantDetail string
+	}{
+		{"validator nan", Temperature{TempC: math.NaN()}, "", "en", "invalid upstream response", ErrNonFinite.Error()},
+		{"validator infinity", Forecast{Days: []ForecastDay{{MaxTempC: math.Inf(1)}}}, "en-US", "en", "invalid upstream response", ErrNonFinite.Error()},
+		{"plain nan", map[string]float64{"temp_C": math.NaN()}, "pt-BR", "pt-BR", "resposta inválida do serviço externo", "unsupported value"},
+		{"plain infinity", []float64{math.Inf(-1)}, "en;q=0.5, pt;q=0.9", "pt-BR", "resposta inválida do serviço externo", "unsupported value"},
+		{"failing encoder", failingEncoder{}, "fr", "en", "invalid upstream response", "encoder failed"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.lang != "" {
+				r.Header.Set("Accept-Language", tt.lang)
+			}
+			rec := httptest.NewRecorder()
+			WriteJSON(rec, r, http.StatusOK, tt.v)
+
+			if rec.Code != http.StatusBadGateway {
+				t.Errorf("status = %d, want %d", rec.Code, http.StatusBadGateway)
+			}
+			if ct := rec.Header().Get("Content-Type"); ct != "application/problem+json" {
+				t.Errorf("Content-Type = %q, want application/problem+json", ct)
+			}
+			if typ := rec.Header().Get(apperr.TypeHeader); typ != "decode_error" {
+				t.Errorf("%s = %q, want decode_error", apperr.TypeHeader, typ)
+			}
+			if lang := rec.Header().Get("Content-Language"); lang != tt.wantLang {
+				t.Errorf("Content-Language = %q, want %q", lang, tt.wantLang)
+			}
+
+			var p apperr.Problem
+			if err := json.Unmarshal(rec.Body.Bytes(), &p); err != nil {
+				t.Fatalf("body %q is not a problem: %v", rec.Body.String(), err)
+			}
+			if p.Type != "/errors/decode_error" || p.Status != http.StatusBadGateway {
+				t.Errorf("type, status = %q, %d, want /errors/decode_error, %d", p.Type, p.Status, http.StatusBadGateway)
+			}
+			if p.Title != tt.wantTitle {
+				t.Errorf("title = %q, want %q", p.Title, tt.wantTitle)
+			}
+			if !strings.Contains(p.Detail, tt.wantDetail) {
+				t.Errorf("detail = %q, want it to contain %q", p.Detail, tt.wantDetail)
+			}
+		})
+	}
+}
@@ -0,0 +1,342 @@
+// Package apperr defines the typed errors shared by both services and the
+// single helper that turns them into HTTP responses, so status codes,
+// messages, metrics and span statuses stay consistent.
+package apperr
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// TypeHeader carries the error type on error responses, so service-a can
+// re-raise the exact error service-b reported.
+const TypeHeader = "X-Error-Type"
+
+// Error is an application error with a stable type, status and message.
+type Error struct {
+	Type    string
+	Status  int
+	Message string
+
+	parent     *Error
+	cause      error
+	fields     []FieldError
+	retryAfter time.Duration
+}
+
+// FieldError is a problem with one field of a request, safe to show to clients.
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+func (f FieldError) Error() string {
+	return f.Field + " " + f.Message
+}
+
+func newError(typ string, status int, msg string) *Error {
+	e := &Error{Type: typ, Status: status, Message: msg}
+	known[key(typ, status)] = e
+	return e
+}
+
+func key(typ string, status int) string {
+	return fmt.Sprintf("%s/%d", typ, status)
+}
+
+// derive creates a more specific error that still matches e with errors.Is.
+func (e *Error) derive(typ, msg string) *Error {
+	d := newError(typ, e.Status, msg)
+	d.parent = e
+	return d
+}
+
+// known indexes the sentinels by type and status.
+var known = map[string]*Error{}
+
+var (
+	ErrInvalidZip          = newError("invalid_zipcode", http.StatusPreconditionFailed, "invalid zipcode")
+	ErrZipNotFound         = newError("zipcode_not_found", http.StatusNotFound, "can not find zipcode")
+	ErrCityNotFound        = newError("city_not_found", http.StatusNotFound, "can not find city")
+	ErrHistoryNotFound     = newError("history_not_found", http.StatusNotFound, "no weather history for that date")
+	ErrInvalidBody         = newError("decode_error", http.StatusBadRequest, "invalid request body")
+	ErrInvalidParam        = newError("invalid_parameter", http.StatusBadRequest, "invalid query parameter")
+	ErrInvalidQuery        = newError("invalid_query", http.StatusBadRequest, "invalid GraphQL query")
+	ErrUnauthorized        = newError("unauthorized", http.StatusUnauthorized, "unauthorized")
+	ErrUpstreamTimeout     = newError("timeout", http.StatusGatewayTimeout, "upstream timed out")
+	ErrUpstreamUnavailable = newError("upstream_unavailable", http.StatusServiceUnavailable, "upstream unavailable")
+	ErrOriginNotAllowed    = newError("origin_not_allowed", http.StatusForbidden, "origin not allowed")
+	ErrInternal            = newError("internal_error", http.StatusInternalServerError, "internal server error")
+	ErrBatchTooLarge       = newError("batch_too_large", http.StatusRequestEntityTooLarge, "too many CEPs in batch")
+	ErrBodyTooLarge        = newError("body_too_large", http.StatusRequestEntityTooLarge, "request body too large")
+	ErrMethodNotAllowed    = newError("method_not_allowed", http.StatusMethodNotAllowed, "method not allowed")
+	ErrNotAcceptable       = newError("not_acceptable", http.StatusNotAcceptable, "no acceptable response format")
+	ErrRateLimited         = newError("rate_limited", http.StatusTooManyRequests, "too many requests")
+	ErrFeatureShed         = newError("feature_unavailable", http.StatusServiceUnavailable, "temporarily unavailable under load")
+	ErrUpgradeRequired     = newError("upgrade_required", http.StatusUpgradeRequired, "WebSocket upgrade required")
+	ErrWebhookNotFound     = newError("webhook_not_found", http.StatusNotFound, "webhook not found")
+	ErrWebhookLimit        = newError("webhook_limit", http.StatusConflict, "too many webhooks registered")
+
+	ErrViaCEP        = ErrUpstreamUnavailable.derive("viacep_error", "failed to get location info")
+	ErrWeatherAPI    = ErrUpstreamUnavailable.derive("weatherapi_error", "failed to get weather info")
+	ErrPostalCodeAPI = ErrUpstreamUnavailable.derive("zippopotam_error", "failed to get postal code info")
+
+	// ErrCircuitOpen is returned without calling a dependency whose circuit
+	// breaker is open, with a Retry-After of when it will be tried again.
+	ErrCircuitOpen = ErrUpstreamUnavailable.derive("circuit_open", "dependency temporarily unavailable")
+	// ErrBulkheadFull is returned without calling a dependency that already
+	// has as many calls in flight as it is allowed.
+	ErrBulkheadFull = ErrUpstreamUnavailable.derive("bulkhead_full", "too many concurrent calls to a dependency")
+
+	// WeatherAPI's quota running out and it rejecting the key are told apart
+	// from outages: the first passes once the quota resets, the second needs
+	// the configuration fixed.
+	ErrWeatherQuota        = newError("weatherapi_quota_exceeded", http.StatusTooManyRequests, "weather provider quota exceeded")
+	ErrWeatherUnauthorized = newError("weatherapi_unauthorized", http.StatusBadGateway, "weather provider rejected the API key")
+
+	// ErrBadUpstreamPayload shares the decode_error type with ErrInvalidBody,
+	// but the fault lies upstream, hence the 502.
+	ErrBadUpstreamPayload = newError("decode_error", http.StatusBadGateway, "invalid upstream response")
+)
+
+func (e *Error) Error() string {
+	if e.cause != nil {
+		return e.Message + ": " + e.cause.Error()
+	}
+	return e.Message
+}
+
+func (e *Error) Unwrap() error {
+	return e.cause
+}
+
+// Is matches the sentinel e was created from and all of its ancestors.
+func (e *Error) Is(target error) bool {
+	t, ok := target.(*Error)
+	if !ok {
+		return false
+	}
+	for cur := e; cur != nil; cur = cur.parent {
+		if cur == t {
+			return true
+		}
+	}
+	return false
+}
+
+// Wrap returns a copy of e carrying cause.
+func (e *Error) Wrap(cause error) *Error {
+	c := *e
+	c.parent = e
+	c.cause = cause
+	return &c
+}
+
+// WithFields returns a copy of e reporting which request fields were invalid.
+func (e *Error) WithFields(fields ...FieldError) *Error {
+	c := *e
+	c.parent = e
+	c.fields = fields
+	return &c
+}
+
+// Fields returns the field-level problems attached with WithFields.
+func (e *Error) Fields() []FieldError {
+	return e.fields
+}
+
+// WithRetryAfter returns a copy of e telling clients to retry after d, in
+// Retry-After.
+func (e *Error) WithRetryAfter(d time.Duration) *Error {
+	c := *e
+	c.parent = e
+	c.retryAfter = d
+	return &c
+}
+
+// RetryAfter returns the delay attached with WithRetryAfter, or zero.
+func (e *Error) RetryAfter() time.Duration {
+	return e.retryAfter
+}
+
+// FromResponse rebuilds the error reported by an upstream service response
+// through TypeHeader, with its Retry-After, falling back to
+// ErrUpstreamUnavailable.
+func FromResponse(resp *http.Response) *Error {
+	if e, ok := known[key(resp.Header.Get(TypeHeader), resp.StatusCode)]; ok {
+		if secs, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && secs > 0 {
+			return e.WithRetryAfter(time.Duration(secs) * time.Second)
+		}
+		return e
+	}
+	return ErrUpstreamUnavailable.Wrap(fmt.Errorf("upstream responded with status %d", resp.StatusCode))
+}
+
+// Upstream maps a failed upstream call to an Error: errors that already are
+// one, such as ErrCircuitOpen, are kept, timeouts and malformed payloads are
+// recognised next, and anything else becomes target.
+func Upstream(target *Error, err error) *Error {
+	var appErr *Error
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	switch {
+	case errors.As(err, &appErr):
+		return appErr
+	case IsTimeout(err):
+		return ErrUpstreamTimeout.Wrap(err)
+	case errors.As(err, &syntaxErr), errors.As(err, &typeErr), errors.Is(err, io.ErrUnexpectedEOF):
+		return ErrBadUpstreamPayload.Wrap(err)
+	}
+	return target.Wrap(err)
+}
+
+// IsTimeout reports whether err is a deadline or network timeout.
+func IsTimeout(err error) bool {
+	var netErr net.Error
+	return errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout())
+}
+
+// From returns err as an Error, treating unknown errors as unavailable upstreams.
+func From(err error) *Error {
+	var e *Error
+	if errors.As(err, &e) {
+		return e
+	}
+	return ErrUpstreamUnavailable.Wrap(err)
+}
+
+var errorsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "app_errors_total",
+	Help: "Errors returned to clients, by error type.",
+}, []string{"type"})
+
+// start every series at zero so rates work before the first error
+func init() {
+	for _, e := range known {
+		errorsTotal.WithLabelValues(e.Type)
+	}
+}
+
+// Types returns every error type, sorted, e.g. to document them.
+func Types() []string {
+	seen := make(map[string]bool)
+	var types []string
+	for _, e := range known {
+		if !seen[e.Type] {
+			seen[e.Type] = true
+			types = append(types, e.Type)
+		}
+	}
+	sort.Strings(types)
+	return types
+}
+
+// Collector returns the error metrics, for registration in the service registry.
+func Collector() prometheus.Collector {
+	return errorsTotal
+}
+
+// Response body formats for WriteError.
+const (
+	FormatText    = "text"
+	FormatProblem = "problem"
+)
+
+var format = FormatText
+
+// SetFormat selects the body WriteError answers with: plain text (the
+// default) or RFC 7807 application/problem+json.
+func SetFormat(f string) error {
+	switch f {
+	case FormatText, FormatProblem:
+		format = f
+		return nil
+	}
+	return fmt.Errorf("unknown error format %q", f)
+}
+
+// Problem is an RFC 7807 problem details body.
+type Problem struct {
+	Type     string `json:"type"`
+	Title    string `json:"title"`
+	Status   int    `json:"status"`
+	Detail   string `json:"detail,omitempty"`
+	Instance string `json:"instance,omitempty"`
+
+	// Errors lists the invalid request fields, when known.
+	Errors []FieldError `json:"errors,omitempty"`
+}
+
+func (e *Error) problem(sc trace.SpanContext, lang string) Problem {
+	p := Problem{Type: "/errors/" + e.Type, Title: e.Localized(lang), Status: e.Status, Errors: e.fields}
+	switch {
+	case len(e.fields) > 0:
+		// the field messages stand in for the cause, which may be a raw decoder error
+		msgs := make([]string, len(e.fields))
+		for i, f := range e.fields {
+			msgs[i] = f.Error()
+		}
+		p.Detail = strings.Join(msgs, "; ")
+	case e.cause != nil:
+		p.Detail = e.cause.Error()
+	}
+	if sc.HasTraceID() {
+		p.Instance = "urn:trace:" + sc.TraceID().String()
+	}
+	return p
+}
+
+// Record counts the error and records it on the span in ctx, for errors that
+// are reported inside a larger response rather than written on their own.
+func Record(ctx context.Context, err error) *Error {
+	e := From(err)
+	errorsTotal.WithLabelValues(e.Type).Inc()
+
+	span := trace.SpanFromContext(ctx)
+	span.SetAttributes(attribute.String("app.error.type", e.Type))
+	span.RecordError(e)
+	if e.Status >= http.StatusInternalServerError {
+		span.SetStatus(codes.Error, e.Message)
+	}
+	return e
+}
+
+// WriteError is the single place errors are turned into responses: it counts
+// the error, records it on the active span and writes the status and body, in
+// the language asked for with Accept-Language.
+func WriteError(w http.ResponseWriter, r *http.Request, err error) *Error {
+	e := Record(r.Context(), err)
+	span := trace.SpanFromContext(r.Context())
+	lang := Language(r)
+
+	w.Header().Set(TypeHeader, e.Type)
+	if e.retryAfter > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(e.retryAfter.Seconds()))))
+	}
+	w.Header().Set("Content-Language", lang)
+	w.Header().Add("Vary", "Accept-Language")
+	if format != FormatProblem {
+		http.Error(w, e.Localized(lang), e.Status)
+		return e
+	}
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(e.Status)
+	json.NewEncoder(w).Encode(e.problem(span.SpanContext(), lang))
+	return e
+}
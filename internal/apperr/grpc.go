@@ -0,0 +1,95 @@
+package apperr
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/protoadapt"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+// Domain is the ErrorInfo domain of the errors sent over gRPC.
+const Domain = "goexpert-lab-2-observabilidade"
+
+// grpcCodes maps the HTTP statuses of the sentinels to gRPC codes.
+var grpcCodes = map[int]codes.Code{
+	http.StatusBadRequest:            codes.InvalidArgument,
+	http.StatusUnauthorized:          codes.Unauthenticated,
+	http.StatusForbidden:             codes.PermissionDenied,
+	http.StatusNotFound:              codes.NotFound,
+	http.StatusMethodNotAllowed:      codes.Unimplemented,
+	http.StatusNotAcceptable:         codes.InvalidArgument,
+	http.StatusConflict:              codes.Aborted,
+	http.StatusPreconditionFailed:    codes.InvalidArgument,
+	http.StatusRequestEntityTooLarge: codes.ResourceExhausted,
+	http.StatusUpgradeRequired:       codes.FailedPrecondition,
+	http.StatusTooManyRequests:       codes.ResourceExhausted,
+	http.StatusInternalServerError:   codes.Internal,
+	http.StatusBadGateway:            codes.Unavailable,
+	http.StatusServiceUnavailable:    codes.Unavailable,
+	http.StatusGatewayTimeout:        codes.DeadlineExceeded,
+}
+
+// GRPCStatus lets gRPC servers return an Error as is. The type and status
+// travel in an ErrorInfo, so FromStatus can rebuild the exact error, as
+// TypeHeader does over HTTP, and the retry delay in a RetryInfo.
+func (e *Error) GRPCStatus() *status.Status {
+	code, ok := grpcCodes[e.Status]
+	if !ok {
+		code = codes.Unknown
+	}
+	st := status.New(code, e.Error())
+	details := []protoadapt.MessageV1{&errdetails.ErrorInfo{
+		Reason:   e.Type,
+		Domain:   Domain,
+		Metadata: map[string]string{"status": strconv.Itoa(e.Status)},
+	}}
+	if e.retryAfter > 0 {
+		details = append(details, &errdetails.RetryInfo{RetryDelay: durationpb.New(e.retryAfter)})
+	}
+	if d, err := st.WithDetails(details...); err == nil {
+		st = d
+	}
+	return st
+}
+
+// FromStatus rebuilds the error reported by a failed gRPC call, with the delay
+// of its RetryInfo, falling back
+// to ErrUpstreamTimeout or ErrUpstreamUnavailable for errors of the transport.
+func FromStatus(err error) *Error {
+	st, ok := status.FromError(err)
+	if !ok {
+		return Upstream(ErrUpstreamUnavailable, err)
+	}
+	var found *Error
+	var retryAfter time.Duration
+	for _, d := range st.Details() {
+		switch d := d.(type) {
+		case *errdetails.ErrorInfo:
+			if d.GetDomain() != Domain {
+				continue
+			}
+			s, _ := strconv.Atoi(d.GetMetadata()["status"])
+			if e, ok := known[key(d.GetReason(), s)]; ok {
+				found = e
+			}
+		case *errdetails.RetryInfo:
+			retryAfter = d.GetRetryDelay().AsDuration()
+		}
+	}
+	if found != nil {
+		if retryAfter > 0 {
+			return found.WithRetryAfter(retryAfter)
+		}
+		return found
+	}
+	if st.Code() == codes.DeadlineExceeded {
+		return ErrUpstreamTimeout.Wrap(errors.New(st.Message()))
+	}
+	return ErrUpstreamUnavailable.Wrap(err)
+}
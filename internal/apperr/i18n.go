@@ -0,0 +1,85 @@
+package apperr
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Languages error messages are available in. English is the default and is
+// what the sentinels carry; the others are looked up in translations.
+const (
+	LangEnglish    = "en"
+	LangPortuguese = "pt-BR"
+)
+
+// translations holds the messages for each language other than English,
+// by error type and status like known.
+var translations = map[string]map[string]string{
+	LangPortuguese: {
+		key("invalid_zipcode", http.StatusPreconditionFailed):        "CEP inválido",
+		key("zipcode_not_found", http.StatusNotFound):                "CEP não encontrado",
+		key("city_not_found", http.StatusNotFound):                   "cidade não encontrada",
+		key("history_not_found", http.StatusNotFound):                "sem histórico do clima para essa data",
+		key("decode_error", http.StatusBadRequest):                   "corpo da requisição inválido",
+		key("invalid_parameter", http.StatusBadRequest):              "parâmetro de consulta inválido",
+		key("invalid_query", http.StatusBadRequest):                  "consulta GraphQL inválida",
+		key("unauthorized", http.StatusUnauthorized):                 "não autorizado",
+		key("origin_not_allowed", http.StatusForbidden):              "origem não permitida",
+		key("timeout", http.StatusGatewayTimeout):                    "o serviço externo não respondeu a tempo",
+		key("upstream_unavailable", http.StatusServiceUnavailable):   "serviço externo indisponível",
+		key("internal_error", http.StatusInternalServerError):        "erro interno do servidor",
+		key("batch_too_large", http.StatusRequestEntityTooLarge):     "CEPs demais no lote",
+		key("body_too_large", http.StatusRequestEntityTooLarge):      "corpo da requisição grande demais",
+		key("method_not_allowed", http.StatusMethodNotAllowed):       "método não permitido",
+		key("not_acceptable", http.StatusNotAcceptable):              "nenhum formato de resposta aceitável",
+		key("rate_limited", http.StatusTooManyRequests):              "muitas requisições",
+		key("feature_unavailable", http.StatusServiceUnavailable):    "temporariamente indisponível devido à carga",
+		key("upgrade_required", http.StatusUpgradeRequired):          "é necessário usar WebSocket",
+		key("webhook_not_found", http.StatusNotFound):                "webhook não encontrado",
+		key("webhook_limit", http.StatusConflict):                    "webhooks registrados demais",
+		key("viacep_error", http.StatusServiceUnavailable):           "falha ao obter informações de localização",
+		key("weatherapi_error", http.StatusServiceUnavailable):       "falha ao obter informações do clima",
+		key("zippopotam_error", http.StatusServiceUnavailable):       "falha ao obter informações do código postal",
+		key("circuit_open", http.StatusServiceUnavailable):           "dependência temporariamente indisponível",
+		key("bulkhead_full", http.StatusServiceUnavailable):          "chamadas simultâneas demais a uma dependência",
+		key("weatherapi_quota_exceeded", http.StatusTooManyRequests): "cota do provedor de clima esgotada",
+		key("weatherapi_unauthorized", http.StatusBadGateway):        "o provedor de clima recusou a chave de API",
+		key("decode_error", http.StatusBadGateway):                   "resposta inválida do serviço externo",
+	},
+}
+
+// Language picks the message language for the Accept-Language header of r:
+// the highest q value wins, any Portuguese tag selects pt-BR and anything
+// else falls back to English.
+func Language(r *http.Request) string {
+	lang, bestQ := LangEnglish, 0.0
+	for _, part := range strings.Split(r.Header.Get("Accept-Language"), ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		q := 1.0
+		if v, found := strings.CutPrefix(strings.TrimSpace(params), "q="); found {
+			var err error
+			if q, err = strconv.ParseFloat(v, 64); err != nil {
+				continue
+			}
+		}
+		if q <= bestQ {
+			continue
+		}
+		switch primary, _, _ := strings.Cut(strings.ToLower(tag), "-"); primary {
+		case "pt":
+			lang, bestQ = LangPortuguese, q
+		case "en":
+			lang, bestQ = LangEnglish, q
+		}
+	}
+	return lang
+}
+
+// Localized returns the message of e in lang, falling back to English.
+func (e *Error) Localized(lang string) string {
+	if msg, ok := translations[lang][key(e.Type, e.Status)]; ok {
+		return msg
+	}
+	return e.Message
+}
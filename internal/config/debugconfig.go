@@ -0,0 +1,73 @@
+package config
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+// redacted replaces the value of secret settings.
+const redacted = "REDACTED"
+
+// isSecret reports whether key holds a credential; paths to secret files are
+// not secrets themselves.
+func isSecret(key string) bool {
+	if strings.HasSuffix(key, "_FILE") {
+		return false
+	}
+	for _, marker := range []string{"SECRET", "PASSWORD", "TOKEN", "API_KEY"} {
+		if strings.Contains(key, marker) {
+			return true
+		}
+	}
+	return false
+}
+
+// redact masks secret settings and the password of URLs with credentials.
+func redact(key string, value any) any {
+	s, ok := value.(string)
+	if !ok || s == "" {
+		return value
+	}
+	if isSecret(key) {
+		return redacted
+	}
+	if u, err := url.Parse(s); err == nil && u.User != nil {
+		if _, hasPassword := u.User.Password(); hasPassword {
+			u.User = url.UserPassword(u.User.Username(), redacted)
+			return u.String()
+		}
+	}
+	return value
+}
+
+// effectiveConfig resolves every known setting after merging flags,
+// environment, config files and defaults, with secrets masked. viper only
+// knows keys that have a default or come from a file or flag, so the
+// settings without a default are passed as unset, to show up in the dump
+// even when unset.
+func effectiveConfig(unset []string) map[string]any {
+	mu.RLock()
+	defer mu.RUnlock()
+	config := make(map[string]any)
+	for _, key := range viper.AllKeys() {
+		key = strings.ToUpper(key)
+		config[key] = redact(key, viper.Get(key))
+	}
+	for _, key := range unset {
+		config[key] = redact(key, viper.Get(key))
+	}
+	return config
+}
+
+// Handler dumps the effective configuration as JSON, unset including the
+// settings without a default.
+func Handler(unset []string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(effectiveConfig(unset))
+	}
+}
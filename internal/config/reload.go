@@ -0,0 +1,134 @@
+package config
+
+import (
+	"fmt"
+	"log/slog"
+	"path/filepath"
+	"sync"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/spf13/viper"
+)
+
+// reloadSetting re-applies a group of settings when any of their keys changes.
+type reloadSetting struct {
+	keys  []string
+	last  string
+	apply func()
+}
+
+// reloadRegistry holds the settings that may change without a restart. Only
+// non-critical settings belong here; everything else is read once at startup.
+type reloadRegistry struct {
+	mu       sync.Mutex
+	settings []*reloadSetting
+}
+
+var reloadable reloadRegistry
+
+func settingValues(keys []string) string {
+	values := make([]any, len(keys))
+	for i, key := range keys {
+		values[i] = viper.Get(key)
+	}
+	return fmt.Sprint(values...)
+}
+
+// OnChange registers apply to run after a reload that changed any of keys.
+func OnChange(apply func(), keys ...string) {
+	mu.RLock()
+	defer mu.RUnlock()
+	reloadable.OnChange(apply, keys...)
+}
+
+// OnChange registers apply to run after a reload that changed any of keys.
+func (r *reloadRegistry) OnChange(apply func(), keys ...string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.settings = append(r.settings, &reloadSetting{keys: keys, last: settingValues(keys), apply: apply})
+}
+
+// reload re-applies every setting whose value changed since it was last applied.
+func (r *reloadRegistry) reload() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, s := range r.settings {
+		if current := settingValues(s.keys); current != s.last {
+			s.apply()
+			s.last = current
+			slog.Warn("configuration reloaded", "keys", s.keys)
+		}
+	}
+}
+
+// mu serializes viper: reloads write its maps from the watcher goroutine, so
+// anything reading viper after Watch must go through Read.
+var mu sync.RWMutex
+
+// Read runs fn with viper safe to read against a concurrent reload. Apply
+// funcs registered with OnChange already run under the lock and must not call it.
+func Read(fn func()) {
+	mu.RLock()
+	defer mu.RUnlock()
+	fn()
+}
+
+// Watch re-reads the config files, in merge order, whenever the last one
+// loaded changes and re-applies the reloadable settings. Flags and
+// environment variables keep their precedence over the edited values.
+func Watch(configFiles []string) {
+	if len(configFiles) == 0 {
+		return
+	}
+	watched := filepath.Clean(configFiles[len(configFiles)-1])
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		slog.Error("failed to watch config file", "path", watched, "error", err)
+		return
+	}
+	// watch the directory, not the file: editors and ConfigMap updates
+	// replace the file, which would end a watch on the file itself
+	if err := watcher.Add(filepath.Dir(watched)); err != nil {
+		slog.Error("failed to watch config file", "path", watched, "error", err)
+		watcher.Close()
+		return
+	}
+	target, _ := filepath.EvalSymlinks(watched)
+	go func() {
+		defer watcher.Close()
+		for {
+			select {
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				current, _ := filepath.EvalSymlinks(watched)
+				written := filepath.Clean(event.Name) == watched && event.Has(fsnotify.Write|fsnotify.Create)
+				if !written && (current == "" || current == target) {
+					continue
+				}
+				target = current
+				reloadFiles(configFiles)
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				slog.Error("config watcher failed", "path", watched, "error", err)
+			}
+		}
+	}()
+}
+
+// reloadFiles merges configFiles back in order and re-applies the settings
+// that changed, with readers held off until both are done.
+func reloadFiles(configFiles []string) {
+	mu.Lock()
+	defer mu.Unlock()
+	for _, path := range configFiles {
+		viper.SetConfigFile(path)
+		if err := viper.MergeInConfig(); err != nil {
+			slog.Error("failed to reload config file", "path", path, "error", err)
+		}
+	}
+	reloadable.reload()
+}
@@ -0,0 +1,205 @@
+// Package degradation derives how much optional work a service sheds from
+// its own latency and the error ratios of its dependencies, so expensive
+// features are turned off before the core lookup suffers.
+package degradation
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/spf13/viper"
+
+	"goexpert-lab-2-observabilidade/internal/middleware"
+)
+
+// Level describes how much optional work the service is shedding.
+type Level int
+
+const (
+	Normal Level = iota
+	Degraded
+	Critical
+)
+
+func (l Level) String() string {
+	switch l {
+	case Degraded:
+		return "degraded"
+	case Critical:
+		return "critical"
+	default:
+		return "normal"
+	}
+}
+
+// Feature is an expensive, optional capability that can be turned off under pressure.
+type Feature string
+
+// ewmaAlpha weights the newest observation in the moving averages.
+const ewmaAlpha = 0.1
+
+const header = "X-Degradation-Level"
+
+var levelGauge = prometheus.NewGauge(prometheus.GaugeOpts{
+	Name: "degradation_level",
+	Help: "Current degradation level (0=normal, 1=degraded, 2=critical).",
+})
+
+// Collectors returns the metrics of the package, for the registry of a service.
+func Collectors() []prometheus.Collector {
+	return []prometheus.Collector{levelGauge}
+}
+
+// Config holds the thresholds of the levels.
+type Config struct {
+	DegradedLatency    time.Duration
+	CriticalLatency    time.Duration
+	DegradedErrorRatio float64
+	CriticalErrorRatio float64
+}
+
+// LoadConfig reads the thresholds from DEGRADED_LATENCY, CRITICAL_LATENCY,
+// DEGRADED_ERROR_RATIO and CRITICAL_ERROR_RATIO.
+func LoadConfig() Config {
+	return Config{
+		DegradedLatency:    viper.GetDuration("DEGRADED_LATENCY"),
+		CriticalLatency:    viper.GetDuration("CRITICAL_LATENCY"),
+		DegradedErrorRatio: viper.GetFloat64("DEGRADED_ERROR_RATIO"),
+		CriticalErrorRatio: viper.GetFloat64("CRITICAL_ERROR_RATIO"),
+	}
+}
+
+// Tracker tracks own latency and dependency health and derives the current level from them.
+type Tracker struct {
+	shedAt map[Feature]Level
+
+	mu        sync.Mutex
+	cfg       Config
+	latency   float64            // EWMA of request latency, in seconds
+	depErrors map[string]float64 // EWMA of the error ratio per dependency
+	level     Level
+}
+
+// New builds a Tracker with the thresholds of LoadConfig. shedAt is the
+// lowest level at which each feature gets disabled; features missing from it
+// are never shed.
+func New(shedAt map[Feature]Level) *Tracker {
+	return &Tracker{
+		shedAt:    shedAt,
+		cfg:       LoadConfig(),
+		depErrors: make(map[string]float64),
+	}
+}
+
+// Reconfigure swaps the thresholds; the level is re-derived on the next observation.
+func (d *Tracker) Reconfigure(cfg Config) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.cfg = cfg
+}
+
+// ObserveLatency feeds the duration of a served request into the latency average.
+func (d *Tracker) ObserveLatency(dur time.Duration) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.latency = ewma(d.latency, dur.Seconds())
+	d.recompute()
+}
+
+// ObserveDependency records the outcome of a call to the named dependency.
+func (d *Tracker) ObserveDependency(name string, ok bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	sample := 0.0
+	if !ok {
+		sample = 1
+	}
+	d.depErrors[name] = ewma(d.depErrors[name], sample)
+	d.recompute()
+}
+
+func (d *Tracker) recompute() {
+	worst := 0.0
+	for _, ratio := range d.depErrors {
+		if ratio > worst {
+			worst = ratio
+		}
+	}
+
+	level := Normal
+	switch {
+	case worst >= d.cfg.CriticalErrorRatio || d.latency >= d.cfg.CriticalLatency.Seconds():
+		level = Critical
+	case worst >= d.cfg.DegradedErrorRatio || d.latency >= d.cfg.DegradedLatency.Seconds():
+		level = Degraded
+	}
+
+	d.level = level
+	levelGauge.Set(float64(level))
+}
+
+// Level returns the current degradation level.
+func (d *Tracker) Level() Level {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.level
+}
+
+// Snapshot is the diagnostics section of a Tracker.
+type Snapshot struct {
+	Level          string             `json:"level"`
+	LatencySeconds float64            `json:"latency_ewma_seconds"`
+	ErrorRatios    map[string]float64 `json:"dependency_error_ratios"`
+}
+
+// Snapshot reports the inputs of the current level, i.e. dependency health.
+func (d *Tracker) Snapshot() Snapshot {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	ratios := make(map[string]float64, len(d.depErrors))
+	for name, ratio := range d.depErrors {
+		ratios[name] = ratio
+	}
+	return Snapshot{Level: d.level.String(), LatencySeconds: d.latency, ErrorRatios: ratios}
+}
+
+// Allows reports whether the feature may run at the current level.
+func (d *Tracker) Allows(f Feature) bool {
+	limit, ok := d.shedAt[f]
+	return !ok || d.Level() < limit
+}
+
+// CacheTTL stretches a cache TTL at higher levels, so cached entries are
+// revalidated less often while the service is under pressure.
+func (d *Tracker) CacheTTL(ttl time.Duration) time.Duration {
+	switch d.Level() {
+	case Degraded:
+		return ttl * 2
+	case Critical:
+		return ttl * 4
+	default:
+		return ttl
+	}
+}
+
+// Middleware exposes the current level as a response header and measures
+// request latency, leaving WebSocket connections out.
+func (d *Tracker) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		w.Header().Set(header, d.Level().String())
+		next.ServeHTTP(w, r)
+		if !middleware.IsWebSocketUpgrade(r) {
+			d.ObserveLatency(time.Since(start))
+		}
+	})
+}
+
+func ewma(current, sample float64) float64 {
+	return ewmaAlpha*sample + (1-ewmaAlpha)*current
+}
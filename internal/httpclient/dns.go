@@ -0,0 +1,172 @@
+package httpclient
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var (
+	dnsCacheLookups = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "dns_cache_lookups_total",
+		Help: "Host lookups of dials, by client and result (hit, miss, stale).",
+	}, []string{"client", "result"})
+
+	dnsResolutions = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "dns_resolution_duration_seconds",
+		Help:    "DNS resolutions of the cache, first lookups and refreshes, by client and result (ok, error).",
+		Buckets: []float64{.001, .005, .01, .025, .05, .1, .25, .5, 1, 2.5},
+	}, []string{"client", "result"})
+)
+
+// dnsResolveTimeout bounds each resolution, which outlives the dial that
+// started it so the other dials waiting for it aren't failed by its cancellation.
+const dnsResolveTimeout = 5 * time.Second
+
+// dnsCache resolves the hosts of a transport's dials, keeping their addresses
+// for a TTL. Expired addresses are still dialled while a single refresh runs
+// in the background, and kept when it fails, so a DNS outage or a slow
+// resolver doesn't reach the calls of hosts already resolved once. Hosts are
+// never evicted, which suits the few fixed upstreams of a service.
+type dnsCache struct {
+	name     string
+	ttl      time.Duration
+	resolver *net.Resolver
+
+	mu      sync.Mutex
+	entries map[string]*dnsEntry
+}
+
+type dnsEntry struct {
+	ready      chan struct{} // closed once the first resolution is done
+	addrs      []string
+	err        error // of the first resolution, when it failed
+	expires    time.Time
+	refreshing bool
+}
+
+func newDNSCache(name string, ttl time.Duration) *dnsCache {
+	return &dnsCache{name: name, ttl: ttl, resolver: net.DefaultResolver, entries: make(map[string]*dnsEntry)}
+}
+
+// dialContext wraps dial to connect to the cached addresses of the host of
+// addr, in turn, until one of them answers.
+func (c *dnsCache) dialContext(dial func(ctx context.Context, network, addr string) (net.Conn, error)) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		host, port, err := net.SplitHostPort(addr)
+		if err != nil || net.ParseIP(host) != nil {
+			return dial(ctx, network, addr)
+		}
+		addrs, err := c.lookup(ctx, host)
+		if err != nil {
+			return nil, err
+		}
+		var errs []error
+		for _, ip := range addrs {
+			conn, err := dial(ctx, network, net.JoinHostPort(ip, port))
+			if err == nil {
+				return conn, nil
+			}
+			errs = append(errs, err)
+			if ctx.Err() != nil {
+				break
+			}
+		}
+		return nil, errors.Join(errs...)
+	}
+}
+
+func (c *dnsCache) lookup(ctx context.Context, host string) ([]string, error) {
+	c.mu.Lock()
+	e, ok := c.entries[host]
+	if !ok {
+		e = &dnsEntry{ready: make(chan struct{})}
+		c.entries[host] = e
+		dnsCacheLookups.WithLabelValues(c.name, "miss").Inc()
+		go c.resolveFirst(ctx, host, e)
+	}
+	c.mu.Unlock()
+
+	select {
+	case <-e.ready:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e.err != nil {
+		return nil, e.err
+	}
+	if ok {
+		if time.Now().Before(e.expires) {
+			dnsCacheLookups.WithLabelValues(c.name, "hit").Inc()
+		} else {
+			dnsCacheLookups.WithLabelValues(c.name, "stale").Inc()
+			if !e.refreshing {
+				e.refreshing = true
+				go c.refresh(host, e)
+			}
+		}
+	}
+	return e.addrs, nil
+}
+
+// resolveFirst resolves a host seen for the first time, recording it on the
+// span of the dial; a failure isn't cached, so the next dial tries again.
+func (c *dnsCache) resolveFirst(ctx context.Context, host string, e *dnsEntry) {
+	start := time.Now()
+	addrs, err := c.resolve(context.WithoutCancel(ctx), host)
+	attrs := []attribute.KeyValue{
+		attribute.String("server.address", host),
+		attribute.Int("dns.addresses", len(addrs)),
+		attribute.Int64("dns.duration_ms", time.Since(start).Milliseconds()),
+	}
+	if err != nil {
+		attrs = append(attrs, attribute.String("error", err.Error()))
+	}
+	trace.SpanFromContext(ctx).AddEvent("dns lookup", trace.WithAttributes(attrs...))
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e.addrs, e.err, e.expires = addrs, err, time.Now().Add(c.ttl)
+	if err != nil {
+		delete(c.entries, host)
+	}
+	close(e.ready)
+}
+
+// refresh resolves an expired host again, keeping its addresses when that fails.
+func (c *dnsCache) refresh(host string, e *dnsEntry) {
+	addrs, err := c.resolve(context.Background(), host)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e.refreshing = false
+	if err == nil {
+		e.addrs, e.expires = addrs, time.Now().Add(c.ttl)
+	}
+}
+
+func (c *dnsCache) resolve(ctx context.Context, host string) ([]string, error) {
+	ctx, cancel := context.WithTimeout(ctx, dnsResolveTimeout)
+	defer cancel()
+
+	start := time.Now()
+	addrs, err := c.resolver.LookupHost(ctx, host)
+	if err == nil && len(addrs) == 0 {
+		err = &net.DNSError{Err: "no addresses", Name: host, IsNotFound: true}
+	}
+	result := "ok"
+	if err != nil {
+		result = "error"
+	}
+	dnsResolutions.WithLabelValues(c.name, result).Observe(time.Since(start).Seconds())
+	return addrs, err
+}
@@ -0,0 +1,152 @@
+// Package httpclient builds the transports of the outbound HTTP calls of both
+// services with the same connection pool and timeout defaults. Each service
+// builds its transports once at startup and shares them between requests, so
+// connections and TLS sessions are reused instead of dialled per call, and
+// every transport reports whether its calls got a pooled connection or a new
+// one, as metrics and span events, to tell pool exhaustion from slow
+// upstreams in latency spikes.
+package httpclient
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var connAcquire = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+	Name:    "http_client_conn_acquire_seconds",
+	Help:    "Time outbound calls took to get a connection, dial and TLS handshake included, by client and whether the connection was reused from the pool.",
+	Buckets: []float64{.0005, .001, .005, .01, .025, .05, .1, .25, .5, 1, 2.5},
+}, []string{"client", "reused"})
+
+// Collectors returns the connection, DNS cache and prewarm metrics, for
+// registration in the service registry.
+func Collectors() []prometheus.Collector {
+	return []prometheus.Collector{connAcquire, dnsCacheLookups, dnsResolutions, prewarmAttempts}
+}
+
+// Config is the shape of a transport; zero timeouts are unbounded.
+type Config struct {
+	// Name labels the metrics of the transport, e.g. "upstream"; it must be
+	// one of a few fixed names, never a host.
+	Name string
+
+	DialTimeout         time.Duration
+	TLSHandshakeTimeout time.Duration
+	MaxIdleConns        int           // idle connections kept in total; 0 keeps any number
+	MaxIdleConnsPerHost int           // idle connections kept per host
+	MaxConnsPerHost     int           // connections per host, calls past it wait for one; 0 is unbounded
+	IdleConnTimeout     time.Duration // how long an idle connection is kept
+	TLS                 *tls.Config   // nil for the system defaults
+
+	// DNSCacheTTL is how long the addresses of the hosts dialled are kept
+	// before they are resolved again; 0 resolves them on every dial.
+	DNSCacheTTL time.Duration
+
+	// DisableCompression stops the transport from asking for gzip and
+	// decompressing responses on its own.
+	DisableCompression bool
+
+	// Control, when set, vets every address dialled, after DNS resolution,
+	// and fails the dial with its error. Transports with one aren't proxied,
+	// since the proxy would dial the target unchecked.
+	Control func(network, address string, c syscall.RawConn) error
+}
+
+// Defaults returns the pool and timeout defaults shared by the services,
+// which then set their own dial and TLS handshake timeouts.
+func Defaults() Config {
+	return Config{
+		DialTimeout:         30 * time.Second,
+		TLSHandshakeTimeout: 10 * time.Second,
+		MaxIdleConns:        100,
+		MaxIdleConnsPerHost: 10,
+		IdleConnTimeout:     90 * time.Second,
+	}
+}
+
+// NewTransport builds an observed transport for cfg, proxied as set in the
+// environment. Every TLS config gets a session cache, so reconnections
+// resume sessions, and with DNSCacheTTL set the hosts dialled are resolved
+// through a cache.
+func NewTransport(cfg Config) http.RoundTripper {
+	tlsConfig := &tls.Config{}
+	if cfg.TLS != nil {
+		tlsConfig = cfg.TLS.Clone()
+	}
+	if tlsConfig.ClientSessionCache == nil {
+		tlsConfig.ClientSessionCache = tls.NewLRUClientSessionCache(0)
+	}
+	dial := (&net.Dialer{Timeout: cfg.DialTimeout, KeepAlive: 30 * time.Second, Control: cfg.Control}).DialContext
+	if cfg.DNSCacheTTL > 0 {
+		dial = newDNSCache(cfg.Name, cfg.DNSCacheTTL).dialContext(dial)
+	}
+	proxy := http.ProxyFromEnvironment
+	if cfg.Control != nil {
+		proxy = nil
+	}
+	return Observe(cfg.Name, &http.Transport{
+		Proxy:                 proxy,
+		DialContext:           dial,
+		ForceAttemptHTTP2:     true,
+		TLSClientConfig:       tlsConfig,
+		TLSHandshakeTimeout:   cfg.TLSHandshakeTimeout,
+		MaxIdleConns:          cfg.MaxIdleConns,
+		MaxIdleConnsPerHost:   cfg.MaxIdleConnsPerHost,
+		MaxConnsPerHost:       cfg.MaxConnsPerHost,
+		IdleConnTimeout:       cfg.IdleConnTimeout,
+		ExpectContinueTimeout: time.Second,
+		DisableCompression:    cfg.DisableCompression,
+	})
+}
+
+// Observe reports how the calls of base got their connections, on
+// http_client_conn_acquire_seconds{client} under name and as an
+// "http connection acquired" event on the span of each call.
+func Observe(name string, base http.RoundTripper) http.RoundTripper {
+	return observedTransport{base: base, name: name}
+}
+
+type observedTransport struct {
+	base http.RoundTripper
+	name string
+}
+
+func (t observedTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	ctx := r.Context()
+	var start time.Time
+	ct := &httptrace.ClientTrace{
+		GetConn: func(string) { start = time.Now() },
+		GotConn: func(info httptrace.GotConnInfo) { t.gotConn(ctx, r.URL.Host, time.Since(start), info) },
+	}
+	return t.base.RoundTrip(r.WithContext(httptrace.WithClientTrace(ctx, ct)))
+}
+
+func (t observedTransport) gotConn(ctx context.Context, host string, d time.Duration, info httptrace.GotConnInfo) {
+	connAcquire.WithLabelValues(t.name, strconv.FormatBool(info.Reused)).Observe(d.Seconds())
+	attrs := []attribute.KeyValue{
+		attribute.String("server.address", host),
+		attribute.Bool("http.connection.reused", info.Reused),
+		attribute.Int64("http.connection.acquire_ms", d.Milliseconds()),
+	}
+	if info.WasIdle {
+		attrs = append(attrs, attribute.Int64("http.connection.idle_ms", info.IdleTime.Milliseconds()))
+	}
+	trace.SpanFromContext(ctx).AddEvent("http connection acquired", trace.WithAttributes(attrs...))
+}
+
+// New builds a client on NewTransport that traces its calls and propagates
+// the trace context, bounding each call by timeout.
+func New(cfg Config, timeout time.Duration) *http.Client {
+	return &http.Client{Transport: otelhttp.NewTransport(NewTransport(cfg)), Timeout: timeout}
+}
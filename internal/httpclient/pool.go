@@ -0,0 +1,62 @@
+package httpclient
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// Pool is the connection pool of the outbound HTTP transports of a service.
+type Pool struct {
+	MaxIdle        int
+	MaxIdlePerHost int
+	MaxPerHost     int // 0 is unbounded
+	IdleTimeout    time.Duration
+}
+
+// LoadPool reads the pool from HTTP_MAX_IDLE_CONNS,
+// HTTP_MAX_IDLE_CONNS_PER_HOST, HTTP_MAX_CONNS_PER_HOST and
+// HTTP_IDLE_CONN_TIMEOUT.
+func LoadPool() Pool {
+	return Pool{
+		MaxIdle:        viper.GetInt("HTTP_MAX_IDLE_CONNS"),
+		MaxIdlePerHost: viper.GetInt("HTTP_MAX_IDLE_CONNS_PER_HOST"),
+		MaxPerHost:     viper.GetInt("HTTP_MAX_CONNS_PER_HOST"),
+		IdleTimeout:    viper.GetDuration("HTTP_IDLE_CONN_TIMEOUT"),
+	}
+}
+
+// Validate checks that none of the limits is negative.
+func (p Pool) Validate() error {
+	var errs []error
+	for _, s := range []struct {
+		key   string
+		value int
+	}{
+		{"HTTP_MAX_IDLE_CONNS", p.MaxIdle},
+		{"HTTP_MAX_IDLE_CONNS_PER_HOST", p.MaxIdlePerHost},
+		{"HTTP_MAX_CONNS_PER_HOST", p.MaxPerHost},
+	} {
+		if s.value < 0 {
+			errs = append(errs, fmt.Errorf("%s (%d) must not be negative", s.key, s.value))
+		}
+	}
+	if p.IdleTimeout < 0 {
+		errs = append(errs, fmt.Errorf("HTTP_IDLE_CONN_TIMEOUT (%s) must not be negative", p.IdleTimeout))
+	}
+	return errors.Join(errs...)
+}
+
+// Transport returns the Defaults of the transport named name, which labels
+// its metrics, with this pool.
+func (p Pool) Transport(name string) Config {
+	cfg := Defaults()
+	cfg.Name = name
+	cfg.MaxIdleConns = p.MaxIdle
+	cfg.MaxIdleConnsPerHost = p.MaxIdlePerHost
+	cfg.MaxConnsPerHost = p.MaxPerHost
+	cfg.IdleConnTimeout = p.IdleTimeout
+	return cfg
+}
@@ -0,0 +1,107 @@
+package httpclient
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var prewarmAttempts = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "prewarm_attempts_total",
+	Help: "Connection pre-dial attempts by target and result.",
+}, []string{"target", "result"})
+
+// usageTransport remembers when the client was last used so the prewarmer
+// knows when the pool may have gone cold.
+type usageTransport struct {
+	base    http.RoundTripper
+	lastUse *atomic.Int64
+}
+
+func (t usageTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	t.lastUse.Store(time.Now().UnixNano())
+	return t.base.RoundTrip(r)
+}
+
+// Prewarmer dials and TLS-handshakes connections to the upstreams on startup
+// and again after idle periods, so user requests don't pay the setup cost.
+type Prewarmer struct {
+	// Client goes through the base transport; it must be used for every
+	// outbound call, so the idle periods are known.
+	Client *http.Client
+
+	tracer  trace.Tracer
+	targets map[string]string // target name -> base URL
+	idle    time.Duration
+	lastUse atomic.Int64
+}
+
+// NewPrewarmer wraps the base transport so its use is tracked. Run warms
+// the targets, by name, through it.
+func NewPrewarmer(base http.RoundTripper, tracer trace.Tracer, idle time.Duration, targets map[string]string) *Prewarmer {
+	p := &Prewarmer{tracer: tracer, targets: targets, idle: idle}
+	p.Client = &http.Client{Transport: usageTransport{base: base, lastUse: &p.lastUse}}
+	return p
+}
+
+// Run warms the pool once and then re-warms it whenever it has been idle.
+func (p *Prewarmer) Run(ctx context.Context) {
+	p.warm(ctx)
+
+	ticker := time.NewTicker(p.idle / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if time.Since(time.Unix(0, p.lastUse.Load())) >= p.idle {
+				p.warm(ctx)
+			}
+		}
+	}
+}
+
+func (p *Prewarmer) warm(ctx context.Context) {
+	var wg sync.WaitGroup
+	for name, baseURL := range p.targets {
+		wg.Add(1)
+		go func(name, baseURL string) {
+			defer wg.Done()
+			p.dial(ctx, name, baseURL)
+		}(name, baseURL)
+	}
+	wg.Wait()
+}
+
+func (p *Prewarmer) dial(ctx context.Context, name, baseURL string) {
+	ctx, span := p.tracer.Start(ctx, "prewarm "+name, trace.WithAttributes(attribute.String("prewarm.target", name)))
+	defer span.End()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, baseURL, nil)
+	if err == nil {
+		var resp *http.Response
+		if resp, err = p.Client.Do(req); err == nil {
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+		}
+	}
+
+	if err != nil {
+		slog.WarnContext(ctx, "prewarm failed", "target", name, "error", err)
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		prewarmAttempts.WithLabelValues(name, "failure").Inc()
+		return
+	}
+	prewarmAttempts.WithLabelValues(name, "success").Inc()
+}
@@ -0,0 +1,112 @@
+package logging
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+type accessRecordKey struct{}
+
+// accessRecord captures what the access log line needs from the response.
+type accessRecord struct {
+	http.ResponseWriter
+	status  int
+	bytes   int
+	traceID string
+	subject string
+}
+
+func (a *accessRecord) WriteHeader(code int) {
+	a.status = code
+	a.ResponseWriter.WriteHeader(code)
+}
+
+func (a *accessRecord) Write(b []byte) (int, error) {
+	n, err := a.ResponseWriter.Write(b)
+	a.bytes += n
+	return n, err
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer, e.g. to flush.
+func (a *accessRecord) Unwrap() http.ResponseWriter {
+	return a.ResponseWriter
+}
+
+// AccessLogExclusions parses ACCESS_LOG_EXCLUDE, a comma separated list of paths.
+func AccessLogExclusions(raw string) map[string]bool {
+	excluded := make(map[string]bool)
+	for _, path := range strings.Split(raw, ",") {
+		if path = strings.TrimSpace(path); path != "" {
+			excluded[path] = true
+		}
+	}
+	return excluded
+}
+
+// AccessLog writes one structured line per request, skipping excluded
+// paths. Error lines are sampled with ErrorSampler.
+func AccessLog(excluded map[string]bool) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if excluded[r.URL.Path] {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			start := time.Now()
+			rec := &accessRecord{ResponseWriter: w, status: http.StatusOK}
+			ctx := context.WithValue(r.Context(), accessRecordKey{}, rec)
+			next.ServeHTTP(rec, r.WithContext(ctx))
+
+			suppressed := 0
+			if rec.status >= http.StatusBadRequest {
+				var ok bool
+				if ok, suppressed = ErrorSampler.Allow("access:" + strconv.Itoa(rec.status) + ":" + r.URL.Path); !ok {
+					return
+				}
+			}
+
+			attrs := []slog.Attr{
+				slog.String("method", r.Method),
+				slog.String("path", r.URL.Path),
+				slog.Int("status", rec.status),
+				slog.Float64("duration_ms", float64(time.Since(start).Microseconds())/1000),
+				slog.Int("bytes", rec.bytes),
+				slog.String("remote_addr", r.RemoteAddr),
+				slog.String("trace_id", rec.traceID),
+				slog.Int("suppressed", suppressed),
+			}
+			if rec.subject != "" {
+				attrs = append(attrs, slog.String("subject", rec.subject))
+			}
+			slog.LogAttrs(ctx, slog.LevelInfo, "request", attrs...)
+		})
+	}
+}
+
+// CaptureTraceID hands the trace ID of the request span to the access log
+// line, which is written outside the span; mount it inside otelhttp.
+func CaptureTraceID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if rec, ok := r.Context().Value(accessRecordKey{}).(*accessRecord); ok {
+			if sc := trace.SpanContextFromContext(r.Context()); sc.HasTraceID() {
+				rec.traceID = sc.TraceID().String()
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// SetSubject hands the authenticated caller of the request in ctx to its
+// access log line.
+func SetSubject(ctx context.Context, subject string) {
+	if rec, ok := ctx.Value(accessRecordKey{}).(*accessRecord); ok {
+		rec.subject = subject
+	}
+}
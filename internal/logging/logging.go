@@ -0,0 +1,187 @@
+package logging
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spf13/viper"
+	"go.opentelemetry.io/otel/trace"
+
+	"goexpert-lab-2-observabilidade/internal/apperr"
+	"goexpert-lab-2-observabilidade/internal/middleware"
+)
+
+// Level is shared by the handlers so the level can be changed after startup.
+var Level = new(slog.LevelVar)
+
+// NewLogger builds the process logger from LOG_LEVEL (debug, info, warn, error)
+// and LOG_FORMAT (json or text), tagging every line with the service name.
+// When LOKI_URL is set lines are also pushed to Loki; the returned func
+// flushes them and must run before exit.
+func NewLogger(service string) (*slog.Logger, func()) {
+	Level.Set(ParseLevel(viper.GetString("LOG_LEVEL")))
+	opts := &slog.HandlerOptions{Level: Level}
+
+	var h slog.Handler = slog.NewJSONHandler(os.Stdout, opts)
+	if strings.EqualFold(viper.GetString("LOG_FORMAT"), "text") {
+		h = slog.NewTextHandler(os.Stdout, opts)
+	}
+
+	closeFn := func() {}
+	if url := viper.GetString("LOKI_URL"); url != "" {
+		labels := parseLokiLabels(viper.GetString("LOKI_LABELS"))
+		labels["service"] = service
+		sink := newLokiSink(url, labels)
+		h = fanoutHandler{h, slog.NewJSONHandler(sink, opts)}
+		closeFn = sink.Close
+	}
+
+	return slog.New(traceHandler{h}).With("service", service), closeFn
+}
+
+// traceHandler adds the trace_id and span_id of the context passed to the log
+// call, so every line logged during a request can be joined with its trace,
+// and the request_id, which is there even when the trace is sampled out.
+type traceHandler struct {
+	slog.Handler
+}
+
+func (h traceHandler) Handle(ctx context.Context, r slog.Record) error {
+	if id := middleware.RequestIDFromContext(ctx); id != "" {
+		r.AddAttrs(slog.String("request_id", id))
+	}
+	if sc := trace.SpanContextFromContext(ctx); sc.IsValid() {
+		r.AddAttrs(
+			slog.String("trace_id", sc.TraceID().String()),
+			slog.String("span_id", sc.SpanID().String()),
+		)
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h traceHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return traceHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h traceHandler) WithGroup(name string) slog.Handler {
+	return traceHandler{h.Handler.WithGroup(name)}
+}
+
+// ParseLevel parses a LOG_LEVEL, falling back to info.
+func ParseLevel(s string) slog.Level {
+	var l slog.Level
+	if err := l.UnmarshalText([]byte(s)); err != nil {
+		return slog.LevelInfo
+	}
+	return l
+}
+
+type logLevelBody struct {
+	Level string `json:"level"`
+}
+
+// LevelHandler reports the current level on GET and switches it on PUT
+// with a {"level":"debug"} body, without a restart. Bad bodies are answered
+// through writeError, the error writer of the service.
+func LevelHandler(writeError func(http.ResponseWriter, *http.Request, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPut {
+			var body logLevelBody
+			if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+				writeError(w, r, apperr.ErrInvalidBody.Wrap(err))
+				return
+			}
+			var level slog.Level
+			if err := level.UnmarshalText([]byte(body.Level)); err != nil {
+				writeError(w, r, apperr.ErrInvalidParam.WithFields(apperr.FieldError{Field: "level", Message: "must be debug, info, warn or error"}))
+				return
+			}
+			previous := Level.Level()
+			Level.Set(level)
+			slog.WarnContext(r.Context(), "log level changed", "from", previous.String(), "to", level.String())
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(logLevelBody{Level: Level.Level().String()})
+	}
+}
+
+// Sampler lets the first burst messages per key through in each interval
+// and then only every nth, so a flood of identical errors (e.g. a scanner
+// sending invalid zipcodes) can't drown useful logs or blow up log storage.
+type Sampler struct {
+	interval time.Duration
+	burst    int
+	every    int
+
+	mu      sync.Mutex
+	windows map[string]*sampleWindow
+}
+
+type sampleWindow struct {
+	start      time.Time
+	count      int
+	suppressed int
+}
+
+// ErrorSampler samples the high-frequency error paths; it is set up in main.
+var ErrorSampler *Sampler
+
+// NewSampler builds a sampler letting burst messages per key through in each
+// interval, and then only every nth.
+func NewSampler(interval time.Duration, burst, every int) *Sampler {
+	return &Sampler{
+		interval: interval,
+		burst:    burst,
+		every:    max(every, 1),
+		windows:  make(map[string]*sampleWindow),
+	}
+}
+
+// Configure changes the sampling parameters of a running sampler.
+func (s *Sampler) Configure(interval time.Duration, burst, every int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.interval = interval
+	s.burst = burst
+	s.every = max(every, 1)
+}
+
+// Allow reports whether a message for key should be logged, along with how
+// many were suppressed since the last one that was.
+func (s *Sampler) Allow(key string) (bool, int) {
+	if s == nil {
+		return true, 0
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	win, ok := s.windows[key]
+	if !ok || now.Sub(win.start) >= s.interval {
+		win = &sampleWindow{start: now}
+		s.windows[key] = win
+	}
+
+	win.count++
+	if win.count <= s.burst || (win.count-s.burst)%s.every == 0 {
+		suppressed := win.suppressed
+		win.suppressed = 0
+		return true, suppressed
+	}
+	win.suppressed++
+	return false, 0
+}
+
+// Fatal logs err and exits, replacing log.Fatal.
+func Fatal(msg string, err error) {
+	slog.Error(msg, "error", err)
+	os.Exit(1)
+}
@@ -0,0 +1,180 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	lokiBatchSize     = 100
+	lokiFlushInterval = time.Second
+)
+
+var lokiDropped = prometheus.NewCounter(prometheus.CounterOpts{
+	Name: "loki_dropped_lines_total",
+	Help: "Log lines dropped because the Loki sink was full or the push failed.",
+})
+
+// Collectors returns the metrics of the package, for the registry of a service.
+func Collectors() []prometheus.Collector {
+	return []prometheus.Collector{lokiDropped}
+}
+
+type lokiEntry struct {
+	ts   time.Time
+	line string
+}
+
+// lokiSink ships log lines straight to Loki's push API in batches, for
+// environments without a log shipper sidecar. Pushing never blocks logging:
+// when the buffer is full lines are dropped and counted.
+type lokiSink struct {
+	url    string
+	labels map[string]string
+	client *http.Client
+
+	entries chan lokiEntry
+	done    chan struct{}
+}
+
+func newLokiSink(url string, labels map[string]string) *lokiSink {
+	s := &lokiSink{
+		url:     strings.TrimSuffix(url, "/") + "/loki/api/v1/push",
+		labels:  labels,
+		client:  &http.Client{Timeout: 5 * time.Second},
+		entries: make(chan lokiEntry, 10*lokiBatchSize),
+		done:    make(chan struct{}),
+	}
+	go s.run()
+	return s
+}
+
+// parseLokiLabels parses LOKI_LABELS, a comma separated list of key=value pairs.
+func parseLokiLabels(raw string) map[string]string {
+	labels := make(map[string]string)
+	for _, pair := range strings.Split(raw, ",") {
+		if k, v, ok := strings.Cut(strings.TrimSpace(pair), "="); ok {
+			labels[k] = v
+		}
+	}
+	return labels
+}
+
+// Write receives one JSON encoded record per call from the slog handler.
+func (s *lokiSink) Write(p []byte) (int, error) {
+	select {
+	case s.entries <- lokiEntry{ts: time.Now(), line: string(bytes.TrimRight(p, "\n"))}:
+	default:
+		lokiDropped.Inc()
+	}
+	return len(p), nil
+}
+
+func (s *lokiSink) run() {
+	defer close(s.done)
+
+	ticker := time.NewTicker(lokiFlushInterval)
+	defer ticker.Stop()
+
+	batch := make([]lokiEntry, 0, lokiBatchSize)
+	for {
+		select {
+		case e, ok := <-s.entries:
+			if !ok {
+				s.push(batch)
+				return
+			}
+			if batch = append(batch, e); len(batch) >= lokiBatchSize {
+				s.push(batch)
+				batch = batch[:0]
+			}
+		case <-ticker.C:
+			s.push(batch)
+			batch = batch[:0]
+		}
+	}
+}
+
+func (s *lokiSink) push(batch []lokiEntry) {
+	if len(batch) == 0 {
+		return
+	}
+
+	values := make([][2]string, len(batch))
+	for i, e := range batch {
+		values[i] = [2]string{strconv.FormatInt(e.ts.UnixNano(), 10), e.line}
+	}
+	body, _ := json.Marshal(map[string]any{
+		"streams": []map[string]any{{"stream": s.labels, "values": values}},
+	})
+
+	resp, err := s.client.Post(s.url, "application/json", bytes.NewReader(body))
+	if err == nil {
+		resp.Body.Close()
+		if resp.StatusCode >= http.StatusBadRequest {
+			err = fmt.Errorf("loki responded with status %d", resp.StatusCode)
+		}
+	}
+	if err != nil {
+		// logging through slog would feed the failure back into the sink
+		fmt.Fprintf(os.Stderr, "loki push failed: %v\n", err)
+		lokiDropped.Add(float64(len(batch)))
+	}
+}
+
+// Close flushes buffered lines; nothing may be logged to the sink afterwards.
+func (s *lokiSink) Close() {
+	close(s.entries)
+	<-s.done
+}
+
+// fanoutHandler sends every record to all of its handlers.
+type fanoutHandler []slog.Handler
+
+func (f fanoutHandler) Enabled(ctx context.Context, l slog.Level) bool {
+	for _, h := range f {
+		if h.Enabled(ctx, l) {
+			return true
+		}
+	}
+	return false
+}
+
+func (f fanoutHandler) Handle(ctx context.Context, r slog.Record) error {
+	var firstErr error
+	for _, h := range f {
+		if !h.Enabled(ctx, r.Level) {
+			continue
+		}
+		if err := h.Handle(ctx, r.Clone()); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+func (f fanoutHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	out := make(fanoutHandler, len(f))
+	for i, h := range f {
+		out[i] = h.WithAttrs(attrs)
+	}
+	return out
+}
+
+func (f fanoutHandler) WithGroup(name string) slog.Handler {
+	out := make(fanoutHandler, len(f))
+	for i, h := range f {
+		out[i] = h.WithGroup(name)
+	}
+	return out
+}
@@ -0,0 +1,159 @@
+package middleware
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var responseBytes = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "response_compression_bytes_total",
+	Help: "Bytes of compressed API responses before and after compression, by content encoding.",
+}, []string{"encoding", "stage"})
+
+// responseEncodings are the supported content codings, in order of preference.
+var responseEncodings = []string{"gzip", "deflate"}
+
+// AcceptedEncoding picks the coding to answer r with from its
+// Accept-Encoding, or "" to leave the response uncompressed; codings with
+// q=0 are refused and ties go to gzip.
+func AcceptedEncoding(r *http.Request) string {
+	accepted := make(map[string]float64)
+	for _, part := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		coding, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		q := 1.0
+		if v, found := strings.CutPrefix(strings.TrimSpace(params), "q="); found {
+			var err error
+			if q, err = strconv.ParseFloat(v, 64); err != nil {
+				continue
+			}
+		}
+		accepted[strings.ToLower(strings.TrimSpace(coding))] = q
+	}
+
+	best, bestQ := "", 0.0
+	for _, enc := range responseEncodings {
+		q, found := accepted[enc]
+		if !found {
+			q = accepted["*"]
+		}
+		if q > bestQ {
+			best, bestQ = enc, q
+		}
+	}
+	return best
+}
+
+// countingWriter counts the bytes written through it.
+type countingWriter struct {
+	w io.Writer
+	n int
+}
+
+func (c *countingWriter) Write(b []byte) (int, error) {
+	n, err := c.w.Write(b)
+	c.n += n
+	return n, err
+}
+
+// compressWriter decides on the first write whether the response is
+// compressible, from its status and Content-Type, and compresses it if so.
+type compressWriter struct {
+	http.ResponseWriter
+	encoding string
+	types    []string
+
+	decided bool
+	zw      interface {
+		io.WriteCloser
+		Flush() error
+	}
+	wire *countingWriter
+	raw  int
+}
+
+func (c *compressWriter) decide(code int) {
+	if c.decided {
+		return
+	}
+	c.decided = true
+
+	// informational, 204 and 304 responses have no body to compress
+	if code < http.StatusOK || code == http.StatusNoContent || code == http.StatusNotModified {
+		return
+	}
+	h := c.ResponseWriter.Header()
+	mediaType, _, _ := strings.Cut(h.Get("Content-Type"), ";")
+	if h.Get("Content-Encoding") != "" || !slices.Contains(c.types, strings.TrimSpace(mediaType)) {
+		return
+	}
+	h.Set("Content-Encoding", c.encoding)
+	h.Del("Content-Length")
+	c.wire = &countingWriter{w: c.ResponseWriter}
+	if c.encoding == "gzip" {
+		c.zw = gzip.NewWriter(c.wire)
+	} else {
+		c.zw = zlib.NewWriter(c.wire)
+	}
+}
+
+func (c *compressWriter) WriteHeader(code int) {
+	c.decide(code)
+	c.ResponseWriter.WriteHeader(code)
+}
+
+func (c *compressWriter) Write(b []byte) (int, error) {
+	c.decide(http.StatusOK)
+	if c.zw == nil {
+		return c.ResponseWriter.Write(b)
+	}
+	c.raw += len(b)
+	return c.zw.Write(b)
+}
+
+// Flush pushes what was compressed so far to the client, for streamed responses.
+func (c *compressWriter) Flush() {
+	if c.zw != nil {
+		c.zw.Flush()
+	}
+	http.NewResponseController(c.ResponseWriter).Flush()
+}
+
+func (c *compressWriter) Unwrap() http.ResponseWriter {
+	return c.ResponseWriter
+}
+
+func (c *compressWriter) close() {
+	if c.zw == nil {
+		return
+	}
+	c.zw.Close()
+	responseBytes.WithLabelValues(c.encoding, "uncompressed").Add(float64(c.raw))
+	responseBytes.WithLabelValues(c.encoding, "compressed").Add(float64(c.wire.n))
+}
+
+// Compress compresses the responses of the given media types with gzip or
+// deflate, as negotiated by AcceptedEncoding, counting their sizes before and
+// after on response_compression_bytes_total. Other media types, responses
+// without a body and responses already encoded are left as they are.
+func Compress(types ...string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Add("Vary", "Accept-Encoding")
+			encoding := AcceptedEncoding(r)
+			if encoding == "" || r.Method == http.MethodHead {
+				next.ServeHTTP(w, r)
+				return
+			}
+			cw := &compressWriter{ResponseWriter: w, encoding: encoding, types: types}
+			defer cw.close()
+			next.ServeHTTP(cw, r)
+		})
+	}
+}
@@ -0,0 +1,28 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var deprecatedRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "deprecated_requests_total",
+	Help: "Requests served on deprecated route aliases, by route.",
+}, []string{"route"})
+
+// Deprecated marks responses from an old route alias with the Deprecation
+// header and a link to its successor, and counts its use on
+// deprecated_requests_total so we know when the alias can go. The series of
+// the route is exported from the start, at zero.
+func Deprecated(route, successor string) func(http.Handler) http.Handler {
+	requests := deprecatedRequests.WithLabelValues(route)
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requests.Inc()
+			w.Header().Set("Deprecation", "true")
+			w.Header().Set("Link", "<"+successor+`>; rel="successor-version"`)
+			next.ServeHTTP(w, r)
+		})
+	}
+}
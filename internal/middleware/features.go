@@ -0,0 +1,57 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"sync/atomic"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Feature flags an optional capability that took part in serving a request;
+// each service numbers its own, one bit each.
+type Feature uint32
+
+var featureUsage = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "feature_usage_total",
+	Help: "Requests served with each optional feature active.",
+}, []string{"feature"})
+
+type featureSetKey struct{}
+
+// MarkFeature flags f as active for the request in ctx.
+func MarkFeature(ctx context.Context, f Feature) {
+	set, ok := ctx.Value(featureSetKey{}).(*atomic.Uint32)
+	if !ok {
+		return
+	}
+	for {
+		old := set.Load()
+		if set.CompareAndSwap(old, old|uint32(f)) {
+			return
+		}
+	}
+}
+
+// Features collects the features marked while serving the request and
+// records them as the app.features bitset on the span plus counters on
+// feature_usage_total; mount it inside otelhttp. names must list every bit,
+// in bit order, the first one for 1<<0; the bitset is decoded with it.
+func Features(names ...string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			set := new(atomic.Uint32)
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), featureSetKey{}, set)))
+
+			bits := set.Load()
+			trace.SpanFromContext(r.Context()).SetAttributes(attribute.Int("app.features", int(bits)))
+			for i, name := range names {
+				if bits&(1<<i) != 0 {
+					featureUsage.WithLabelValues(name).Inc()
+				}
+			}
+		})
+	}
+}
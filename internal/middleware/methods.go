@@ -0,0 +1,83 @@
+package middleware
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"goexpert-lab-2-observabilidade/internal/apperr"
+)
+
+var httpRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "http_requests_total",
+	Help: "API requests by method, matched route pattern and status.",
+}, []string{"method", "route", "code"})
+
+// Collectors returns the metrics of the package, for the registry of a service.
+func Collectors() []prometheus.Collector {
+	return []prometheus.Collector{PanicsRecovered, httpRequests, responseBytes, deprecatedRequests, featureUsage}
+}
+
+// routeMethods are the methods a route can be registered for; anything else
+// is reported as "other" to keep the method label bounded.
+var routeMethods = []string{
+	http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut,
+	http.MethodPatch, http.MethodDelete, http.MethodOptions,
+}
+
+func methodLabel(method string) string {
+	for _, m := range routeMethods {
+		if m == method {
+			return m
+		}
+	}
+	return "other"
+}
+
+// StatusRecorder captures the status code written by the wrapped handler;
+// Status starts as the one to report when the handler writes none.
+type StatusRecorder struct {
+	http.ResponseWriter
+	Status int
+}
+
+func (r *StatusRecorder) WriteHeader(code int) {
+	r.Status = code
+	r.ResponseWriter.WriteHeader(code)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (r *StatusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+// RequestMetrics counts requests on http_requests_total, labelled with the
+// route pattern, or "unmatched" for 404s and 405s.
+func RequestMetrics(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rec := &StatusRecorder{ResponseWriter: w, Status: http.StatusOK}
+		next.ServeHTTP(rec, r)
+
+		route := "unmatched"
+		if rctx := chi.RouteContext(r.Context()); rctx != nil && rctx.RoutePattern() != "" {
+			route = rctx.RoutePattern()
+		}
+		httpRequests.WithLabelValues(methodLabel(r.Method), route, strconv.Itoa(rec.Status)).Inc()
+	})
+}
+
+// MethodNotAllowed answers requests for a known path with an unregistered
+// method: a method_not_allowed error, answered with writeError, listing the
+// route's methods in Allow.
+func MethodNotAllowed(routes chi.Routes, writeError func(http.ResponseWriter, *http.Request, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		for _, m := range routeMethods {
+			if routes.Match(chi.NewRouteContext(), m, r.URL.Path) {
+				w.Header().Add("Allow", m)
+			}
+		}
+		writeError(w, r, apperr.ErrMethodNotAllowed)
+	}
+}
@@ -0,0 +1,44 @@
+package middleware
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"runtime/debug"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"goexpert-lab-2-observabilidade/internal/apperr"
+)
+
+// PanicsRecovered counts the panics recovered by Recover, by path, and by
+// the gRPC interceptors of the services, by method.
+var PanicsRecovered = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "panics_recovered_total",
+	Help: "Handler panics recovered and answered with a 500, by path.",
+}, []string{"path"})
+
+// Recover turns a handler panic into a 500, answered with writeError,
+// instead of taking the process down; mount it inside otelhttp so the panic
+// lands on the request span.
+func Recover(writeError func(http.ResponseWriter, *http.Request, error)) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			defer func() {
+				v := recover()
+				if v == nil {
+					return
+				}
+				if v == http.ErrAbortHandler {
+					panic(v)
+				}
+
+				PanicsRecovered.WithLabelValues(r.URL.Path).Inc()
+				slog.ErrorContext(r.Context(), "handler panicked",
+					"method", r.Method, "path", r.URL.Path, "panic", fmt.Sprint(v), "stack", string(debug.Stack()))
+				writeError(w, r, apperr.ErrInternal.Wrap(fmt.Errorf("panic: %v", v)))
+			}()
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,19 @@
+package middleware
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	otelprom "go.opentelemetry.io/otel/exporters/prometheus"
+	"go.opentelemetry.io/otel/metric"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+)
+
+// RPCMeterProvider exports the OpenTelemetry metrics of instrumentation
+// such as otelgrpc, e.g. rpc_server_duration_milliseconds, on reg, next to
+// the other metrics of the service.
+func RPCMeterProvider(reg prometheus.Registerer) (metric.MeterProvider, error) {
+	exporter, err := otelprom.New(otelprom.WithRegisterer(reg), otelprom.WithoutTargetInfo(), otelprom.WithoutScopeInfo())
+	if err != nil {
+		return nil, err
+	}
+	return sdkmetric.NewMeterProvider(sdkmetric.WithReader(exporter)), nil
+}
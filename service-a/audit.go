@@ -0,0 +1,77 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+
+	"go.opentelemetry.io/otel/trace"
+
+	"goexpert-lab-2-observabilidade/internal/api"
+	"goexpert-lab-2-observabilidade/internal/middleware"
+)
+
+// auditLog is the dedicated data-access audit stream: one JSON object per
+// temperature query recording who asked for which CEP and what was returned.
+// It is kept apart from the application logs so it can follow its own
+// retention, and is never sampled or filtered by LOG_LEVEL.
+type auditLog struct {
+	logger *slog.Logger
+}
+
+// newAuditLog appends to AUDIT_LOG_FILE, or writes to stdout when it is empty.
+func newAuditLog(path string) (*auditLog, io.Closer, error) {
+	var out io.WriteCloser = nopCloser{os.Stdout}
+	if path != "" {
+		f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o640)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to open audit log: %w", err)
+		}
+		out = f
+	}
+	logger := slog.New(slog.NewJSONHandler(out, nil)).With("stream", "audit")
+	return &auditLog{logger: logger}, out, nil
+}
+
+type nopCloser struct{ io.Writer }
+
+func (nopCloser) Close() error { return nil }
+
+// auditEntry is filled while a query is served and written once it is done.
+type auditEntry struct {
+	CEP     string
+	Country string // DefaultCountry when empty
+	Status  int
+	Result  *api.Temperature
+}
+
+func (a *auditLog) Record(ctx context.Context, r *http.Request, e auditEntry) {
+	attrs := []slog.Attr{
+		slog.String("cep", e.CEP),
+		slog.String("country", cmp.Or(e.Country, api.DefaultCountry)),
+		slog.Int("status", e.Status),
+		slog.String("remote_addr", r.RemoteAddr),
+	}
+	if id, ok := identityFromContext(ctx); ok {
+		attrs = append(attrs, slog.String("subject", id.Subject), slog.String("auth_method", id.Method))
+	}
+	if sc := trace.SpanContextFromContext(ctx); sc.HasTraceID() {
+		attrs = append(attrs, slog.String("trace_id", sc.TraceID().String()))
+	}
+	if id := middleware.RequestIDFromContext(ctx); id != "" {
+		attrs = append(attrs, slog.String("request_id", id))
+	}
+	if e.Result != nil {
+		attrs = append(attrs, slog.Group("result",
+			slog.String("city", e.Result.City),
+			slog.Float64("temp_C", e.Result.TempC),
+			slog.Float64("temp_F", e.Result.TempF),
+			slog.Float64("temp_K", e.Result.TempK),
+		))
+	}
+	a.logger.LogAttrs(ctx, slog.LevelInfo, "temperature_query", attrs...)
+}
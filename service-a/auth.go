@@ -0,0 +1,227 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"github.com/go-jose/go-jose/v4"
+	"github.com/go-jose/go-jose/v4/jwt"
+	"github.com/spf13/viper"
+	"go.opentelemetry.io/otel/attribute"
+	semconv "go.opentelemetry.io/otel/semconv/v1.21.0"
+	"go.opentelemetry.io/otel/trace"
+
+	"goexpert-lab-2-observabilidade/internal/api"
+	"goexpert-lab-2-observabilidade/internal/apperr"
+	"goexpert-lab-2-observabilidade/internal/logging"
+)
+
+var errUnauthenticated = errors.New("unauthenticated")
+
+// identity is the caller resolved by an Authenticator.
+type identity struct {
+	Subject string
+	Method  string
+}
+
+// Authenticator resolves the identity of the caller of a request.
+type Authenticator interface {
+	Authenticate(r *http.Request) (identity, error)
+}
+
+type identityKey struct{}
+
+func withIdentity(ctx context.Context, id identity) context.Context {
+	return context.WithValue(ctx, identityKey{}, id)
+}
+
+// identityFromContext returns the caller identity stored by authMiddleware.
+func identityFromContext(ctx context.Context) (identity, bool) {
+	id, ok := ctx.Value(identityKey{}).(identity)
+	return id, ok
+}
+
+// newAuthenticator builds the Authenticator selected by AUTH_MODE.
+func newAuthenticator(ctx context.Context) (Authenticator, error) {
+	switch mode := viper.GetString("AUTH_MODE"); mode {
+	case "", "none":
+		return anonymousAuthenticator{}, nil
+	case "apikey":
+		return newAPIKeyAuthenticator(viper.GetString("AUTH_API_KEYS"))
+	case "jwt":
+		return newJWTAuthenticator(ctx)
+	case "mtls":
+		return mtlsAuthenticator{}, nil
+	default:
+		return nil, fmt.Errorf("unknown AUTH_MODE %q", mode)
+	}
+}
+
+// authMiddleware rejects unauthenticated requests and stores the resolved
+// identity in the request context, on the active span and on the access log
+// line; the rate limit mounted after it is per identity.
+func authMiddleware(a Authenticator) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			id, err := a.Authenticate(r)
+			if err != nil {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="service-a"`)
+				api.Error(w, r, apperr.ErrUnauthorized.Wrap(err))
+				return
+			}
+
+			trace.SpanFromContext(r.Context()).SetAttributes(
+				semconv.EnduserID(id.Subject),
+				attribute.String("auth.method", id.Method),
+			)
+			logging.SetSubject(r.Context(), id.Subject)
+
+			next.ServeHTTP(w, r.WithContext(withIdentity(r.Context(), id)))
+		})
+	}
+}
+
+type anonymousAuthenticator struct{}
+
+func (anonymousAuthenticator) Authenticate(*http.Request) (identity, error) {
+	return identity{Subject: "anonymous", Method: "none"}, nil
+}
+
+// apiKeyAuthenticator accepts keys sent in the X-API-Key header.
+type apiKeyAuthenticator struct {
+	keys map[string]string // key -> subject
+}
+
+// newAPIKeyAuthenticator parses a comma separated list of key[=subject] entries.
+func newAPIKeyAuthenticator(raw string) (*apiKeyAuthenticator, error) {
+	a := &apiKeyAuthenticator{keys: make(map[string]string)}
+	for _, entry := range strings.Split(raw, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		key, subject, found := strings.Cut(entry, "=")
+		if !found {
+			sum := sha256.Sum256([]byte(key))
+			subject = "key:" + hex.EncodeToString(sum[:4])
+		}
+		a.keys[key] = subject
+	}
+	if len(a.keys) == 0 {
+		return nil, errors.New("AUTH_MODE=apikey requires AUTH_API_KEYS")
+	}
+	return a, nil
+}
+
+func (a *apiKeyAuthenticator) Authenticate(r *http.Request) (identity, error) {
+	presented := r.Header.Get("X-API-Key")
+	if presented == "" {
+		return identity{}, errUnauthenticated
+	}
+	for key, subject := range a.keys {
+		if subtle.ConstantTimeCompare([]byte(key), []byte(presented)) == 1 {
+			return identity{Subject: subject, Method: "apikey"}, nil
+		}
+	}
+	return identity{}, fmt.Errorf("%w: unknown api key", errUnauthenticated)
+}
+
+// jwtAuthenticator validates bearer tokens, either against an OIDC issuer
+// (AUTH_OIDC_ISSUER) or against a shared HS256 secret (AUTH_JWT_SECRET).
+type jwtAuthenticator struct {
+	verify func(ctx context.Context, raw string) (string, error)
+}
+
+func newJWTAuthenticator(ctx context.Context) (*jwtAuthenticator, error) {
+	audience := viper.GetString("AUTH_JWT_AUDIENCE")
+
+	if issuer := viper.GetString("AUTH_OIDC_ISSUER"); issuer != "" {
+		provider, err := oidc.NewProvider(ctx, issuer)
+		if err != nil {
+			return nil, fmt.Errorf("failed to discover OIDC issuer: %w", err)
+		}
+		verifier := provider.Verifier(&oidc.Config{
+			ClientID:          audience,
+			SkipClientIDCheck: audience == "",
+		})
+		return &jwtAuthenticator{verify: func(ctx context.Context, raw string) (string, error) {
+			token, err := verifier.Verify(ctx, raw)
+			if err != nil {
+				return "", err
+			}
+			return token.Subject, nil
+		}}, nil
+	}
+
+	secret := viper.GetString("AUTH_JWT_SECRET")
+	if secret == "" {
+		return nil, errors.New("AUTH_MODE=jwt requires AUTH_OIDC_ISSUER or AUTH_JWT_SECRET")
+	}
+	return &jwtAuthenticator{verify: func(_ context.Context, raw string) (string, error) {
+		token, err := jwt.ParseSigned(raw, []jose.SignatureAlgorithm{jose.HS256})
+		if err != nil {
+			return "", err
+		}
+		var claims jwt.Claims
+		if err := token.Claims([]byte(secret), &claims); err != nil {
+			return "", err
+		}
+		expected := jwt.Expected{Time: time.Now()}
+		if audience != "" {
+			expected.AnyAudience = jwt.Audience{audience}
+		}
+		if err := claims.Validate(expected); err != nil {
+			return "", err
+		}
+		return claims.Subject, nil
+	}}, nil
+}
+
+func (a *jwtAuthenticator) Authenticate(r *http.Request) (identity, error) {
+	raw, found := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !found || raw == "" {
+		return identity{}, errUnauthenticated
+	}
+	subject, err := a.verify(r.Context(), raw)
+	if err != nil {
+		return identity{}, fmt.Errorf("%w: %v", errUnauthenticated, err)
+	}
+	return identity{Subject: subject, Method: "jwt"}, nil
+}
+
+// mtlsAuthenticator derives the identity from the verified client certificate.
+type mtlsAuthenticator struct{}
+
+func (mtlsAuthenticator) Authenticate(r *http.Request) (identity, error) {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 {
+		return identity{}, fmt.Errorf("%w: no verified client certificate", errUnauthenticated)
+	}
+	return identity{Subject: r.TLS.VerifiedChains[0][0].Subject.CommonName, Method: "mtls"}, nil
+}
+
+// mtlsServerConfig requires and verifies client certificates signed by AUTH_CLIENT_CA_FILE.
+func mtlsServerConfig() (*tls.Config, error) {
+	pem, err := os.ReadFile(viper.GetString("AUTH_CLIENT_CA_FILE"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read client CA: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, errors.New("no certificates found in AUTH_CLIENT_CA_FILE")
+	}
+	return &tls.Config{
+		ClientAuth: tls.RequireAndVerifyClientCert,
+		ClientCAs:  pool,
+	}, nil
+}
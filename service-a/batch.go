@@ -0,0 +1,190 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"goexpert-lab-2-observabilidade/internal/api"
+	"goexpert-lab-2-observabilidade/internal/apperr"
+)
+
+type batchRequest struct {
+	CEPs []string `json:"ceps"`
+
+	// Country is the country of all the CEPs, DefaultCountry when empty.
+	Country string `json:"country,omitempty"`
+}
+
+type batchError struct {
+	Type    string              `json:"type"`
+	Message string              `json:"message"`
+	Fields  []apperr.FieldError `json:"fields,omitempty"`
+}
+
+// batchItem is the outcome for one CEP; Status is what the single lookup
+// endpoint would have answered.
+type batchItem struct {
+	Index  int              `json:"index"`
+	CEP    string           `json:"cep"`
+	Status int              `json:"status"`
+	Result *api.Temperature `json:"result,omitempty"`
+	Error  *batchError      `json:"error,omitempty"`
+}
+
+type batchResponse struct {
+	Results   []batchItem `json:"results"`
+	Succeeded int         `json:"succeeded"`
+	Failed    int         `json:"failed"`
+}
+
+// batch bounds the size of POST /v1/temperatures and the concurrency it fans out with.
+type batch struct {
+	maxCEPs int
+	workers int
+}
+
+// ndjsonContentType is the Accept value that makes batch results stream.
+const ndjsonContentType = "application/x-ndjson"
+
+// batchHandler serves POST /v1/temperatures. Each CEP is looked up on its
+// own: the response is 200 when all of them succeeded and 207 otherwise,
+// with the per-CEP status and error in the body. Clients accepting NDJSON
+// get one line per CEP instead, as each lookup finishes.
+func (h *handler) batchHandler(w http.ResponseWriter, r *http.Request) {
+	if !h.degradation.Allows(featureBatch) {
+		api.Error(w, r, apperr.ErrFeatureShed)
+		return
+	}
+
+	var req batchRequest
+	if err := api.DecodeJSON(r, &req); err != nil {
+		api.Error(w, r, err)
+		return
+	}
+	if len(req.CEPs) == 0 {
+		api.Error(w, r, apperr.ErrInvalidBody.WithFields(apperr.FieldError{Field: "ceps", Message: "must not be empty"}))
+		return
+	}
+	if len(req.CEPs) > h.batch.maxCEPs {
+		api.Error(w, r, apperr.ErrBatchTooLarge.WithFields(apperr.FieldError{
+			Field: "ceps", Message: fmt.Sprintf("must have at most %d entries, got %d", h.batch.maxCEPs, len(req.CEPs)),
+		}))
+		return
+	}
+	country := api.NormalizeCountry(req.Country)
+	if err := api.ValidateCountry(country); err != nil {
+		api.Error(w, r, err)
+		return
+	}
+
+	trace.SpanFromContext(r.Context()).SetAttributes(attribute.Int("batch.size", len(req.CEPs)))
+
+	if strings.Contains(r.Header.Get("Accept"), ndjsonContentType) {
+		h.streamBatch(w, r, country, req.CEPs)
+		return
+	}
+
+	resp := batchResponse{Results: make([]batchItem, len(req.CEPs))}
+	h.runBatch(r, "batch lookup", country, req.CEPs, func(i int, item batchItem) {
+		resp.Results[i] = item
+	})
+	for _, item := range resp.Results {
+		if item.Error == nil {
+			resp.Succeeded++
+		} else {
+			resp.Failed++
+		}
+	}
+
+	status := http.StatusOK
+	if resp.Failed > 0 {
+		status = http.StatusMultiStatus
+	}
+	api.WriteJSON(w, r, status, resp)
+}
+
+// streamBatch writes each outcome as its own JSON line, in completion order,
+// flushing after every line. The status is sent before any lookup is done, so
+// it is always 200; per-CEP failures are only reported in the lines.
+func (h *handler) streamBatch(w http.ResponseWriter, r *http.Request, country string, ceps []string) {
+	w.Header().Set("Content-Type", ndjsonContentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(http.StatusOK)
+
+	rc := http.NewResponseController(w)
+	enc := json.NewEncoder(w)
+	var mu sync.Mutex
+	h.runBatch(r, "batch lookup", country, ceps, func(_ int, item batchItem) {
+		mu.Lock()
+		defer mu.Unlock()
+		enc.Encode(item)
+		rc.Flush()
+	})
+}
+
+// runBatch looks the CEPs of country up on a bounded pool of workers, each
+// under a span with the given name, and hands each outcome to emit, with its
+// index in ceps, as soon as it is known. emit is called from the workers,
+// concurrently.
+func (h *handler) runBatch(r *http.Request, spanName, country string, ceps []string, emit func(int, batchItem)) {
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for range min(h.batch.workers, len(ceps)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				emit(i, h.lookup(r, spanName, i, country, ceps[i]))
+			}
+		}()
+	}
+	for i := range ceps {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+}
+
+// lookup resolves one CEP of a batch under its own span, so failures are
+// recorded per CEP instead of on the batch request.
+func (h *handler) lookup(r *http.Request, spanName string, i int, country, cep string) batchItem {
+	ctx, span := h.tracer.Start(r.Context(), spanName, trace.WithAttributes(attribute.String("cep", cep), attribute.String("country", country)))
+	defer span.End()
+
+	item := batchItem{Index: i, CEP: cep, Status: http.StatusOK}
+	cep = normalizeCEP(ctx, cep)
+	result, err := h.fetchBatchItem(ctx, country, cep)
+	if err != nil {
+		e := apperr.Record(ctx, err)
+		item.Status = e.Status
+		item.Error = &batchError{Type: e.Type, Message: e.Localized(apperr.Language(r)), Fields: e.Fields()}
+	} else {
+		item.Result = &result
+	}
+
+	h.audit.Record(ctx, r, auditEntry{CEP: cep, Country: country, Status: item.Status, Result: item.Result})
+	return item
+}
+
+// fetchBatchItem validates and looks up a normalized postal code of a
+// normalized country, as /v1/temperature does.
+func (h *handler) fetchBatchItem(ctx context.Context, country, cep string) (api.Temperature, error) {
+	if err := api.ValidatePostalCode(country, cep); err != nil {
+		return api.Temperature{}, err
+	}
+	result, err := h.fetchTemperature(ctx, country, cep)
+	if err != nil {
+		return api.Temperature{}, err
+	}
+	if err := result.Validate(); err != nil {
+		return api.Temperature{}, apperr.ErrBadUpstreamPayload.Wrap(err)
+	}
+	return result, nil
+}
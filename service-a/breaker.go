@@ -0,0 +1,184 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/spf13/viper"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"goexpert-lab-2-observabilidade/internal/apperr"
+)
+
+// breakerState is the state of a circuit breaker, as exported on circuit_breaker_state.
+type breakerState int
+
+const (
+	stateClosed breakerState = iota
+	stateHalfOpen
+	stateOpen
+)
+
+func (s breakerState) String() string {
+	switch s {
+	case stateHalfOpen:
+		return "half_open"
+	case stateOpen:
+		return "open"
+	}
+	return "closed"
+}
+
+var (
+	breakerStateGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "circuit_breaker_state",
+		Help: "State of the circuit breaker of each dependency: 0 closed, 1 half-open, 2 open.",
+	}, []string{"dependency"})
+
+	breakerTransitions = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "circuit_breaker_transitions_total",
+		Help: "Circuit breaker state changes, by dependency and states.",
+	}, []string{"dependency", "from", "to"})
+
+	breakerRejections = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "circuit_breaker_rejections_total",
+		Help: "Calls failed fast by an open circuit breaker, by dependency.",
+	}, []string{"dependency"})
+)
+
+// callOutcome is how a call let through by a circuit breaker ended.
+type callOutcome int
+
+const (
+	callSucceeded callOutcome = iota
+	callFailed
+	// callCancelled is a call the caller gave up on, which says nothing about
+	// the dependency: it frees the probe slot and is otherwise ignored.
+	callCancelled
+)
+
+type breakerConfig struct {
+	failures    int           // consecutive failures that open the circuit; 0 disables breakers
+	openTimeout time.Duration // how long an open circuit waits before a probe
+}
+
+func loadBreakerConfig() breakerConfig {
+	return breakerConfig{
+		failures:    viper.GetInt("CIRCUIT_BREAKER_FAILURES"),
+		openTimeout: viper.GetDuration("CIRCUIT_BREAKER_OPEN_TIMEOUT"),
+	}
+}
+
+// Validate checks that the failure threshold isn't negative and the open timeout is positive.
+func (c breakerConfig) Validate() error {
+	var errs []error
+	if c.failures < 0 {
+		errs = append(errs, fmt.Errorf("CIRCUIT_BREAKER_FAILURES (%d) must not be negative", c.failures))
+	}
+	if c.openTimeout <= 0 {
+		errs = append(errs, fmt.Errorf("CIRCUIT_BREAKER_OPEN_TIMEOUT (%s) must be positive", c.openTimeout))
+	}
+	return errors.Join(errs...)
+}
+
+// circuitBreaker stops calling a dependency after consecutive failures:
+// while open, calls fail fast with ErrCircuitOpen, and once the open timeout
+// has passed a single probe is let through, half-open, whose outcome closes
+// the circuit or opens it again.
+type circuitBreaker struct {
+	name string
+	cfg  breakerConfig
+
+	mu       sync.Mutex
+	state    breakerState
+	failures int // consecutive, while closed
+	openedAt time.Time
+	probing  bool // a half-open probe is in flight
+}
+
+func newCircuitBreaker(name string, cfg breakerConfig) *circuitBreaker {
+	breakerStateGauge.WithLabelValues(name).Set(float64(stateClosed))
+	return &circuitBreaker{name: name, cfg: cfg}
+}
+
+// Allow reports whether a call may go ahead, failing with ErrCircuitOpen and
+// the time left until the next probe when it may not. done must be called
+// with the outcome of allowed calls.
+func (b *circuitBreaker) Allow(ctx context.Context) (done func(callOutcome), err error) {
+	if b.cfg.failures == 0 {
+		return func(callOutcome) {}, nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == stateOpen {
+		if wait := b.cfg.openTimeout - time.Since(b.openedAt); wait > 0 {
+			return nil, b.reject(ctx, wait)
+		}
+		b.transition(ctx, stateHalfOpen)
+	}
+	probe := b.state == stateHalfOpen
+	if probe {
+		if b.probing {
+			return nil, b.reject(ctx, time.Second)
+		}
+		b.probing = true
+	}
+	return func(outcome callOutcome) { b.record(ctx, probe, outcome) }, nil
+}
+
+func (b *circuitBreaker) reject(ctx context.Context, wait time.Duration) error {
+	breakerRejections.WithLabelValues(b.name).Inc()
+	trace.SpanFromContext(ctx).AddEvent("circuit breaker rejected", trace.WithAttributes(attribute.String("dependency", b.name)))
+	return apperr.ErrCircuitOpen.Wrap(fmt.Errorf("the circuit of %s is %s", b.name, b.state)).WithRetryAfter(wait)
+}
+
+func (b *circuitBreaker) record(ctx context.Context, probe bool, outcome callOutcome) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if probe {
+		b.probing = false
+	}
+	switch {
+	case outcome == callCancelled:
+		// neither closes nor opens the circuit; a half-open one probes again
+	case outcome == callSucceeded:
+		b.failures = 0
+		if b.state == stateHalfOpen {
+			b.transition(ctx, stateClosed)
+		}
+	case probe:
+		b.transition(ctx, stateOpen)
+	case b.state == stateClosed:
+		// failures of calls that started before the circuit opened don't count
+		if b.failures++; b.failures >= b.cfg.failures {
+			b.transition(ctx, stateOpen)
+		}
+	}
+}
+
+func (b *circuitBreaker) transition(ctx context.Context, to breakerState) {
+	from := b.state
+	b.state = to
+	switch to {
+	case stateOpen:
+		b.openedAt = time.Now()
+	case stateClosed:
+		b.failures = 0
+	}
+	breakerStateGauge.WithLabelValues(b.name).Set(float64(to))
+	breakerTransitions.WithLabelValues(b.name, from.String(), to.String()).Inc()
+	trace.SpanFromContext(ctx).AddEvent("circuit breaker state change", trace.WithAttributes(
+		attribute.String("dependency", b.name),
+		attribute.String("from", from.String()),
+		attribute.String("to", to.String()),
+	))
+	slog.WarnContext(ctx, "circuit breaker state change", "dependency", b.name, "from", from.String(), "to", to.String())
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"unicode"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// normalizeCEP strips the hyphen and whitespace of formatted CEPs such as
+// "01310-100" before validation, and records the result on the span in ctx.
+func normalizeCEP(ctx context.Context, raw string) string {
+	cep := strings.Map(func(r rune) rune {
+		if r == '-' || unicode.IsSpace(r) {
+			return -1
+		}
+		return r
+	}, raw)
+	trace.SpanFromContext(ctx).SetAttributes(attribute.String("cep.normalized", cep))
+	return cep
+}
+
+// postalCode is a normalized postal code with its normalized country, for
+// what is looked up once per code rather than per request.
+type postalCode struct {
+	country, cep string
+}
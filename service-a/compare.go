@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"goexpert-lab-2-observabilidade/internal/api"
+	"goexpert-lab-2-observabilidade/internal/apperr"
+)
+
+// compareEntry is a city of the comparison, with how much colder it is than
+// the warmest one.
+type compareEntry struct {
+	Rank int    `json:"rank"`
+	CEP  string `json:"cep"`
+	api.Temperature
+	DeltaC float64 `json:"delta_C"`
+	DeltaF float64 `json:"delta_F"`
+}
+
+type compareResponse struct {
+	Cities  []compareEntry `json:"cities"` // warmest first
+	SpreadC float64        `json:"spread_C"`
+	Failed  []batchItem    `json:"failed,omitempty"`
+}
+
+// compareHandler serves POST /v1/compare, with a body like the batch's. The
+// CEPs are looked up concurrently, each under a "compare lookup" span, and
+// the cities come back warmest first, with their delta to the warmest and
+// the spread between the warmest and the coldest. CEPs that failed are
+// listed apart, making the response a 207.
+func (h *handler) compareHandler(w http.ResponseWriter, r *http.Request) {
+	if !h.degradation.Allows(featureBatch) {
+		api.Error(w, r, apperr.ErrFeatureShed)
+		return
+	}
+
+	var req batchRequest
+	if err := api.DecodeJSON(r, &req); err != nil {
+		api.Error(w, r, err)
+		return
+	}
+	if len(req.CEPs) < 2 {
+		api.Error(w, r, apperr.ErrInvalidBody.WithFields(apperr.FieldError{Field: "ceps", Message: "must have at least 2 entries"}))
+		return
+	}
+	if len(req.CEPs) > h.batch.maxCEPs {
+		api.Error(w, r, apperr.ErrBatchTooLarge.WithFields(apperr.FieldError{
+			Field: "ceps", Message: fmt.Sprintf("must have at most %d entries, got %d", h.batch.maxCEPs, len(req.CEPs)),
+		}))
+		return
+	}
+	country := api.NormalizeCountry(req.Country)
+	if err := api.ValidateCountry(country); err != nil {
+		api.Error(w, r, err)
+		return
+	}
+
+	trace.SpanFromContext(r.Context()).SetAttributes(attribute.Int("compare.size", len(req.CEPs)))
+
+	items := make([]batchItem, len(req.CEPs))
+	h.runBatch(r, "compare lookup", country, req.CEPs, func(i int, item batchItem) {
+		items[i] = item
+	})
+
+	resp := compareResponse{Cities: []compareEntry{}}
+	for _, item := range items {
+		if item.Error != nil {
+			resp.Failed = append(resp.Failed, item)
+			continue
+		}
+		resp.Cities = append(resp.Cities, compareEntry{CEP: item.CEP, Temperature: *item.Result})
+	}
+	// stable, so ties keep the request order
+	sort.SliceStable(resp.Cities, func(i, j int) bool { return resp.Cities[i].TempC > resp.Cities[j].TempC })
+	if len(resp.Cities) > 0 {
+		warmest := resp.Cities[0].TempC
+		for i := range resp.Cities {
+			c := &resp.Cities[i]
+			c.Rank = i + 1
+			c.DeltaC = c.TempC - warmest
+			c.DeltaF = c.DeltaC * 1.8
+		}
+		resp.SpreadC = warmest - resp.Cities[len(resp.Cities)-1].TempC
+	}
+
+	status := http.StatusOK
+	if len(resp.Failed) > 0 {
+		status = http.StatusMultiStatus
+	}
+	api.WriteJSON(w, r, status, resp)
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/tls"
+	"io"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/net/http2"
+
+	"goexpert-lab-2-observabilidade/internal/httpclient"
+)
+
+var internalPayloadBytes = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "internal_payload_bytes_total",
+	Help: "Bytes of service-b responses on the wire and after decoding, by content encoding.",
+}, []string{"encoding", "stage"})
+
+// newServiceBTransport disables the transport's implicit gzip handling, so
+// INTERNAL_COMPRESSION alone decides whether the hop is compressed and the
+// wire size stays observable. With h2c the hop speaks cleartext HTTP/2,
+// multiplexing concurrent calls over one connection. name labels its
+// connection metrics.
+func newServiceBTransport(name string, timeouts timeoutConfig, h2c bool) http.RoundTripper {
+	dialer := &net.Dialer{Timeout: timeouts.dial, KeepAlive: 30 * time.Second}
+	if h2c {
+		return httpclient.Observe(name, &http2.Transport{
+			AllowHTTP:          true,
+			DisableCompression: true,
+			DialTLSContext: func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+				return dialer.DialContext(ctx, network, addr)
+			},
+		})
+	}
+
+	cfg := httpclient.LoadPool().Transport(name)
+	cfg.DisableCompression = true
+	if timeouts.dial > 0 {
+		cfg.DialTimeout = timeouts.dial
+	}
+	return httpclient.NewTransport(cfg)
+}
+
+// readBody reads and, when needed, decompresses a service-b response body,
+// recording its wire and decoded sizes.
+func readBody(resp *http.Response) ([]byte, error) {
+	wire, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	encoding := "identity"
+	decoded := wire
+	if resp.Header.Get("Content-Encoding") == "gzip" {
+		encoding = "gzip"
+		zr, err := gzip.NewReader(bytes.NewReader(wire))
+		if err != nil {
+			return nil, err
+		}
+		defer zr.Close()
+		if decoded, err = io.ReadAll(zr); err != nil {
+			return nil, err
+		}
+	}
+
+	internalPayloadBytes.WithLabelValues(encoding, "wire").Add(float64(len(wire)))
+	internalPayloadBytes.WithLabelValues(encoding, "decoded").Add(float64(len(decoded)))
+	return decoded, nil
+}
+
+// compressibleTypes are the response media types RESPONSE_COMPRESSION compresses.
+var compressibleTypes = []string{mediaJSON, mediaXML, mediaCSV, ndjsonContentType, "application/problem+json"}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"strings"
+
+	"goexpert-lab-2-observabilidade/internal/apperr"
+	"goexpert-lab-2-observabilidade/internal/httpclient"
+
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+)
+
+// requiredSettings must be set for the service to start, with what each is for.
+var requiredSettings = []struct {
+	key, purpose string
+}{
+	{"OTEL_SERVICE_NAME", "service name reported on traces, logs and metrics"},
+	{"OTEL_EXPORTER_OTLP_ENDPOINT", "host:port of the OTLP/HTTP collector, e.g. otel-collector:4318"},
+	{"SERVICE_B_URL", "base URL of service-b, e.g. http://service-b:8081"},
+}
+
+// settingFlags are named flags for the settings most often tweaked in ad-hoc
+// runs and integration tests, bound to their keys.
+var settingFlags = []struct {
+	name, key, usage string
+}{
+	{"port", "PORT", "API listener port"},
+	{"admin-port", "ADMIN_PORT", "admin listener port"},
+	{"log-level", "LOG_LEVEL", "debug, info, warn or error"},
+	{"otlp-endpoint", "OTEL_EXPORTER_OTLP_ENDPOINT", "host:port of the OTLP/HTTP collector"},
+	{"service-b-url", "SERVICE_B_URL", "base URL of service-b"},
+}
+
+// configSummaryKeys are the settings worth seeing at a glance while triaging.
+var configSummaryKeys = []string{
+	"OTEL_SERVICE_NAME",
+	"OTEL_EXPORTER_OTLP_ENDPOINT",
+	"PORT",
+	"ADMIN_PORT",
+	"SERVICE_B_URL",
+	"LOG_LEVEL",
+	"ERROR_FORMAT",
+	"AUTH_MODE",
+	"INTERNAL_COMPRESSION",
+	"PREWARM_ENABLED",
+	"ENABLE_PPROF",
+	"SLO_TARGET",
+	"SLO_WINDOW",
+}
+
+// loadConfig layers the settings on top of the defaults set in init, from
+// highest precedence: flags (settingFlags and --set), environment variables, the config file
+// (--config, or ./config.yaml when present), the dotenv file (--env-file,
+// default ./.env), then the defaults. Keys are the environment variable
+// names, case-insensitive.
+func loadConfig(args []string) error {
+	flags := pflag.NewFlagSet(args[0], pflag.ContinueOnError)
+	configFile := flags.String("config", "", "config file (default ./config.yaml when present)")
+	envFile := flags.String("env-file", ".env", "dotenv file, read when present")
+	overrides := flags.StringArray("set", nil, "override a setting as KEY=value; repeatable")
+	for _, f := range settingFlags {
+		flags.String(f.name, "", f.usage+" ("+f.key+")")
+	}
+	if err := flags.Parse(args[1:]); errors.Is(err, pflag.ErrHelp) {
+		os.Exit(0)
+	} else if err != nil {
+		return err
+	}
+	for _, f := range settingFlags {
+		if err := viper.BindPFlag(f.key, flags.Lookup(f.name)); err != nil {
+			return err
+		}
+	}
+
+	if err := mergeConfigFile(*envFile, flags.Changed("env-file")); err != nil {
+		return err
+	}
+	if *configFile != "" {
+		if err := mergeConfigFile(*configFile, true); err != nil {
+			return err
+		}
+	} else if err := mergeConfigFile("config.yaml", false); err != nil {
+		return err
+	}
+
+	for _, kv := range *overrides {
+		key, value, ok := strings.Cut(kv, "=")
+		if !ok || key == "" {
+			return fmt.Errorf("invalid --set %q, expected KEY=value", kv)
+		}
+		viper.Set(key, value)
+	}
+	return nil
+}
+
+// unsetSettings are the settings without a default; viper only knows keys
+// that have a default or come from a file or flag, so these are listed to
+// show up in /debug/config even when unset.
+var unsetSettings = []string{
+	"OTEL_SERVICE_NAME",
+	"OTEL_EXPORTER_OTLP_ENDPOINT",
+	"REQUEST_NAME_OTEL",
+	"SERVICE_B_URL",
+	"AUTH_MODE",
+	"AUTH_API_KEYS",
+	"AUTH_OIDC_ISSUER",
+	"AUTH_JWT_AUDIENCE",
+	"AUTH_JWT_SECRET",
+	"AUTH_CLIENT_CA_FILE",
+	"TLS_CERT_FILE",
+	"TLS_KEY_FILE",
+	"AUDIT_LOG_FILE",
+	"LOKI_URL",
+	"LOKI_LABELS",
+	"PUSHGATEWAY_URL",
+}
+
+// configFiles are the config files loaded, in merge order.
+var configFiles []string
+
+// mergeConfigFile merges path into the config, its format taken from the
+// extension; a missing file is only an error when required.
+func mergeConfigFile(path string, required bool) error {
+	if path == "" {
+		return nil
+	}
+	if _, err := os.Stat(path); errors.Is(err, fs.ErrNotExist) && !required {
+		return nil
+	}
+	viper.SetConfigFile(path)
+	if err := viper.MergeInConfig(); err != nil {
+		return fmt.Errorf("failed to read config file %s: %w", path, err)
+	}
+	configFiles = append(configFiles, path)
+	return nil
+}
+
+// validateConfig checks the whole configuration at startup and reports every
+// problem at once, so a bad deploy fails fast with the full list to fix
+// instead of one setting per restart or at the first request.
+func validateConfig() error {
+	var errs []error
+	for _, s := range requiredSettings {
+		if strings.TrimSpace(viper.GetString(s.key)) == "" {
+			errs = append(errs, fmt.Errorf("%s is required: %s", s.key, s.purpose))
+		}
+	}
+	switch f := viper.GetString("ERROR_FORMAT"); f {
+	case apperr.FormatText, apperr.FormatProblem:
+	default:
+		errs = append(errs, fmt.Errorf("ERROR_FORMAT %q must be %q or %q", f, apperr.FormatText, apperr.FormatProblem))
+	}
+	if raw := viper.GetString("SERVICE_B_URL"); raw != "" {
+		if _, err := parseServiceBURL(raw); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	switch t := viper.GetString("SERVICE_B_TRANSPORT"); t {
+	case transportHTTP, transportGRPC:
+	default:
+		errs = append(errs, fmt.Errorf("SERVICE_B_TRANSPORT %q must be %q or %q", t, transportHTTP, transportGRPC))
+	}
+	if d := viper.GetDuration("WS_UPDATE_INTERVAL"); d <= 0 {
+		errs = append(errs, fmt.Errorf("WS_UPDATE_INTERVAL (%s) must be positive", d))
+	}
+	if n := viper.GetInt("WS_MAX_SUBSCRIPTIONS"); n < 1 {
+		errs = append(errs, fmt.Errorf("WS_MAX_SUBSCRIPTIONS (%d) must be at least 1", n))
+	}
+	// the error budget is 1 - SLO_TARGET, which burn rates divide by
+	if t := viper.GetFloat64("SLO_TARGET"); t <= 0 || t >= 1 {
+		errs = append(errs, fmt.Errorf("SLO_TARGET (%g) must be between 0 and 1, exclusive", t))
+	}
+	if d := viper.GetDuration("SLO_WINDOW"); d < sloResolution {
+		errs = append(errs, fmt.Errorf("SLO_WINDOW (%s) must be at least %s", d, sloResolution))
+	}
+	errs = append(errs, loadWebhookConfig().Validate())
+	errs = append(errs, loadTimeouts().Validate())
+	errs = append(errs, loadBreakerConfig().Validate())
+	errs = append(errs, httpclient.LoadPool().Validate())
+	return errors.Join(errs...)
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/viper"
+
+	"goexpert-lab-2-observabilidade/internal/apperr"
+	"goexpert-lab-2-observabilidade/internal/middleware"
+)
+
+// corsExposedHeaders are the response headers browser code may read.
+var corsExposedHeaders = []string{
+	middleware.RequestIDHeader, apperr.TypeHeader, "Deprecation", "Link", "Content-Language",
+	"Retry-After", "X-RateLimit-Limit", "X-RateLimit-Remaining", "X-RateLimit-Reset",
+}
+
+// corsPolicy answers CORS preflights and tags responses for allowed origins.
+type corsPolicy struct {
+	anyOrigin bool
+	origins   map[string]bool
+	methods   string
+	headers   string
+	maxAge    time.Duration
+}
+
+// newCORSPolicy reads the CORS_* settings; it returns nil, and CORS stays
+// off, when CORS_ALLOWED_ORIGINS is empty.
+func newCORSPolicy() *corsPolicy {
+	origins := splitList(viper.GetString("CORS_ALLOWED_ORIGINS"))
+	if len(origins) == 0 {
+		return nil
+	}
+	c := &corsPolicy{
+		origins: make(map[string]bool),
+		methods: strings.Join(splitList(viper.GetString("CORS_ALLOWED_METHODS")), ", "),
+		headers: strings.Join(splitList(viper.GetString("CORS_ALLOWED_HEADERS")), ", "),
+		maxAge:  viper.GetDuration("CORS_MAX_AGE"),
+	}
+	for _, origin := range origins {
+		if origin == "*" {
+			c.anyOrigin = true
+		}
+		c.origins[origin] = true
+	}
+	return c
+}
+
+// splitList parses a comma separated setting, dropping empty entries.
+func splitList(raw string) []string {
+	var items []string
+	for _, item := range strings.Split(raw, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+func (c *corsPolicy) Middleware(next http.Handler) http.Handler {
+	if c == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		w.Header().Add("Vary", "Origin")
+		if origin == "" || !(c.anyOrigin || c.origins[origin]) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if c.anyOrigin {
+			w.Header().Set("Access-Control-Allow-Origin", "*")
+		} else {
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+		}
+
+		if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+			w.Header().Set("Access-Control-Allow-Methods", c.methods)
+			w.Header().Set("Access-Control-Allow-Headers", c.headers)
+			w.Header().Set("Access-Control-Max-Age", strconv.Itoa(int(c.maxAge.Seconds())))
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		w.Header().Set("Access-Control-Expose-Headers", strings.Join(corsExposedHeaders, ", "))
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,19 @@
+package main
+
+import "goexpert-lab-2-observabilidade/internal/degradation"
+
+// The expensive, optional capabilities turned off under pressure.
+const (
+	featureForecast      degradation.Feature = "forecast"
+	featureBatch         degradation.Feature = "batch"
+	featureSubscriptions degradation.Feature = "subscriptions"
+	featureDebugCapture  degradation.Feature = "debug_capture"
+)
+
+// shedAt is the lowest level at which each feature gets disabled.
+var shedAt = map[degradation.Feature]degradation.Level{
+	featureDebugCapture:  degradation.Degraded,
+	featureForecast:      degradation.Critical,
+	featureBatch:         degradation.Critical,
+	featureSubscriptions: degradation.Critical,
+}
@@ -0,0 +1,26 @@
+package main
+
+import "goexpert-lab-2-observabilidade/internal/middleware"
+
+// The optional capabilities flagged with middleware.MarkFeature when they take
+// part in serving a request.
+const (
+	usesCacheMemory middleware.Feature = 1 << iota
+	usesCacheRedis
+	usesProviderFallback
+	usesRetry
+	usesHedging
+	usesCompression
+	usesDegradedMode
+)
+
+// featureNames must list every bit, in bit order; the bitset attribute is decoded with it.
+var featureNames = []string{
+	"cache_memory",
+	"cache_redis",
+	"provider_fallback",
+	"retry",
+	"hedging",
+	"compression",
+	"degraded_mode",
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"context"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync/atomic"
+
+	"github.com/graph-gophers/graphql-go"
+	gqlerrors "github.com/graph-gophers/graphql-go/errors"
+	gqlotel "github.com/graph-gophers/graphql-go/trace/otel"
+
+	"goexpert-lab-2-observabilidade/internal/admin"
+	"goexpert-lab-2-observabilidade/internal/api"
+	"goexpert-lab-2-observabilidade/internal/apperr"
+	"goexpert-lab-2-observabilidade/internal/logging"
+)
+
+// graphQLSchema is the schema /graphql resolves, in SDL.
+//
+//go:embed schema.graphql
+var graphQLSchema string
+
+// graphQLSchemaHandler serves graphQLSchema, for client code generators.
+func graphQLSchemaHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/graphql; charset=utf-8")
+	w.Write([]byte(graphQLSchema))
+}
+
+// newGraphQLSchema parses graphQLSchema with the resolvers of h. Fields are
+// resolved by up to BATCH_WORKERS goroutines per query, as batches are, and
+// traced as children of the request span.
+func newGraphQLSchema(h *handler) *graphql.Schema {
+	return graphql.MustParseSchema(graphQLSchema, &queryResolver{h: h},
+		graphql.UseStringDescriptions(),
+		graphql.MaxParallelism(h.batch.workers),
+		graphql.Tracer(&gqlotel.Tracer{Tracer: h.tracer}),
+	)
+}
+
+// graphQLQuery is the state of one query, shared by its resolvers.
+type graphQLQuery struct {
+	r       *http.Request
+	lookups atomic.Int32
+}
+
+type graphQLQueryKey struct{}
+
+// queryResolver resolves the Query type.
+type queryResolver struct {
+	h *handler
+}
+
+// Temperature looks the CEP up like one of a batch, so a query can ask for
+// several CEPs, of any countries, under different aliases, up to
+// BATCH_MAX_CEPS.
+func (q *queryResolver) Temperature(ctx context.Context, args struct {
+	Cep     string
+	Country *string
+}) (*temperatureResolver, error) {
+	query := ctx.Value(graphQLQueryKey{}).(*graphQLQuery)
+	r := query.r.WithContext(ctx)
+
+	i := int(query.lookups.Add(1)) - 1
+	if i >= q.h.batch.maxCEPs {
+		e := apperr.Record(ctx, apperr.ErrBatchTooLarge.WithFields(apperr.FieldError{Field: "query", Message: fmt.Sprintf("must have at most %d temperature fields", q.h.batch.maxCEPs)}))
+		return nil, &graphQLFieldError{&batchError{Type: e.Type, Message: e.Localized(apperr.Language(r)), Fields: e.Fields()}}
+	}
+	var country string
+	if args.Country != nil {
+		country = *args.Country
+	}
+	item := q.h.lookup(r, "batch lookup", i, api.NormalizeCountry(country), args.Cep)
+	if item.Error != nil {
+		return nil, &graphQLFieldError{item.Error}
+	}
+	return &temperatureResolver{*item.Result}, nil
+}
+
+// graphQLFieldError is a failed lookup, reported in errors with its apperr
+// type in extensions.code.
+type graphQLFieldError struct {
+	*batchError
+}
+
+func (e *graphQLFieldError) Error() string { return e.Message }
+
+func (e *graphQLFieldError) Extensions() map[string]any {
+	return errorExtensions(e.Type, e.Fields)
+}
+
+// temperatureResolver resolves the Temperature type.
+type temperatureResolver struct {
+	t api.Temperature
+}
+
+func (t *temperatureResolver) City() string         { return t.t.City }
+func (t *temperatureResolver) TempC() float64       { return t.t.TempC }
+func (t *temperatureResolver) TempF() float64       { return t.t.TempF }
+func (t *temperatureResolver) TempK() float64       { return t.t.TempK }
+func (t *temperatureResolver) Condition() *string   { return optional(t.t.Condition) }
+func (t *temperatureResolver) Timezone() *string    { return optional(t.t.Timezone) }
+func (t *temperatureResolver) LocalTime() *string   { return optional(t.t.LocalTime) }
+func (t *temperatureResolver) ObservedAt() *string  { return optional(t.t.ObservedAt) }
+func (t *temperatureResolver) RetrievedAt() *string { return optional(t.t.RetrievedAt) }
+
+// optional resolves an empty string as null.
+func optional(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return &s
+}
+
+type graphQLRequest struct {
+	Query         string         `json:"query"`
+	OperationName string         `json:"operationName,omitempty"`
+	Variables     map[string]any `json:"variables,omitempty"`
+
+	// Extensions is accepted and ignored, as some clients always send it.
+	Extensions json.RawMessage `json:"extensions,omitempty"`
+}
+
+// graphQLHandler serves /graphql, with the query in a JSON body on POST or
+// in the query, operationName and variables parameters on GET. Failed
+// lookups are null, with their error in errors; queries that don't parse or
+// validate against the schema get a 400 invalid_query.
+func (h *handler) graphQLHandler(w http.ResponseWriter, r *http.Request) {
+	req, err := readGraphQLRequest(r)
+	if err != nil {
+		graphQLRequestError(w, r, err)
+		return
+	}
+
+	ctx := context.WithValue(r.Context(), graphQLQueryKey{}, &graphQLQuery{r: r})
+	resp := h.graphQL.Exec(ctx, req.Query, req.OperationName, req.Variables)
+	if resp.Data == nil && len(resp.Errors) > 0 {
+		graphQLRequestError(w, r, apperr.ErrInvalidQuery.Wrap(queryErrors(resp.Errors)))
+		return
+	}
+	api.WriteJSON(w, r, http.StatusOK, resp)
+}
+
+// queryErrors are the reasons a query was rejected before it ran.
+type queryErrors []*gqlerrors.QueryError
+
+func (e queryErrors) Error() string {
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Message
+	}
+	return strings.Join(msgs, "; ")
+}
+
+func errorExtensions(typ string, fields []apperr.FieldError) map[string]any {
+	ext := map[string]any{"code": typ}
+	if len(fields) > 0 {
+		ext["fields"] = fields
+	}
+	return ext
+}
+
+// readGraphQLRequest reads the request from the body on POST and from the
+// query string on GET.
+func readGraphQLRequest(r *http.Request) (graphQLRequest, error) {
+	var req graphQLRequest
+	if r.Method == http.MethodPost {
+		if err := api.DecodeJSON(r, &req); err != nil {
+			return req, err
+		}
+	} else {
+		q := r.URL.Query()
+		req.Query, req.OperationName = q.Get("query"), q.Get("operationName")
+		if v := q.Get("variables"); v != "" {
+			if err := json.Unmarshal([]byte(v), &req.Variables); err != nil {
+				return req, apperr.ErrInvalidParam.WithFields(apperr.FieldError{Field: "variables", Message: "must be a JSON object"})
+			}
+		}
+	}
+	if req.Query == "" {
+		return req, apperr.ErrInvalidQuery.WithFields(apperr.FieldError{Field: "query", Message: "is required"})
+	}
+	return req, nil
+}
+
+// graphQLRequestError is api.Error for /graphql: the error is counted,
+// sampled and logged the same way, but answered as a GraphQL response.
+func graphQLRequestError(w http.ResponseWriter, r *http.Request, err error) {
+	e := apperr.Record(r.Context(), err)
+	admin.RecentErrors.Record(r, e)
+	if ok, suppressed := logging.ErrorSampler.Allow(e.Type); ok {
+		slog.WarnContext(r.Context(), "request failed",
+			"type", e.Type, "status", e.Status, "error", e.Error(), "suppressed", suppressed)
+	}
+
+	lang := apperr.Language(r)
+	extensions := errorExtensions(e.Type, e.Fields())
+	gqlErrs := []*gqlerrors.QueryError{{Message: e.Localized(lang), Extensions: extensions}}
+	var rejected queryErrors
+	if errors.As(err, &rejected) {
+		// the query problems are more useful to client developers than the error type
+		gqlErrs = make([]*gqlerrors.QueryError, len(rejected))
+		for i, q := range rejected {
+			gqlErrs[i] = &gqlerrors.QueryError{Message: q.Message, Locations: q.Locations, Extensions: extensions}
+		}
+	}
+
+	w.Header().Set(apperr.TypeHeader, e.Type)
+	w.Header().Set("Content-Language", lang)
+	w.Header().Add("Vary", "Accept-Language")
+	api.WriteJSON(w, r, e.Status, graphql.Response{Errors: gqlErrs})
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/encoding/gzip"
+	"google.golang.org/grpc/metadata"
+
+	"goexpert-lab-2-observabilidade/internal/api"
+	"goexpert-lab-2-observabilidade/internal/apperr"
+	"goexpert-lab-2-observabilidade/internal/middleware"
+	"goexpert-lab-2-observabilidade/internal/temperaturepb"
+)
+
+// Transports service-a can reach service-b with, chosen by SERVICE_B_TRANSPORT.
+const (
+	transportHTTP = "http"
+	transportGRPC = "grpc"
+)
+
+// newTemperatureClient connects to service-b's gRPC API at addr. The
+// connection is established lazily, on the first call. otelgrpc wraps each
+// call in a client span, propagates the trace to service-b and records the
+// rpc.client.* metrics on reg.
+func newTemperatureClient(addr string, reg prometheus.Registerer) (temperaturepb.TemperatureServiceClient, *grpc.ClientConn, error) {
+	meterProvider, err := middleware.RPCMeterProvider(reg)
+	if err != nil {
+		return nil, nil, err
+	}
+	conn, err := grpc.NewClient(addr,
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithStatsHandler(otelgrpc.NewClientHandler(otelgrpc.WithMeterProvider(meterProvider))),
+	)
+	if err != nil {
+		return nil, nil, err
+	}
+	return temperaturepb.NewTemperatureServiceClient(conn), conn, nil
+}
+
+// fetchTemperatureGRPC is fetchTemperature over gRPC; the deadline of ctx
+// travels with the call.
+func (h *handler) fetchTemperatureGRPC(ctx context.Context, country, cep string) (api.Temperature, error) {
+	ctx = metadata.AppendToOutgoingContext(ctx, middleware.RequestIDHeader, middleware.RequestIDFromContext(ctx))
+	var opts []grpc.CallOption
+	if h.internalCompression {
+		opts = append(opts, grpc.UseCompressor(gzip.Name))
+		middleware.MarkFeature(ctx, usesCompression)
+	}
+
+	resp, err := h.temperatureClient.GetTemperatureByZipcode(ctx, &temperaturepb.GetTemperatureByZipcodeRequest{Zipcode: cep, Country: country}, opts...)
+	if err != nil {
+		e := apperr.FromStatus(err)
+		h.degradation.ObserveDependency("service-b", e.Status < http.StatusInternalServerError)
+		if e.Status >= http.StatusInternalServerError {
+			observeProviderError("service-b", err)
+		}
+		return api.Temperature{}, e
+	}
+	h.degradation.ObserveDependency("service-b", true)
+
+	return api.Temperature{
+		City:      resp.GetCity(),
+		TempC:     resp.GetTempC(),
+		TempF:     resp.GetTempF(),
+		TempK:     resp.GetTempK(),
+		Condition: resp.GetCondition(),
+		Timezone:  resp.GetTimezone(),
+		LocalTime: resp.GetLocalTime(),
+
+		ObservedAt:  resp.GetObservedAt(),
+		RetrievedAt: resp.GetRetrievedAt(),
+		Cached:      resp.GetCached(),
+		Stale:       resp.GetStale(),
+	}, nil
+}
@@ -3,15 +3,18 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
 	"os"
 	"os/signal"
-	"regexp"
+	"strings"
+	"syscall"
 	"time"
 
-	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/go-chi/chi/v5"
+	"github.com/graph-gophers/graphql-go"
 	"github.com/spf13/viper"
 	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
 	"go.opentelemetry.io/otel"
@@ -21,18 +24,17 @@ import (
 	sdktrace "go.opentelemetry.io/otel/sdk/trace"
 	semconv "go.opentelemetry.io/otel/semconv/v1.21.0"
 	"go.opentelemetry.io/otel/trace"
-)
-
-type ZipCodeRequest struct {
-	CEP string `json:"cep"`
-}
 
-type ZipCodeResponse struct {
-	City  string  `json:"city"`
-	TempC float64 `json:"temp_C"`
-	TempF float64 `json:"temp_F"`
-	TempK float64 `json:"temp_K"`
-}
+	"goexpert-lab-2-observabilidade/internal/admin"
+	"goexpert-lab-2-observabilidade/internal/api"
+	"goexpert-lab-2-observabilidade/internal/apperr"
+	"goexpert-lab-2-observabilidade/internal/config"
+	"goexpert-lab-2-observabilidade/internal/degradation"
+	"goexpert-lab-2-observabilidade/internal/httpclient"
+	"goexpert-lab-2-observabilidade/internal/logging"
+	"goexpert-lab-2-observabilidade/internal/middleware"
+	"goexpert-lab-2-observabilidade/internal/temperaturepb"
+)
 
 func initProvider(serviceName, collectorURL string) (func(context.Context) error, error) {
 	ctx := context.Background()
@@ -80,112 +82,503 @@ func initProvider(serviceName, collectorURL string) (func(context.Context) error
 // load env vars cfg
 func init() {
 	viper.AutomaticEnv()
+	viper.SetDefault("PORT", "8080")
+	viper.SetDefault("ADMIN_PORT", "9090")
+	viper.SetDefault("LOG_LEVEL", "info")
+	viper.SetDefault("LOG_FORMAT", "json")
+	viper.SetDefault("ERROR_FORMAT", "text")
+	viper.SetDefault("ACCESS_LOG_EXCLUDE", "/metrics,/healthz,/readyz")
+	viper.SetDefault("LOG_SAMPLE_INTERVAL", "1s")
+	viper.SetDefault("LOG_SAMPLE_BURST", 10)
+	viper.SetDefault("LOG_SAMPLE_EVERY", 100)
+	viper.SetDefault("DEGRADED_LATENCY", "1s")
+	viper.SetDefault("CRITICAL_LATENCY", "3s")
+	viper.SetDefault("DEGRADED_ERROR_RATIO", 0.2)
+	viper.SetDefault("CRITICAL_ERROR_RATIO", 0.5)
+	viper.SetDefault("PREWARM_ENABLED", true)
+	viper.SetDefault("PREWARM_IDLE", "60s")
+	viper.SetDefault("SERVICE_B_TRANSPORT", transportHTTP)
+	viper.SetDefault("SERVICE_B_GRPC_ADDR", "localhost:50051")
+	viper.SetDefault("INTERNAL_COMPRESSION", false)
+	viper.SetDefault("RESPONSE_COMPRESSION", true)
+	viper.SetDefault("INTERNAL_HTTP2", false)
+	viper.SetDefault("SHUTDOWN_DRAIN_DELAY", "0s")
+	viper.SetDefault("SHUTDOWN_TIMEOUT", "10s")
+	viper.SetDefault("READINESS_CACHE_TTL", "10s")
+	viper.SetDefault("READINESS_TIMEOUT", "2s")
+	viper.SetDefault("ENABLE_PPROF", false)
+	viper.SetDefault("ENABLE_DOCS", false)
+	viper.SetDefault("CORS_ALLOWED_ORIGINS", "")
+	viper.SetDefault("CORS_ALLOWED_METHODS", "GET,POST")
+	viper.SetDefault("CORS_ALLOWED_HEADERS", "Accept,Accept-Language,Authorization,Content-Type,X-API-Key,X-Request-ID")
+	viper.SetDefault("CORS_MAX_AGE", "10m")
+	viper.SetDefault("RATE_LIMIT_RPS", 0)
+	viper.SetDefault("RATE_LIMIT_BURST", 20)
+	viper.SetDefault("RATE_LIMIT_IP_RPS", 0)
+	viper.SetDefault("RATE_LIMIT_IP_BURST", 40)
+	viper.SetDefault("READ_HEADER_TIMEOUT", "5s")
+	viper.SetDefault("READ_TIMEOUT", "10s")
+	viper.SetDefault("IDLE_TIMEOUT", "120s")
+	viper.SetDefault("HANDLER_TIMEOUT", "7s")
+	viper.SetDefault("SERVICE_B_TIMEOUT", "6s")
+	viper.SetDefault("CIRCUIT_BREAKER_FAILURES", 5)
+	viper.SetDefault("CIRCUIT_BREAKER_OPEN_TIMEOUT", "30s")
+	viper.SetDefault("DIAL_TIMEOUT", "500ms")
+	viper.SetDefault("HTTP_MAX_IDLE_CONNS", 100)
+	viper.SetDefault("HTTP_MAX_IDLE_CONNS_PER_HOST", 10)
+	viper.SetDefault("HTTP_MAX_CONNS_PER_HOST", 0)
+	viper.SetDefault("HTTP_IDLE_CONN_TIMEOUT", "90s")
+	viper.SetDefault("SLO_TARGET", 0.995)
+	viper.SetDefault("SLO_WINDOW", "720h")
+	viper.SetDefault("BATCH_MAX_CEPS", 50)
+	viper.SetDefault("BATCH_WORKERS", 8)
+	viper.SetDefault("WS_UPDATE_INTERVAL", "60s")
+	viper.SetDefault("WS_MAX_SUBSCRIPTIONS", 10)
+	viper.SetDefault("WEBHOOK_EVAL_INTERVAL", "60s")
+	viper.SetDefault("WEBHOOK_MAX", 100)
+	viper.SetDefault("WEBHOOK_MAX_ATTEMPTS", 5)
+	viper.SetDefault("WEBHOOK_RETRY_BACKOFF", "1s")
+	viper.SetDefault("WEBHOOK_TIMEOUT", "5s")
+	viper.SetDefault("REQUEST_MAX_BYTES", 16<<10)
 }
 
 type handler struct {
-	tracer trace.Tracer
+	tracer      trace.Tracer
+	degradation *degradation.Tracker
+	auth        Authenticator
+	cors        *corsPolicy // also checks the Origin of /ws upgrades
+	client      *http.Client
+	serviceBURL string
+	audit       *auditLog
+	batch       batch
+
+	requestName         string // REQUEST_NAME_OTEL
+	internalCompression bool   // INTERNAL_COMPRESSION
+	maxRequestBytes     int64  // REQUEST_MAX_BYTES, for WebSocket messages too
+
+	// temperatureClient is set with SERVICE_B_TRANSPORT=grpc, and used instead of client.
+	temperatureClient temperaturepb.TemperatureServiceClient
+	serviceBBreaker   *circuitBreaker
+
+	subscriptions *subscriptions
+	graphQL       *graphql.Schema
 }
 
 func main() {
 
-	sigCh := make(chan os.Signal, 1)
-	signal.Notify(sigCh, os.Interrupt)
-
-	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
 	defer cancel()
 
+	if err := loadConfig(os.Args); err != nil {
+		logging.Fatal("failed to load configuration", err)
+	}
+
+	logger, closeLogs := logging.NewLogger(viper.GetString("OTEL_SERVICE_NAME"))
+	defer closeLogs()
+	slog.SetDefault(logger)
+	logging.ErrorSampler = logging.NewSampler(viper.GetDuration("LOG_SAMPLE_INTERVAL"), viper.GetInt("LOG_SAMPLE_BURST"), viper.GetInt("LOG_SAMPLE_EVERY"))
+
+	if err := validateConfig(); err != nil {
+		logging.Fatal("invalid configuration", err)
+	}
+	if err := apperr.SetFormat(viper.GetString("ERROR_FORMAT")); err != nil {
+		logging.Fatal("invalid ERROR_FORMAT", err)
+	}
+	timeouts := loadTimeouts()
+	activeTimeouts.Store(&timeouts)
+
 	shutdown, err := initProvider(viper.GetString("OTEL_SERVICE_NAME"), viper.GetString("OTEL_EXPORTER_OTLP_ENDPOINT"))
 	if err != nil {
-		log.Fatal(err)
+		logging.Fatal("failed to initialize TracerProvider", err)
 	}
 	defer func() {
-		if err := shutdown(ctx); err != nil {
-			log.Fatal("failed to shutdown TracerProvider: %w", err)
+		//flush the spans of the drained requests; ctx is already canceled here
+		flushCtx, flushCancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer flushCancel()
+		if err := shutdown(flushCtx); err != nil {
+			slog.Error("failed to shutdown TracerProvider", "error", err)
 		}
 	}()
 
 	tracer := otel.Tracer("service-a")
 
+	auth, err := newAuthenticator(ctx)
+	if err != nil {
+		logging.Fatal("failed to configure authentication", err)
+	}
+
+	serviceBURL, err := parseServiceBURL(viper.GetString("SERVICE_B_URL"))
+	if err != nil {
+		logging.Fatal("invalid configuration", err)
+	}
+
+	//h2c only applies to a cleartext service-b; https negotiates HTTP/2 by itself
+	h2c := viper.GetBool("INTERNAL_HTTP2") && strings.HasPrefix(serviceBURL, "http://")
+	upstream := httpclient.NewPrewarmer(otelhttp.NewTransport(newServiceBTransport("service-b", timeouts, h2c)), tracer, viper.GetDuration("PREWARM_IDLE"), map[string]string{
+		"service-b": serviceBURL,
+	})
+	if viper.GetBool("PREWARM_ENABLED") {
+		go upstream.Run(ctx)
+	}
+
+	objective := newSLO(viper.GetFloat64("SLO_TARGET"), viper.GetDuration("SLO_WINDOW"))
+	reg := newRegistry(objective)
+
+	var temperatureClient temperaturepb.TemperatureServiceClient
+	if viper.GetString("SERVICE_B_TRANSPORT") == transportGRPC {
+		client, conn, err := newTemperatureClient(viper.GetString("SERVICE_B_GRPC_ADDR"), reg)
+		if err != nil {
+			logging.Fatal("failed to configure the gRPC client", err)
+		}
+		defer conn.Close()
+		temperatureClient = client
+	}
+
+	audit, auditFile, err := newAuditLog(viper.GetString("AUDIT_LOG_FILE"))
+	if err != nil {
+		logging.Fatal("failed to configure audit log", err)
+	}
+	defer auditFile.Close()
+
 	h := &handler{
-		tracer: tracer,
+		tracer:      tracer,
+		degradation: degradation.New(shedAt),
+		auth:        auth,
+		cors:        newCORSPolicy(),
+		client:      upstream.Client,
+		serviceBURL: serviceBURL,
+		audit:       audit,
+
+		requestName:         viper.GetString("REQUEST_NAME_OTEL"),
+		internalCompression: viper.GetBool("INTERNAL_COMPRESSION"),
+		maxRequestBytes:     viper.GetInt64("REQUEST_MAX_BYTES"),
+		batch:               batch{maxCEPs: viper.GetInt("BATCH_MAX_CEPS"), workers: max(viper.GetInt("BATCH_WORKERS"), 1)},
+
+		temperatureClient: temperatureClient,
+		serviceBBreaker:   newCircuitBreaker("service-b", loadBreakerConfig()),
+		subscriptions:     newSubscriptions(viper.GetDuration("WS_UPDATE_INTERVAL"), viper.GetInt("WS_MAX_SUBSCRIPTIONS")),
+	}
+
+	h.graphQL = newGraphQLSchema(h)
+
+	hooks := newWebhooks(loadWebhookConfig(), tracer, h.fetchBatchItem)
+	go hooks.Run(ctx)
+
+	//operational endpoints live on a separate listener
+	requestStats := admin.NewStats()
+
+	diag := admin.NewDiagnostics(configSummaryKeys...)
+	diag.Register("dependencies", func() any { return h.degradation.Snapshot() })
+	diag.Register("stats", func() any { return requestStats.Snapshot() })
+	diag.Register("slo", func() any { return objective.report() })
+	if pushURL := viper.GetString("PUSHGATEWAY_URL"); pushURL != "" {
+		job := viper.GetString("OTEL_SERVICE_NAME")
+		defer func() {
+			if err := pushMetrics(reg, pushURL, job); err != nil {
+				slog.Error("failed to push metrics", "error", err)
+			}
+		}()
+	}
+
+	drain := admin.NewDrainer(viper.GetDuration("SHUTDOWN_TIMEOUT"))
+	excluded := logging.AccessLogExclusions(viper.GetString("ACCESS_LOG_EXCLUDE"))
+	limiter := newRateLimiter("caller", rateLimitKey, viper.GetFloat64("RATE_LIMIT_RPS"), viper.GetInt("RATE_LIMIT_BURST"))
+	//ahead of authentication, so failed attempts are limited too
+	ipLimiter := newRateLimiter("ip", clientIP, viper.GetFloat64("RATE_LIMIT_IP_RPS"), viper.GetInt("RATE_LIMIT_IP_BURST"))
+
+	//each middleware wraps the ones listed after it
+	router := chi.NewRouter()
+	router.Use(
+		drain.Middleware,
+		middleware.RequestID,
+		logging.AccessLog(excluded),
+		middleware.RequestMetrics,
+		h.cors.Middleware, // answers preflights before they count as traffic
+		requestStats.Middleware,
+		h.degradation.Middleware,
+		timeoutMiddleware,
+		bodyLimitMiddleware(h.maxRequestBytes),
+	)
+	if viper.GetBool("RESPONSE_COMPRESSION") {
+		router.Use(middleware.Compress(compressibleTypes...))
+	}
+	temperature := router.With(
+		sliMiddleware(objective),
+		otelhttp.NewMiddleware("ZipCodeHandler"),
+		middleware.Recover(api.Error), // inside tracing, so panics land on the request span
+		logging.CaptureTraceID,
+		middleware.TagRequestID,
+		middleware.Features(featureNames...),
+		ipLimiter.Middleware,
+		authMiddleware(h.auth),
+		limiter.Middleware,
+	)
+	temperature.Post("/v1/temperature", h.zipCodeHandler)
+	temperature.Get("/v1/temperature/{cep}", h.temperatureByCEPHandler)
+	temperature.Post("/v1/temperatures", h.batchHandler)
+	temperature.Post("/v1/compare", h.compareHandler)
+	temperature.Get("/graphql", h.graphQLHandler)
+	temperature.Post("/graphql", h.graphQLHandler)
+	temperature.Post("/v1/webhooks", hooks.createHandler)
+	temperature.Get("/v1/webhooks", hooks.listHandler)
+	temperature.Get("/v1/webhooks/{id}", hooks.getHandler)
+	temperature.Delete("/v1/webhooks/{id}", hooks.deleteHandler)
+	temperature.With(middleware.Deprecated("/zipcode", "/v1/temperature")).Post("/zipcode", h.zipCodeHandler)
+	//long-lived, so kept out of the SLI and of the per-request feature counts
+	router.With(
+		otelhttp.NewMiddleware("WebSocket"),
+		middleware.Recover(api.Error),
+		logging.CaptureTraceID,
+		middleware.TagRequestID,
+		ipLimiter.Middleware,
+		authMiddleware(h.auth),
+		limiter.Middleware,
+	).Get("/ws", h.webSocketHandler)
+	router.MethodNotAllowed(middleware.MethodNotAllowed(router, api.Error))
+	docs := router.With(limiter.Middleware)
+	docs.Get("/openapi.json", openAPIHandler())
+	docs.Get("/graphql/schema.graphql", graphQLSchemaHandler)
+	if viper.GetBool("ENABLE_DOCS") {
+		docs.Get("/docs", api.Docs("service-a"))
+	}
+
+	//readiness checks use their own untraced client, apart from the request path
+	ready := admin.NewReadiness(&http.Client{Transport: newServiceBTransport("readiness", timeouts, h2c)}, map[string]string{
+		"service-b": serviceBURL,
+	}, viper.GetDuration("READINESS_CACHE_TTL"), viper.GetDuration("READINESS_TIMEOUT"), drain)
+
+	adminRouter := chi.NewRouter()
+	adminRouter.Use(logging.AccessLog(excluded))
+	adminRouter.Get("/healthz", admin.Liveness(admin.StartTime))
+	adminRouter.Method(http.MethodGet, "/readyz", ready)
+	adminRouter.Method(http.MethodGet, "/drain", drain)
+	adminRouter.Method(http.MethodPost, "/drain", drain)
+	adminRouter.Method(http.MethodGet, "/metrics", metricsHandler(reg))
+	adminRouter.Method(http.MethodGet, "/stats", requestStats)
+	adminRouter.Method(http.MethodGet, "/admin/diagnostics", diag)
+	adminRouter.Get("/loglevel", logging.LevelHandler(api.Error))
+	adminRouter.Put("/loglevel", logging.LevelHandler(api.Error))
+	adminRouter.Get("/debug/config", config.Handler(unsetSettings))
+	if viper.GetBool("ENABLE_PPROF") {
+		admin.RegisterPprof(adminRouter)
 	}
+	adminRouter.Method(http.MethodGet, "/slo", objective)
 
-	http.Handle("/metrics", promhttp.Handler())
-	http.Handle("/zipcode", otelhttp.NewHandler(http.HandlerFunc(h.zipCodeHandler), "ZipCodeHandler"))
+	adminServer := newServer(":"+viper.GetString("ADMIN_PORT"), adminRouter)
+	go admin.Serve("admin", adminServer.ListenAndServe)
 
-	log.Fatal(http.ListenAndServe(":8080", nil))
+	server := newServer(":"+viper.GetString("PORT"), router)
+	server.RegisterOnShutdown(h.subscriptions.Shutdown)
+	if viper.GetString("AUTH_MODE") == "mtls" {
+		server.TLSConfig, err = mtlsServerConfig()
+		if err != nil {
+			logging.Fatal("failed to configure mTLS", err)
+		}
+	}
 
-	select {
-	case <-sigCh:
-		log.Println("Shutting down gracefully, CTRL+C pressed...")
-	case <-ctx.Done():
-		log.Println("Shutting down due to other reason...")
+	if server.TLSConfig != nil {
+		go admin.Serve("api", func() error {
+			return server.ListenAndServeTLS(viper.GetString("TLS_CERT_FILE"), viper.GetString("TLS_KEY_FILE"))
+		})
+	} else {
+		go admin.Serve("api", server.ListenAndServe)
 	}
 
-	// Create a timeout context for the graceful shutdown
-	_, shutdownCancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer shutdownCancel()
+	//settings that can be changed by editing the config file
+	config.OnChange(func() { logging.Level.Set(logging.ParseLevel(viper.GetString("LOG_LEVEL"))) }, "LOG_LEVEL")
+	config.OnChange(func() {
+		logging.ErrorSampler.Configure(viper.GetDuration("LOG_SAMPLE_INTERVAL"), viper.GetInt("LOG_SAMPLE_BURST"), viper.GetInt("LOG_SAMPLE_EVERY"))
+	}, "LOG_SAMPLE_INTERVAL", "LOG_SAMPLE_BURST", "LOG_SAMPLE_EVERY")
+	config.OnChange(func() { h.degradation.Reconfigure(degradation.LoadConfig()) },
+		"DEGRADED_LATENCY", "CRITICAL_LATENCY", "DEGRADED_ERROR_RATIO", "CRITICAL_ERROR_RATIO")
+	config.OnChange(reloadTimeouts, reloadableTimeouts...)
+	config.OnChange(func() { limiter.Configure(viper.GetFloat64("RATE_LIMIT_RPS"), viper.GetInt("RATE_LIMIT_BURST")) },
+		"RATE_LIMIT_RPS", "RATE_LIMIT_BURST")
+	config.OnChange(func() {
+		ipLimiter.Configure(viper.GetFloat64("RATE_LIMIT_IP_RPS"), viper.GetInt("RATE_LIMIT_IP_BURST"))
+	}, "RATE_LIMIT_IP_RPS", "RATE_LIMIT_IP_BURST")
+
+	//read after Watch only through config.Read or a reload callback
+	drainDelay, shutdownTimeout := viper.GetDuration("SHUTDOWN_DRAIN_DELAY"), viper.GetDuration("SHUTDOWN_TIMEOUT")
+	config.Watch(configFiles)
+
+	<-ctx.Done()
+	slog.Info("Shutting down gracefully...")
+	drain.Start()
+
+	//drain the API first; the admin listener keeps serving metrics meanwhile
+	admin.DrainServer(server, drainDelay, shutdownTimeout)
+	admin.DrainServer(adminServer, 0, shutdownTimeout)
 }
 
+// zipCodeHandler serves POST /v1/temperature, with the CEP, or another
+// country's postal code and its country, in a JSON body.
 func (h *handler) zipCodeHandler(w http.ResponseWriter, r *http.Request) {
+	h.serveTemperature(w, r, func() (api.TemperatureRequest, error) {
+		var req api.TemperatureRequest
+		err := api.DecodeJSON(r, &req)
+		return req, err
+	})
+}
+
+// temperatureByCEPHandler serves GET /v1/temperature/{cep}, for browsers,
+// curl one-liners and monitoring checks, with the country of other postal
+// codes in the country query parameter.
+func (h *handler) temperatureByCEPHandler(w http.ResponseWriter, r *http.Request) {
+	h.serveTemperature(w, r, func() (api.TemperatureRequest, error) {
+		return api.TemperatureRequest{CEP: chi.URLParam(r, "cep"), Country: r.URL.Query().Get("country")}, nil
+	})
+}
+
+// serveTemperature answers with the temperature for the postal code returned
+// by readRequest.
+func (h *handler) serveTemperature(w http.ResponseWriter, r *http.Request, readRequest func() (api.TemperatureRequest, error)) {
 
 	carrier := propagation.HeaderCarrier(r.Header)
 	ctx := r.Context()
 	ctx = otel.GetTextMapPropagator().Extract(ctx, carrier)
 
-	ctx, spanInicial := h.tracer.Start(ctx, "SPAN_INICIAL "+viper.GetString("REQUEST_NAME_OTEL"))
+	ctx, spanInicial := h.tracer.Start(ctx, "SPAN_INICIAL "+h.requestName)
 	spanInicial.End()
 
 	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(r.Header))
 
-	if r.Method != http.MethodPost {
-		http.Error(w, "Only POST method is allowed", http.StatusMethodNotAllowed)
+	if h.degradation.Level() != degradation.Normal {
+		middleware.MarkFeature(ctx, usesDegradedMode)
+	}
+
+	rec := &middleware.StatusRecorder{ResponseWriter: w, Status: http.StatusOK}
+	w = rec
+	var audit auditEntry
+	defer func() {
+		audit.Status = rec.Status
+		h.audit.Record(ctx, r, audit)
+	}()
+
+	mediaType, ok := negotiate(r)
+	if !ok {
+		api.Error(w, r, apperr.ErrNotAcceptable)
+		return
+	}
+	format, err := parseTemperatureFormat(r)
+	if err != nil {
+		api.Error(w, r, err)
+		return
+	}
+
+	req, err := readRequest()
+	if err != nil {
+		api.Error(w, r, err)
 		return
 	}
+	cep := normalizeCEP(r.Context(), req.CEP)
+	country := api.NormalizeCountry(req.Country)
+	audit.CEP, audit.Country = cep, country
 
-	var req ZipCodeRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+	if err := api.ValidatePostalCode(country, cep); err != nil {
+		api.Error(w, r, err)
 		return
 	}
 
-	if !isValidZipCode(req.CEP) {
-		http.Error(w, "invalid zipcode", http.StatusPreconditionFailed)
+	result, err := h.fetchTemperature(ctx, country, cep)
+	if err != nil {
+		api.Error(w, r, err)
 		return
 	}
 
-	_, span := h.tracer.Start(ctx, "Chamada externa: getTemperatureByZipCode")
+	audit.Result = &result
+	w.Header().Set(api.CacheHeader, result.CacheStatus())
+	if age := result.Age(); age != "" {
+		w.Header().Set(api.AgeHeader, age)
+	}
+	writeResponse(w, r, mediaType, http.StatusOK, format.view(result))
+}
+
+// fetchTemperature asks service-b for the temperature at a valid postal code
+// of country, over HTTP or, with SERVICE_B_TRANSPORT=grpc, gRPC.
+func (h *handler) fetchTemperature(ctx context.Context, country, cep string) (api.Temperature, error) {
+	ctx, span := h.tracer.Start(ctx, "Chamada externa: getTemperatureByZipCode")
 	defer span.End()
 
-	client := http.Client{Transport: otelhttp.NewTransport(http.DefaultTransport)}
+	ctx, cancel := withTimeout(ctx, activeTimeouts.Load().serviceB)
+	defer cancel()
+
+	done, err := h.serviceBBreaker.Allow(ctx)
+	if err != nil {
+		return api.Temperature{}, err
+	}
+	fetch := h.fetchTemperatureHTTP
+	if h.temperatureClient != nil {
+		fetch = h.fetchTemperatureGRPC
+	}
+	result, err := fetch(ctx, country, cep)
+	done(serviceBOutcome(err))
+	return result, err
+}
+
+// serviceBOutcome is the outcome of a call to service-b for its breaker: a
+// failure when it failed because of service-b, with a 5xx, other than the
+// fast ones of its own open circuits, or without an answer. The caller
+// giving up says nothing about service-b either way.
+func serviceBOutcome(err error) callOutcome {
+	switch {
+	case errors.Is(err, context.Canceled):
+		return callCancelled
+	case err == nil || errors.Is(err, apperr.ErrCircuitOpen):
+		return callSucceeded
+	case apperr.From(err).Status >= http.StatusInternalServerError:
+		return callFailed
+	}
+	return callSucceeded
+}
 
-	url := fmt.Sprintf("http://service-b:8081/zipcode?zipcode=%s", req.CEP)
+// fetchTemperatureHTTP is fetchTemperature over HTTP.
+func (h *handler) fetchTemperatureHTTP(ctx context.Context, country, cep string) (api.Temperature, error) {
 
-	resp, err := client.Get(url)
+	url := fmt.Sprintf("%s/v1/temperature?zipcode=%s", h.serviceBURL, cep)
+	if country != api.DefaultCountry {
+		url += "&country=" + country
+	}
 
+	outReq, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
+		return api.Temperature{}, err
+	}
+	outReq.Header.Set(middleware.RequestIDHeader, middleware.RequestIDFromContext(ctx))
+	setDeadlineHeader(ctx, outReq)
+	if h.internalCompression {
+		outReq.Header.Set("Accept-Encoding", "gzip")
+		middleware.MarkFeature(ctx, usesCompression)
+	}
+
+	resp, err := h.client.Do(outReq)
+	h.degradation.ObserveDependency("service-b", err == nil && resp.StatusCode < http.StatusInternalServerError)
+
+	if err != nil {
+		observeProviderError("service-b", err)
+		return api.Temperature{}, apperr.Upstream(apperr.ErrUpstreamUnavailable, err)
 	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode == http.StatusNotFound {
-		http.Error(w, "can not find zipcode", http.StatusNotFound)
-		return
+	if resp.StatusCode >= http.StatusInternalServerError {
+		observeProviderError("service-b", checkStatus("service-b", resp))
 	}
 
-	var zipCodeResponse ZipCodeResponse
-	if err := json.NewDecoder(resp.Body).Decode(&zipCodeResponse); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
+	if resp.StatusCode >= http.StatusBadRequest {
+		return api.Temperature{}, apperr.FromResponse(resp)
 	}
 
-	w.WriteHeader(resp.StatusCode)
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(zipCodeResponse)
-}
+	body, err := readBody(resp)
+	if err != nil {
+		return api.Temperature{}, apperr.Upstream(apperr.ErrBadUpstreamPayload, err)
+	}
 
-func isValidZipCode(zipCode string) bool {
-	match, _ := regexp.MatchString(`^\d{8}$`, zipCode)
-	return match
+	var zipCodeResponse api.Temperature
+	if err := json.Unmarshal(body, &zipCodeResponse); err != nil {
+		return api.Temperature{}, apperr.ErrBadUpstreamPayload.Wrap(err)
+	}
+	status := resp.Header.Get(api.CacheHeader)
+	zipCodeResponse.Cached = status == "HIT" || status == "STALE"
+	zipCodeResponse.Stale = status == "STALE"
+	return zipCodeResponse, nil
 }
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"net/http"
+	"slices"
+
+	"goexpert-lab-2-observabilidade/internal/admin"
+	"goexpert-lab-2-observabilidade/internal/apperr"
+	"goexpert-lab-2-observabilidade/internal/degradation"
+	"goexpert-lab-2-observabilidade/internal/httpclient"
+	"goexpert-lab-2-observabilidade/internal/logging"
+	"goexpert-lab-2-observabilidade/internal/middleware"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/prometheus/client_golang/prometheus/push"
+)
+
+// appCollectors lists every application metric; they are registered explicitly
+// instead of through the global default registry.
+func appCollectors() []prometheus.Collector {
+	return slices.Concat([]prometheus.Collector{
+		apperr.Collector(),
+		rateLimited,
+		providerErrors,
+		e2eLatency,
+		internalPayloadBytes,
+		webSocketConnections,
+		webSocketSubscriptions,
+		webSocketMessages,
+		webhooksRegistered,
+		webhookDeliveries,
+		webhookAttempts,
+		webhookAttemptDuration,
+		breakerStateGauge,
+		breakerTransitions,
+		breakerRejections,
+	}, middleware.Collectors(), logging.Collectors(), httpclient.Collectors(), admin.Collectors(), degradation.Collectors())
+}
+
+// newRegistry builds an isolated registry with the process, Go and app
+// collectors, plus any collectors owned by components built in main.
+func newRegistry(extra ...prometheus.Collector) *prometheus.Registry {
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		collectors.NewGoCollector(),
+	)
+	reg.MustRegister(appCollectors()...)
+	reg.MustRegister(extra...)
+	return reg
+}
+
+// pushMetrics sends the registry to the Pushgateway at url, for short-lived runs
+// that live too briefly to be scraped.
+func pushMetrics(reg *prometheus.Registry, url, job string) error {
+	return push.New(url, job).Gatherer(reg).Push()
+}
+
+func metricsHandler(reg *prometheus.Registry) http.Handler {
+	return promhttp.HandlerFor(reg, promhttp.HandlerOpts{Registry: reg})
+}
@@ -0,0 +1,453 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"goexpert-lab-2-observabilidade/internal/admin"
+	"goexpert-lab-2-observabilidade/internal/api"
+	"goexpert-lab-2-observabilidade/internal/apperr"
+)
+
+// object is a node of the OpenAPI document.
+type object = map[string]any
+
+func ref(name string) object {
+	return object{"$ref": "#/components/schemas/" + name}
+}
+
+// errorResponses describes the error statuses an operation can answer with:
+// plain text, or a Problem with ERROR_FORMAT=problem.
+func errorResponses(responses object, statuses ...int) object {
+	for _, status := range statuses {
+		responses[strconv.Itoa(status)] = object{
+			"description": http.StatusText(status),
+			"headers":     object{apperr.TypeHeader: object{"schema": ref("ErrorType")}},
+			"content": object{
+				"text/plain":               object{"schema": object{"type": "string"}},
+				"application/problem+json": object{"schema": ref("Problem")},
+			},
+		}
+	}
+	return responses
+}
+
+// temperatureOperation describes a single-CEP lookup; op holds what differs
+// between the routes, i.e. how the CEP is sent.
+func temperatureOperation(summary string, op object) object {
+	params, _ := op["parameters"].([]object)
+	op["summary"] = summary
+	op["tags"] = []string{"temperature"}
+	op["parameters"] = append(params,
+		object{"name": "units", "in": "query", "schema": object{"type": "string", "enum": []string{"metric", "imperial", "all"}, "default": "all"},
+			"description": "metric returns temp_C and temp_K, imperial temp_F, all every scale."},
+		object{"name": "precision", "in": "query", "schema": object{"type": "integer", "minimum": 0, "maximum": maxPrecision},
+			"description": "Round the temperatures to this many decimal places; unrounded by default."},
+	)
+	op["responses"] = errorResponses(object{
+		"200": object{
+			"description": "Temperature at the CEP, in the scales asked for.",
+			"headers": object{
+				api.CacheHeader: object{"schema": object{"type": "string", "enum": []string{"HIT", "MISS", "STALE"}},
+					"description": "Whether the temperature came from a cache, STALE when expired because the weather couldn't be refreshed in time; see retrieved_at for its age."},
+				api.AgeHeader: object{"schema": object{"type": "integer"},
+					"description": "Seconds since a cached temperature was retrieved; only sent with HIT and STALE."},
+			},
+			"content": object{
+				mediaJSON: object{"schema": ref("Temperature")},
+				mediaXML:  object{"schema": ref("Temperature")},
+				mediaCSV:  object{"schema": object{"type": "string"}, "example": "city,temp_C,temp_F,temp_K\nRio de Janeiro,25.5,77.9,298.5\n"},
+			},
+		},
+	}, http.StatusBadRequest, http.StatusNotFound, http.StatusNotAcceptable, http.StatusPreconditionFailed,
+		http.StatusRequestEntityTooLarge, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout)
+	return op
+}
+
+// openAPISpec builds the OpenAPI 3 document of the public API.
+func openAPISpec() object {
+	temperatureBody := object{
+		"required": true,
+		"content":  object{mediaJSON: object{"schema": ref("TemperatureRequest")}},
+	}
+	post := temperatureOperation("Temperature at a CEP sent in the body", object{"requestBody": temperatureBody})
+
+	deprecated := temperatureOperation("Deprecated alias of POST /v1/temperature", object{"requestBody": temperatureBody})
+	deprecated["deprecated"] = true
+
+	temperature := object{"type": "number", "format": "double"}
+
+	graphQL := func(op object) object {
+		op["summary"] = "GraphQL query, see /graphql/schema.graphql"
+		op["tags"] = []string{"graphql"}
+		op["responses"] = object{
+			"200": object{
+				"description": "Query executed; failed lookups are null in data, with their error in errors.",
+				"content":     object{mediaJSON: object{"schema": ref("GraphQLResponse")}},
+			},
+			"400": object{
+				"description": "Invalid request or query, e.g. a syntax error or an unknown field.",
+				"content":     object{mediaJSON: object{"schema": ref("GraphQLResponse")}},
+			},
+			"413": object{
+				"description": "More temperature fields than BATCH_MAX_CEPS.",
+				"content":     object{mediaJSON: object{"schema": ref("GraphQLResponse")}},
+			},
+		}
+		return op
+	}
+
+	return object{
+		"openapi": "3.0.3",
+		"info": object{
+			"title":       "service-a",
+			"description": "Validates CEPs and returns the current temperature of their city, as looked up by service-b.",
+			"version":     admin.Version(),
+		},
+		"security": []object{{}, {"apiKey": []string{}}, {"bearer": []string{}}},
+		"paths": object{
+			"/v1/temperature": object{"post": post},
+			"/v1/temperature/{cep}": object{"get": temperatureOperation("Temperature at a CEP sent in the path", object{
+				"parameters": []object{
+					{"name": "cep", "in": "path", "required": true, "schema": object{"type": "string", "example": "22261040"},
+						"description": "The CEP, or the postal code of country."},
+					{"name": "country", "in": "query", "schema": ref("Country")},
+				},
+			})},
+			"/v1/temperatures": object{"post": object{
+				"summary": "Temperatures at several CEPs",
+				"tags":    []string{"temperature"},
+				"requestBody": object{
+					"required": true,
+					"content":  object{mediaJSON: object{"schema": ref("BatchRequest")}},
+				},
+				"responses": errorResponses(object{
+					"200": object{
+						"description": "Every CEP succeeded; with Accept: application/x-ndjson, one BatchItem per line as each lookup finishes.",
+						"content": object{
+							mediaJSON:         object{"schema": ref("BatchResponse")},
+							ndjsonContentType: object{"schema": ref("BatchItem")},
+						},
+					},
+					"207": object{
+						"description": "Some CEPs failed; see the status and error of each result.",
+						"content":     object{mediaJSON: object{"schema": ref("BatchResponse")}},
+					},
+				}, http.StatusBadRequest, http.StatusRequestEntityTooLarge, http.StatusServiceUnavailable),
+			}},
+			"/v1/compare": object{"post": object{
+				"summary": "Cities of several CEPs, warmest first",
+				"tags":    []string{"temperature"},
+				"requestBody": object{
+					"required": true,
+					"content":  object{mediaJSON: object{"schema": ref("BatchRequest")}},
+				},
+				"responses": errorResponses(object{
+					"200": object{
+						"description": "Every CEP succeeded.",
+						"content":     object{mediaJSON: object{"schema": ref("CompareResponse")}},
+					},
+					"207": object{
+						"description": "Some CEPs failed; they are listed in failed and left out of the ranking.",
+						"content":     object{mediaJSON: object{"schema": ref("CompareResponse")}},
+					},
+				}, http.StatusBadRequest, http.StatusRequestEntityTooLarge, http.StatusServiceUnavailable),
+			}},
+			"/v1/webhooks": object{
+				"post": object{
+					"summary": "Register a temperature threshold webhook",
+					"tags":    []string{"webhooks"},
+					"description": "The url is POSTed a WebhookEvent whenever the temperature at the CEP crosses threshold_c in the direction asked for, " +
+						"signed in X-Webhook-Signature with the secret returned here.",
+					"requestBody": object{
+						"required": true,
+						"content":  object{mediaJSON: object{"schema": ref("WebhookRequest")}},
+					},
+					"responses": errorResponses(object{
+						"201": object{
+							"description": "Registered; the secret is only returned now.",
+							"headers":     object{"Location": object{"schema": object{"type": "string"}}},
+							"content":     object{mediaJSON: object{"schema": ref("Webhook")}},
+						},
+					}, http.StatusBadRequest, http.StatusConflict, http.StatusRequestEntityTooLarge),
+				},
+				"get": object{
+					"summary": "The caller's webhooks",
+					"tags":    []string{"webhooks"},
+					"responses": object{"200": object{
+						"description": "Webhooks registered by the caller, oldest first.",
+						"content":     object{mediaJSON: object{"schema": ref("WebhookList")}},
+					}},
+				},
+			},
+			"/v1/webhooks/{id}": object{
+				"parameters": []object{{"name": "id", "in": "path", "required": true, "schema": object{"type": "string"}}},
+				"get": object{
+					"summary": "A webhook",
+					"tags":    []string{"webhooks"},
+					"responses": errorResponses(object{
+						"200": object{"description": "The webhook.", "content": object{mediaJSON: object{"schema": ref("Webhook")}}},
+					}, http.StatusNotFound),
+				},
+				"delete": object{
+					"summary":   "Delete a webhook",
+					"tags":      []string{"webhooks"},
+					"responses": errorResponses(object{"204": object{"description": "Deleted."}}, http.StatusNotFound),
+				},
+			},
+			"/zipcode": object{"post": deprecated},
+			"/graphql": object{
+				"get": graphQL(object{"parameters": []object{
+					{"name": "query", "in": "query", "required": true, "schema": object{"type": "string"}},
+					{"name": "operationName", "in": "query", "schema": object{"type": "string"}},
+					{"name": "variables", "in": "query", "schema": object{"type": "string"}, "description": "A JSON object."},
+				}}),
+				"post": graphQL(object{"requestBody": object{
+					"required": true,
+					"content":  object{mediaJSON: object{"schema": ref("GraphQLRequest")}},
+				}}),
+			},
+			"/ws": object{"get": object{
+				"summary":     "Temperature updates over WebSocket",
+				"tags":        []string{"temperature"},
+				"description": `Upgrade to WebSocket, then send {"type": "subscribe", "ceps": [...]} or {"type": "unsubscribe", "ceps": [...]} text messages, with an optional "country" for all their CEPs. Each subscribed CEP gets a WebSocketMessage of type temperature right away and whenever its result changes, checked every WS_UPDATE_INTERVAL.`,
+				"responses": errorResponses(object{
+					"101": object{"description": "Switched to WebSocket; the messages are WebSocketMessage objects.",
+						"content": object{mediaJSON: object{"schema": ref("WebSocketMessage")}}},
+				}, http.StatusBadRequest, http.StatusUpgradeRequired, http.StatusServiceUnavailable),
+			}},
+			"/graphql/schema.graphql": object{"get": object{
+				"summary":   "GraphQL schema, in SDL",
+				"tags":      []string{"graphql"},
+				"security":  []object{{}},
+				"responses": object{"200": object{"description": "The schema.", "content": object{"application/graphql": object{"schema": object{"type": "string"}}}}},
+			}},
+		},
+		"components": object{
+			"securitySchemes": object{
+				"apiKey": object{"type": "apiKey", "in": "header", "name": "X-API-Key", "description": "With AUTH_MODE=apikey."},
+				"bearer": object{"type": "http", "scheme": "bearer", "bearerFormat": "JWT", "description": "With AUTH_MODE=jwt."},
+			},
+			"schemas": object{
+				"TemperatureRequest": object{
+					"type":                 "object",
+					"required":             []string{"cep"},
+					"additionalProperties": false,
+					"properties": object{
+						"cep": object{"type": "string", "example": "22261040",
+							"description": "A CEP, or with country the postal code of another country; hyphens and whitespace are stripped first."},
+						"country": ref("Country"),
+					},
+				},
+				"Country": object{"type": "string", "enum": api.Countries(), "default": api.DefaultCountry,
+					"description": "The country of the postal code, as ISO 3166-1 alpha-2; CEPs are BR and the others are looked up on Zippopotam.us."},
+				"Temperature": object{
+					"type":     "object",
+					"required": []string{"city"},
+					"xml":      object{"name": "temperature"},
+					"properties": object{
+						"city":   object{"type": "string", "example": "Rio de Janeiro"},
+						"temp_C": temperature,
+						"temp_F": temperature,
+						"temp_K": temperature,
+						"condition": object{"type": "string", "example": "Partly cloudy",
+							"description": "The weather as described by WeatherAPI."},
+						"timezone": object{"type": "string", "example": "America/Sao_Paulo",
+							"description": "The IANA time zone of the city."},
+						"local_time": object{"type": "string", "format": "date-time", "example": "2024-07-01T14:32:00-03:00",
+							"description": "When the weather was reported, in the time zone of the city."},
+						"observed_at": object{"type": "string", "format": "date-time", "example": "2024-07-01T17:30:00Z",
+							"description": "When WeatherAPI last updated the weather."},
+						"retrieved_at": object{"type": "string", "format": "date-time", "example": "2024-07-01T17:32:05Z",
+							"description": "When service-b fetched the weather from WeatherAPI; older than the request when cached."},
+					},
+				},
+				"BatchRequest": object{
+					"type":                 "object",
+					"required":             []string{"ceps"},
+					"additionalProperties": false,
+					"properties": object{
+						"ceps": object{"type": "array", "minItems": 1, "items": object{"type": "string", "example": "22261040"},
+							"description": "At most BATCH_MAX_CEPS CEPs, or with country postal codes of another country."},
+						"country": ref("Country"),
+					},
+				},
+				"BatchItem": object{
+					"type":     "object",
+					"required": []string{"index", "cep", "status"},
+					"properties": object{
+						"index":  object{"type": "integer", "description": "Position of the CEP in the request."},
+						"cep":    object{"type": "string"},
+						"status": object{"type": "integer", "description": "Status the single lookup would have answered with."},
+						"result": ref("Temperature"),
+						"error":  ref("ItemError"),
+					},
+				},
+				"ItemError": object{
+					"type":     "object",
+					"required": []string{"type", "message"},
+					"properties": object{
+						"type":    ref("ErrorType"),
+						"message": object{"type": "string"},
+						"fields":  object{"type": "array", "items": ref("FieldError")},
+					},
+				},
+				"BatchResponse": object{
+					"type":     "object",
+					"required": []string{"results", "succeeded", "failed"},
+					"properties": object{
+						"results":   object{"type": "array", "items": ref("BatchItem")},
+						"succeeded": object{"type": "integer"},
+						"failed":    object{"type": "integer"},
+					},
+				},
+				"CompareEntry": object{
+					"allOf": []object{ref("Temperature"), {
+						"type":     "object",
+						"required": []string{"rank", "cep", "delta_C", "delta_F"},
+						"properties": object{
+							"rank":    object{"type": "integer", "minimum": 1, "description": "1 for the warmest city."},
+							"cep":     object{"type": "string"},
+							"delta_C": object{"type": "number", "format": "double", "description": "Difference to the warmest city, 0 or below."},
+							"delta_F": object{"type": "number", "format": "double"},
+						},
+					}},
+				},
+				"CompareResponse": object{
+					"type":     "object",
+					"required": []string{"cities", "spread_C"},
+					"properties": object{
+						"cities":   object{"type": "array", "items": ref("CompareEntry"), "description": "Warmest first; ties keep the request order."},
+						"spread_C": object{"type": "number", "format": "double", "description": "Warmest minus coldest."},
+						"failed":   object{"type": "array", "items": ref("BatchItem")},
+					},
+				},
+				"GraphQLRequest": object{
+					"type":     "object",
+					"required": []string{"query"},
+					"properties": object{
+						"query":         object{"type": "string", "example": `{ temperature(cep: "01310100") { city tempC condition } }`},
+						"operationName": object{"type": "string"},
+						"variables":     object{"type": "object", "additionalProperties": true},
+						"extensions":    object{"type": "object", "additionalProperties": true, "description": "Ignored."},
+					},
+				},
+				"GraphQLResponse": object{
+					"type": "object",
+					"properties": object{
+						"data": object{"type": "object", "additionalProperties": true},
+						"errors": object{"type": "array", "items": object{
+							"type":     "object",
+							"required": []string{"message"},
+							"properties": object{
+								"message":   object{"type": "string"},
+								"locations": object{"type": "array", "items": object{"type": "object", "properties": object{"line": object{"type": "integer"}, "column": object{"type": "integer"}}}},
+								"path":      object{"type": "array", "items": object{"type": "string"}},
+								"extensions": object{"type": "object", "properties": object{
+									"code":   ref("ErrorType"),
+									"fields": object{"type": "array", "items": ref("FieldError")},
+								}},
+							},
+						}},
+					},
+				},
+				"WebSocketMessage": object{
+					"type":     "object",
+					"required": []string{"type"},
+					"properties": object{
+						"type":    object{"type": "string", "enum": []string{msgSubscribed, msgUnsubscribed, msgTemperature, msgError}},
+						"cep":     object{"type": "string", "description": "The CEP a temperature or error is about."},
+						"ceps":    object{"type": "array", "items": object{"type": "string"}, "description": "The CEPs a subscribe or unsubscribe applied to."},
+						"country": object{"type": "string", "description": "The country of cep or ceps."},
+						"result":  ref("Temperature"),
+						"error":   ref("ItemError"),
+					},
+				},
+				"WebhookRequest": object{
+					"type":                 "object",
+					"required":             []string{"url", "cep", "threshold_c"},
+					"additionalProperties": false,
+					"properties": object{
+						"url": object{"type": "string", "format": "uri", "example": "https://example.com/hooks/temperature"},
+						"cep": object{"type": "string", "example": "22261040",
+							"description": "A CEP, or with country the postal code of another country; hyphens and whitespace are stripped first."},
+						"country":     ref("Country"),
+						"threshold_c": temperature,
+						"direction": object{"type": "string", "enum": []string{directionAbove, directionBelow, directionAny}, "default": directionAny,
+							"description": "Crossings to notify: upwards, downwards or both."},
+					},
+				},
+				"Webhook": object{
+					"type":     "object",
+					"required": []string{"id", "url", "cep", "country", "threshold_c", "direction", "created_at"},
+					"properties": object{
+						"id":          object{"type": "string"},
+						"url":         object{"type": "string", "format": "uri"},
+						"cep":         object{"type": "string"},
+						"country":     object{"type": "string"},
+						"threshold_c": temperature,
+						"direction":   object{"type": "string", "enum": []string{directionAbove, directionBelow, directionAny}},
+						"created_at":  object{"type": "string", "format": "date-time"},
+						"secret":      object{"type": "string", "description": "HMAC-SHA256 key of the signatures; only in the creation response."},
+					},
+				},
+				"WebhookList": object{
+					"type":       "object",
+					"required":   []string{"webhooks"},
+					"properties": object{"webhooks": object{"type": "array", "items": ref("Webhook")}},
+				},
+				"WebhookEvent": object{
+					"type":        "object",
+					"description": "Body of a delivery. X-Webhook-Signature is t=<unix seconds>,v1=<hex HMAC-SHA256 of \"<t>.<body>\">; retries keep the id, also sent in X-Webhook-Delivery.",
+					"properties": object{
+						"id":              object{"type": "string"},
+						"type":            object{"type": "string", "enum": []string{webhookEventType}},
+						"webhook_id":      object{"type": "string"},
+						"cep":             object{"type": "string"},
+						"country":         object{"type": "string"},
+						"city":            object{"type": "string"},
+						"threshold_c":     temperature,
+						"direction":       object{"type": "string", "enum": []string{directionAbove, directionBelow}, "description": "Which way the threshold was crossed."},
+						"temp_C":          temperature,
+						"previous_temp_C": temperature,
+						"occurred_at":     object{"type": "string", "format": "date-time"},
+					},
+				},
+				"ErrorType": object{"type": "string", "enum": apperr.Types()},
+				"FieldError": object{
+					"type":     "object",
+					"required": []string{"field", "message"},
+					"properties": object{
+						"field":   object{"type": "string"},
+						"message": object{"type": "string", "example": "must be 8 digits"},
+					},
+				},
+				"Problem": object{
+					"type":     "object",
+					"required": []string{"type", "title", "status"},
+					"properties": object{
+						"type":     object{"type": "string", "example": "/errors/invalid_zipcode"},
+						"title":    object{"type": "string"},
+						"status":   object{"type": "integer"},
+						"detail":   object{"type": "string"},
+						"instance": object{"type": "string", "example": "urn:trace:4bf92f3577b34da6a3ce929d0e0e4736"},
+						"errors":   object{"type": "array", "items": ref("FieldError")},
+					},
+				},
+			},
+		},
+	}
+}
+
+// openAPIHandler serves the OpenAPI document, built once at startup.
+func openAPIHandler() http.HandlerFunc {
+	body, err := json.Marshal(openAPISpec())
+	if err != nil {
+		panic(err)
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(body)
+	}
+}
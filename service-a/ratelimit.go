@@ -0,0 +1,155 @@
+package main
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"goexpert-lab-2-observabilidade/internal/api"
+	"goexpert-lab-2-observabilidade/internal/apperr"
+)
+
+var rateLimited = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "rate_limited_requests_total",
+	Help: "Requests rejected by the rate limits, by limit: per client IP ahead of authentication or per caller after it.",
+}, []string{"limit"})
+
+// bucketIdle is how long a full bucket is kept after its caller's last request.
+const bucketIdle = 10 * time.Minute
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// rateLimiter is a token bucket per caller, as told apart by key: each
+// refills at rps tokens per second up to burst, and every request takes one.
+type rateLimiter struct {
+	name string // labels rate_limited_requests_total
+	key  func(*http.Request) string
+
+	mu        sync.Mutex
+	rps       float64
+	burst     float64
+	buckets   map[string]*tokenBucket
+	lastSweep time.Time
+}
+
+// newRateLimiter builds the limit named name, with a bucket per key of the
+// requests; it applies no limit while rps is not positive.
+func newRateLimiter(name string, key func(*http.Request) string, rps float64, burst int) *rateLimiter {
+	l := &rateLimiter{name: name, key: key, buckets: make(map[string]*tokenBucket), lastSweep: time.Now()}
+	rateLimited.WithLabelValues(name)
+	l.Configure(rps, burst)
+	return l
+}
+
+// Configure changes the rate and burst of every caller from now on; buckets
+// over the new burst are capped on their next request.
+func (l *rateLimiter) Configure(rps float64, burst int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.rps = rps
+	l.burst = float64(max(burst, 1))
+}
+
+// rateLimit is the outcome of a take, with the limit it was taken against.
+type rateLimit struct {
+	rps, burst, remaining float64
+	// wait is how long until the next token, when none was available.
+	wait time.Duration
+}
+
+// take spends a token of the caller's bucket. It reports ok false when no
+// limit is configured.
+func (l *rateLimiter) take(client string, now time.Time) (limit rateLimit, ok bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.rps <= 0 {
+		return rateLimit{}, false
+	}
+	if now.Sub(l.lastSweep) >= bucketIdle {
+		l.sweep(now)
+	}
+
+	b, ok := l.buckets[client]
+	if !ok {
+		b = &tokenBucket{tokens: l.burst, last: now}
+		l.buckets[client] = b
+	}
+	b.tokens = min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rps)
+	b.last = now
+
+	limit = rateLimit{rps: l.rps, burst: l.burst}
+	if b.tokens < 1 {
+		limit.remaining = b.tokens
+		limit.wait = time.Duration((1 - b.tokens) / l.rps * float64(time.Second))
+		return limit, true
+	}
+	b.tokens--
+	limit.remaining = b.tokens
+	return limit, true
+}
+
+// sweep drops the buckets of callers idle long enough to have refilled.
+func (l *rateLimiter) sweep(now time.Time) {
+	for client, b := range l.buckets {
+		if now.Sub(b.last) >= bucketIdle {
+			delete(l.buckets, client)
+		}
+	}
+	l.lastSweep = now
+}
+
+// Middleware rejects requests over the caller's rate with a 429 and
+// Retry-After, and reports the limit on every response in X-RateLimit-*;
+// when limits are stacked, the innermost one reached reports.
+func (l *rateLimiter) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		limit, ok := l.take(l.key(r), time.Now())
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		h := w.Header()
+		h.Set("X-RateLimit-Limit", strconv.Itoa(int(limit.burst)))
+		h.Set("X-RateLimit-Remaining", strconv.Itoa(int(limit.remaining)))
+		h.Set("X-RateLimit-Reset", strconv.Itoa(int(math.Ceil((limit.burst-limit.remaining)/limit.rps))))
+
+		if limit.wait > 0 {
+			rateLimited.WithLabelValues(l.name).Inc()
+			h.Set("Retry-After", strconv.Itoa(int(math.Ceil(limit.wait.Seconds()))))
+			api.Error(w, r, apperr.ErrRateLimited)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// rateLimitKey is the bucket of the caller of r: its subject when it
+// authenticated, so a caller gets the same limit from any address, or the
+// host part of its peer address. Limits keyed on it go after authMiddleware,
+// so they see the identity.
+func rateLimitKey(r *http.Request) string {
+	if id, ok := identityFromContext(r.Context()); ok && id.Method != "none" {
+		return id.Method + ":" + id.Subject
+	}
+	return clientIP(r)
+}
+
+// clientIP is the host part of the peer address of r. Limits keyed on it go
+// ahead of authMiddleware, so requests it refuses are limited too.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/xml"
+	"errors"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"goexpert-lab-2-observabilidade/internal/api"
+	"goexpert-lab-2-observabilidade/internal/apperr"
+)
+
+// Media types the temperature response can be written as, in order of preference.
+const (
+	mediaJSON = "application/json"
+	mediaXML  = "application/xml"
+	mediaCSV  = "text/csv"
+)
+
+var responseMediaTypes = []string{mediaJSON, mediaXML, mediaCSV}
+
+// csvEncoder is implemented by response types that can be written as CSV,
+// a header row followed by the data rows.
+type csvEncoder interface {
+	csvRecords() [][]string
+}
+
+// negotiate picks the response media type for the Accept header of r: the
+// highest q value wins and ties go to the earlier range, then to JSON. ok is
+// false when none of responseMediaTypes is acceptable.
+func negotiate(r *http.Request) (mediaType string, ok bool) {
+	accept := r.Header.Get("Accept")
+	if accept == "" {
+		return mediaJSON, true
+	}
+	bestQ := 0.0
+	for _, part := range strings.Split(accept, ",") {
+		mt, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if v, found := params["q"]; found {
+			if q, err = strconv.ParseFloat(v, 64); err != nil {
+				continue
+			}
+		}
+		for _, candidate := range responseMediaTypes {
+			if q > bestQ && mediaRangeMatches(mt, candidate) {
+				mediaType, bestQ = candidate, q
+			}
+		}
+	}
+	return mediaType, mediaType != ""
+}
+
+func mediaRangeMatches(mediaRange, mediaType string) bool {
+	if mediaRange == "*/*" || mediaRange == mediaType {
+		return true
+	}
+	prefix, found := strings.CutSuffix(mediaRange, "*")
+	return found && strings.HasSuffix(prefix, "/") && strings.HasPrefix(mediaType, prefix)
+}
+
+// writeResponse writes v as mediaType, one of the negotiated responseMediaTypes.
+// Like api.WriteJSON, the body is validated and encoded before anything is written.
+func writeResponse(w http.ResponseWriter, r *http.Request, mediaType string, status int, v any) {
+	w.Header().Add("Vary", "Accept")
+	if mediaType == mediaJSON {
+		api.WriteJSON(w, r, status, v)
+		return
+	}
+
+	if val, ok := v.(api.Validator); ok {
+		if err := val.Validate(); err != nil {
+			api.Error(w, r, apperr.ErrBadUpstreamPayload.Wrap(err))
+			return
+		}
+	}
+
+	var body []byte
+	var err error
+	switch mediaType {
+	case mediaXML:
+		if body, err = xml.Marshal(v); err == nil {
+			body = append([]byte(xml.Header), body...)
+		}
+	case mediaCSV:
+		body, err = encodeCSV(v)
+	}
+	if err != nil {
+		api.Error(w, r, apperr.ErrBadUpstreamPayload.Wrap(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", mediaType+"; charset=utf-8")
+	w.WriteHeader(status)
+	w.Write(body)
+}
+
+func encodeCSV(v any) ([]byte, error) {
+	enc, ok := v.(csvEncoder)
+	if !ok {
+		return nil, errors.New("response has no CSV form")
+	}
+	var buf bytes.Buffer
+	cw := csv.NewWriter(&buf)
+	cw.WriteAll(enc.csvRecords())
+	return buf.Bytes(), cw.Error()
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"goexpert-lab-2-observabilidade/internal/middleware"
+)
+
+// e2eLatency measures the full time from request receipt to final response,
+// including the call to service-b; it is the basis for the latency SLO.
+var e2eLatency = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+	Name:    "sli_e2e_request_duration_seconds",
+	Help:    "End-to-end latency of temperature requests, by outcome.",
+	Buckets: []float64{0.05, 0.1, 0.25, 0.5, 0.75, 1, 1.5, 2, 3, 5, 10},
+}, []string{"outcome"})
+
+// outcome classifies a status code for the SLI: only server errors count against availability.
+func outcome(status int) string {
+	switch {
+	case status >= http.StatusInternalServerError:
+		return "server_error"
+	case status >= http.StatusBadRequest:
+		return "client_error"
+	default:
+		return "success"
+	}
+}
+
+// sliMiddleware observes the SLI histogram and feeds the availability objective.
+func sliMiddleware(objective *slo) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			rec := &middleware.StatusRecorder{ResponseWriter: w, Status: http.StatusOK}
+			next.ServeHTTP(rec, r)
+			o := outcome(rec.Status)
+			e2eLatency.WithLabelValues(o).Observe(time.Since(start).Seconds())
+			objective.Record(o != "server_error")
+		})
+	}
+}
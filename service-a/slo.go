@@ -0,0 +1,141 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// sloResolution is the width of each bucket of the rolling window.
+const sloResolution = time.Minute
+
+// burnWindows are the look-back windows burn rates are reported for, besides the full SLO window.
+var burnWindows = map[string]time.Duration{
+	"1h": time.Hour,
+	"6h": 6 * time.Hour,
+}
+
+type sloBucket struct {
+	minute int64
+	good   uint64
+	total  uint64
+}
+
+// slo tracks a rolling success ratio against a target and derives burn rates:
+// a burn rate of 1 spends the error budget exactly over the window.
+type slo struct {
+	target float64
+	window time.Duration
+
+	mu      sync.Mutex
+	buckets []sloBucket // ring indexed by minute
+}
+
+func newSLO(target float64, window time.Duration) *slo {
+	return &slo{
+		target:  target,
+		window:  window,
+		buckets: make([]sloBucket, max(int(window/sloResolution), 1)),
+	}
+}
+
+// Record counts one request against the objective.
+func (s *slo) Record(good bool) {
+	minute := time.Now().Unix() / int64(sloResolution.Seconds())
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	b := &s.buckets[minute%int64(len(s.buckets))]
+	if b.minute != minute {
+		*b = sloBucket{minute: minute}
+	}
+	b.total++
+	if good {
+		b.good++
+	}
+}
+
+// errorRatio returns the share of bad requests over the last d.
+func (s *slo) errorRatio(d time.Duration) (float64, uint64) {
+	now := time.Now().Unix() / int64(sloResolution.Seconds())
+	oldest := now - int64(d/sloResolution)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var good, total uint64
+	for _, b := range s.buckets {
+		if b.minute > oldest && b.minute <= now {
+			good += b.good
+			total += b.total
+		}
+	}
+	if total == 0 {
+		return 0, 0
+	}
+	return float64(total-good) / float64(total), total
+}
+
+// BurnRate is the error ratio over d divided by the error budget.
+func (s *slo) BurnRate(d time.Duration) float64 {
+	ratio, _ := s.errorRatio(d)
+	return ratio / (1 - s.target)
+}
+
+type sloReport struct {
+	Target               float64            `json:"target"`
+	Window               string             `json:"window"`
+	Requests             uint64             `json:"requests"`
+	SuccessRatio         float64            `json:"success_ratio"`
+	ErrorBudgetRemaining float64            `json:"error_budget_remaining"`
+	BurnRates            map[string]float64 `json:"burn_rates"`
+}
+
+func (s *slo) report() sloReport {
+	ratio, total := s.errorRatio(s.window)
+	r := sloReport{
+		Target:               s.target,
+		Window:               s.window.String(),
+		Requests:             total,
+		SuccessRatio:         1 - ratio,
+		ErrorBudgetRemaining: 1 - ratio/(1-s.target),
+		BurnRates:            map[string]float64{"window": s.BurnRate(s.window)},
+	}
+	for name, d := range burnWindows {
+		r.BurnRates[name] = s.BurnRate(d)
+	}
+	return r
+}
+
+// ServeHTTP writes the current SLO report as JSON.
+func (s *slo) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.report())
+}
+
+var (
+	sloTargetDesc    = prometheus.NewDesc("slo_target", "Availability objective.", nil, nil)
+	sloBurnRateDesc  = prometheus.NewDesc("slo_burn_rate", "Error budget burn rate by look-back window.", []string{"window"}, nil)
+	sloRemainingDesc = prometheus.NewDesc("slo_error_budget_remaining", "Fraction of the error budget left in the SLO window.", nil, nil)
+)
+
+// Describe implements prometheus.Collector.
+func (s *slo) Describe(ch chan<- *prometheus.Desc) {
+	ch <- sloTargetDesc
+	ch <- sloBurnRateDesc
+	ch <- sloRemainingDesc
+}
+
+// Collect implements prometheus.Collector, computing the values at scrape time.
+func (s *slo) Collect(ch chan<- prometheus.Metric) {
+	r := s.report()
+	ch <- prometheus.MustNewConstMetric(sloTargetDesc, prometheus.GaugeValue, r.Target)
+	ch <- prometheus.MustNewConstMetric(sloRemainingDesc, prometheus.GaugeValue, r.ErrorBudgetRemaining)
+	for window, rate := range r.BurnRates {
+		ch <- prometheus.MustNewConstMetric(sloBurnRateDesc, prometheus.GaugeValue, rate, window)
+	}
+}
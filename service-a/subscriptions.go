@@ -0,0 +1,357 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/coder/websocket"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+
+	"goexpert-lab-2-observabilidade/internal/api"
+	"goexpert-lab-2-observabilidade/internal/apperr"
+)
+
+var (
+	webSocketConnections = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "websocket_connections",
+		Help: "Open /ws connections.",
+	})
+
+	webSocketSubscriptions = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "websocket_subscriptions",
+		Help: "CEPs subscribed to across the open /ws connections.",
+	})
+
+	webSocketMessages = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "websocket_messages_total",
+		Help: "/ws messages by direction (received or sent) and message type.",
+	}, []string{"direction", "type"})
+)
+
+// Message types of the /ws protocol.
+const (
+	msgSubscribe    = "subscribe"
+	msgUnsubscribe  = "unsubscribe"
+	msgSubscribed   = "subscribed"
+	msgUnsubscribed = "unsubscribed"
+	msgTemperature  = "temperature"
+	msgError        = "error"
+)
+
+// wsClientMessage is what clients send: a subscribe or unsubscribe for some
+// CEPs, of Country or DefaultCountry.
+type wsClientMessage struct {
+	Type    string   `json:"type"`
+	CEPs    []string `json:"ceps"`
+	Country string   `json:"country,omitempty"`
+}
+
+// wsServerMessage is what /ws pushes, with the country of its CEPs. Errors
+// reuse the shape of failed batch items.
+type wsServerMessage struct {
+	Type    string           `json:"type"`
+	CEP     string           `json:"cep,omitempty"`
+	CEPs    []string         `json:"ceps,omitempty"`
+	Country string           `json:"country,omitempty"`
+	Result  *api.Temperature `json:"result,omitempty"`
+	Error   *batchError      `json:"error,omitempty"`
+}
+
+// subscriptions holds the settings of /ws and its open connections, which
+// are not tracked by the server once hijacked; Shutdown closes them.
+type subscriptions struct {
+	interval time.Duration // how often each subscribed CEP is looked up again
+	max      int           // subscriptions per connection
+
+	mu       sync.Mutex
+	sessions map[*wsSession]struct{}
+	closed   bool
+}
+
+func newSubscriptions(interval time.Duration, max int) *subscriptions {
+	return &subscriptions{interval: interval, max: max, sessions: make(map[*wsSession]struct{})}
+}
+
+func (s *subscriptions) add(sess *wsSession) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return false
+	}
+	s.sessions[sess] = struct{}{}
+	return true
+}
+
+func (s *subscriptions) remove(sess *wsSession) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.sessions, sess)
+}
+
+// Shutdown closes every connection with 1001 Going Away, so clients
+// reconnect to another replica; register it with http.Server.RegisterOnShutdown.
+func (s *subscriptions) Shutdown() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	for sess := range s.sessions {
+		sess.conn.close(websocket.StatusGoingAway, "server shutting down")
+	}
+}
+
+// wsSession is one /ws connection and its subscriptions.
+type wsSession struct {
+	h    *handler
+	r    *http.Request // the upgrade request, for auditing and Accept-Language
+	conn *wsConn
+
+	mu   sync.Mutex
+	subs map[postalCode]context.CancelFunc
+	wg   sync.WaitGroup
+}
+
+// webSocketHandler serves /ws. Clients send {"type": "subscribe", "ceps": [...]}
+// and then get a temperature message per CEP, right away and again every
+// WS_UPDATE_INTERVAL whenever the result changed, until they unsubscribe or
+// disconnect. Each subscription is traced as a span of its own, with a child
+// span per lookup.
+func (h *handler) webSocketHandler(w http.ResponseWriter, r *http.Request) {
+	if !h.degradation.Allows(featureSubscriptions) {
+		api.Error(w, r, apperr.ErrFeatureShed)
+		return
+	}
+	if err := checkWebSocketUpgrade(w, r, h.cors); err != nil {
+		api.Error(w, r, err)
+		return
+	}
+	conn, err := acceptWebSocket(w, r, h.maxRequestBytes)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to accept websocket", "error", err)
+		return
+	}
+	sess := &wsSession{h: h, r: r, conn: conn, subs: make(map[postalCode]context.CancelFunc)}
+	if !h.subscriptions.add(sess) {
+		conn.close(websocket.StatusGoingAway, "server shutting down")
+		return
+	}
+	defer h.subscriptions.remove(sess)
+
+	webSocketConnections.Inc()
+	defer webSocketConnections.Dec()
+
+	// the connection outlives HANDLER_TIMEOUT, which only bounds each lookup
+	ctx, cancel := context.WithCancel(context.WithoutCancel(r.Context()))
+	defer func() {
+		cancel()
+		sess.wg.Wait()
+		conn.close(websocket.StatusNormalClosure, "")
+	}()
+
+	for {
+		msg, err := conn.readMessage(ctx)
+		if err != nil {
+			if !errors.Is(err, errWebSocketClosed) {
+				slog.DebugContext(ctx, "websocket connection ended", "error", err)
+			}
+			return
+		}
+		sess.handle(ctx, msg)
+	}
+}
+
+// handle applies one client message, answering protocol errors with an
+// error message; the connection stays open.
+func (s *wsSession) handle(ctx context.Context, raw []byte) {
+	var msg wsClientMessage
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&msg); err != nil {
+		webSocketMessages.WithLabelValues("received", "invalid").Inc()
+		s.sendError(ctx, postalCode{}, apperr.ErrInvalidBody.Wrap(err))
+		return
+	}
+
+	country := api.NormalizeCountry(msg.Country)
+	switch msg.Type {
+	case msgSubscribe:
+		webSocketMessages.WithLabelValues("received", msg.Type).Inc()
+		s.subscribe(ctx, country, msg.CEPs)
+	case msgUnsubscribe:
+		webSocketMessages.WithLabelValues("received", msg.Type).Inc()
+		s.unsubscribe(ctx, country, msg.CEPs)
+	default:
+		webSocketMessages.WithLabelValues("received", "invalid").Inc()
+		s.sendError(ctx, postalCode{}, apperr.ErrInvalidBody.WithFields(apperr.FieldError{Field: "type", Message: `must be "subscribe" or "unsubscribe"`}))
+	}
+}
+
+func (s *wsSession) subscribe(ctx context.Context, country string, ceps []string) {
+	if len(ceps) == 0 {
+		s.sendError(ctx, postalCode{}, apperr.ErrInvalidBody.WithFields(apperr.FieldError{Field: "ceps", Message: "must not be empty"}))
+		return
+	}
+	if err := api.ValidateCountry(country); err != nil {
+		s.sendError(ctx, postalCode{}, err)
+		return
+	}
+	if !s.h.degradation.Allows(featureSubscriptions) {
+		s.sendError(ctx, postalCode{}, apperr.ErrFeatureShed)
+		return
+	}
+
+	var added []string
+	var watches []func()
+	for _, cep := range ceps {
+		code := postalCode{country, normalizeCEP(ctx, cep)}
+		if err := api.ValidatePostalCode(code.country, code.cep); err != nil {
+			s.sendError(ctx, code, err)
+			continue
+		}
+
+		s.mu.Lock()
+		_, dup := s.subs[code]
+		full := len(s.subs) >= s.h.subscriptions.max
+		if !dup && !full {
+			subCtx, cancel := context.WithCancel(ctx)
+			s.subs[code] = cancel
+			added = append(added, code.cep)
+			watches = append(watches, func() { s.watch(subCtx, code) })
+		}
+		s.mu.Unlock()
+
+		if full && !dup {
+			s.sendError(ctx, code, apperr.ErrBatchTooLarge.WithFields(apperr.FieldError{
+				Field: "ceps", Message: fmt.Sprintf("must have at most %d subscriptions per connection", s.h.subscriptions.max),
+			}))
+		}
+	}
+	if len(added) == 0 {
+		return
+	}
+
+	// acknowledged before the watchers start, so it comes ahead of their first update
+	s.send(ctx, wsServerMessage{Type: msgSubscribed, CEPs: added, Country: country})
+	for _, watch := range watches {
+		s.wg.Add(1)
+		go watch()
+	}
+}
+
+func (s *wsSession) unsubscribe(ctx context.Context, country string, ceps []string) {
+	var removed []string
+	s.mu.Lock()
+	for _, cep := range ceps {
+		code := postalCode{country, normalizeCEP(ctx, cep)}
+		if cancel, ok := s.subs[code]; ok {
+			cancel()
+			delete(s.subs, code)
+			removed = append(removed, code.cep)
+		}
+	}
+	s.mu.Unlock()
+	s.send(ctx, wsServerMessage{Type: msgUnsubscribed, CEPs: removed, Country: country})
+}
+
+// watch pushes the temperature at code until ctx is canceled, under the
+// subscription's span.
+func (s *wsSession) watch(ctx context.Context, code postalCode) {
+	defer s.wg.Done()
+	webSocketSubscriptions.Inc()
+	defer webSocketSubscriptions.Dec()
+
+	ctx, span := s.h.tracer.Start(ctx, "websocket subscription", trace.WithAttributes(
+		attribute.String("cep", code.cep), attribute.String("country", code.country)))
+	defer span.End()
+
+	ticker := time.NewTicker(s.h.subscriptions.interval)
+	defer ticker.Stop()
+
+	var last wsServerMessage
+	updates := 0
+	for {
+		// lookups are skipped while shed, and resume once the level drops
+		if s.h.degradation.Allows(featureSubscriptions) {
+			msg := s.update(ctx, code)
+			// a lookup cut short by unsubscribing is not reported
+			if ctx.Err() == nil && changed(last, msg) {
+				s.send(ctx, msg)
+				updates++
+			}
+			last = msg
+		}
+
+		select {
+		case <-ctx.Done():
+			span.SetAttributes(attribute.Int("websocket.updates", updates))
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// changed reports whether next is worth pushing after prev: a different
+// temperature, or a different error type.
+func changed(prev, next wsServerMessage) bool {
+	switch {
+	case prev.Type != next.Type:
+		return true
+	case next.Result != nil:
+		return *next.Result != *prev.Result
+	default:
+		return next.Error.Type != prev.Error.Type
+	}
+}
+
+// update looks code up like a request would, bounded by HANDLER_TIMEOUT.
+func (s *wsSession) update(ctx context.Context, code postalCode) wsServerMessage {
+	ctx, span := s.h.tracer.Start(ctx, "websocket update", trace.WithAttributes(
+		attribute.String("cep", code.cep), attribute.String("country", code.country)))
+	defer span.End()
+	ctx, cancel := withTimeout(ctx, activeTimeouts.Load().handler)
+	defer cancel()
+
+	result, err := s.h.fetchBatchItem(ctx, code.country, code.cep)
+	if err != nil {
+		e := apperr.Record(ctx, err)
+		s.h.audit.Record(ctx, s.r, auditEntry{CEP: code.cep, Country: code.country, Status: e.Status})
+		return wsServerMessage{Type: msgError, CEP: code.cep, Country: code.country,
+			Error: &batchError{Type: e.Type, Message: e.Localized(apperr.Language(s.r)), Fields: e.Fields()}}
+	}
+	s.h.audit.Record(ctx, s.r, auditEntry{CEP: code.cep, Country: code.country, Status: http.StatusOK, Result: &result})
+	return wsServerMessage{Type: msgTemperature, CEP: code.cep, Country: code.country, Result: &result}
+}
+
+// sendError reports err to the client, for code or, when it is the zero
+// value, for the whole message.
+func (s *wsSession) sendError(ctx context.Context, code postalCode, err error) {
+	e := apperr.Record(ctx, err)
+	s.send(ctx, wsServerMessage{Type: msgError, CEP: code.cep, Country: code.country,
+		Error: &batchError{Type: e.Type, Message: e.Localized(apperr.Language(s.r)), Fields: e.Fields()}})
+}
+
+func (s *wsSession) send(ctx context.Context, msg wsServerMessage) {
+	b, err := json.Marshal(msg)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to encode websocket message", "error", err)
+		return
+	}
+	if err := s.conn.writeText(ctx, b); err != nil {
+		if !errors.Is(err, errWebSocketClosed) {
+			// a client that can't keep up is dropped; the read loop ends with it
+			trace.SpanFromContext(ctx).SetStatus(codes.Error, err.Error())
+			s.conn.close(websocket.StatusGoingAway, "write failed")
+		}
+		return
+	}
+	webSocketMessages.WithLabelValues("sent", msg.Type).Inc()
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// timeoutConfig holds the timeout hierarchy; zero means unbounded.
+type timeoutConfig struct {
+	handler  time.Duration
+	serviceB time.Duration
+	dial     time.Duration
+}
+
+func loadTimeouts() timeoutConfig {
+	return timeoutConfig{
+		handler:  viper.GetDuration("HANDLER_TIMEOUT"),
+		serviceB: viper.GetDuration("SERVICE_B_TIMEOUT"),
+		dial:     viper.GetDuration("DIAL_TIMEOUT"),
+	}
+}
+
+// Validate checks that each level of the hierarchy outlasts the one below it:
+// handler timeout > service-b call budget > dial timeout. Misordered timeouts
+// surface as confusing 500s, so inconsistent configs are refused at startup.
+func (t timeoutConfig) Validate() error {
+	return errors.Join(
+		outlasts("HANDLER_TIMEOUT", t.handler, "SERVICE_B_TIMEOUT", t.serviceB),
+		outlasts("SERVICE_B_TIMEOUT", t.serviceB, "DIAL_TIMEOUT", t.dial),
+	)
+}
+
+// outlasts checks parent > child, where zero means unbounded.
+func outlasts(parentName string, parent time.Duration, childName string, child time.Duration) error {
+	switch {
+	case parent <= 0:
+		return nil
+	case child <= 0:
+		return fmt.Errorf("%s (%s) is bounded but %s is not", parentName, parent, childName)
+	case parent <= child:
+		return fmt.Errorf("%s (%s) must be greater than %s (%s)", parentName, parent, childName, child)
+	}
+	return nil
+}
+
+// reloadableTimeouts are the keys re-applied by reloadTimeouts.
+var reloadableTimeouts = []string{"HANDLER_TIMEOUT", "SERVICE_B_TIMEOUT"}
+
+// activeTimeouts holds the timeouts in effect. The handler and per-call
+// budgets are reloadable; the transport ones apply from startup.
+var activeTimeouts atomic.Pointer[timeoutConfig]
+
+// reloadTimeouts swaps in the reloaded timeouts, keeping the current ones if
+// the new hierarchy is inconsistent.
+func reloadTimeouts() {
+	t := loadTimeouts()
+	if err := t.Validate(); err != nil {
+		slog.Error("ignoring inconsistent reloaded timeouts", "error", err)
+		return
+	}
+	activeTimeouts.Store(&t)
+}
+
+// withTimeout bounds ctx by d, where zero means unbounded.
+func withTimeout(ctx context.Context, d time.Duration) (context.Context, context.CancelFunc) {
+	if d <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, d)
+}
+
+// deadlineHeader carries the remaining budget of a request to service-b, in
+// milliseconds, so it stops working on requests service-a has given up on.
+const deadlineHeader = "X-Request-Timeout"
+
+// setDeadlineHeader sends what is left of the deadline of ctx, if it has one.
+func setDeadlineHeader(ctx context.Context, req *http.Request) {
+	if deadline, ok := ctx.Deadline(); ok {
+		req.Header.Set(deadlineHeader, strconv.FormatInt(max(time.Until(deadline).Milliseconds(), 1), 10))
+	}
+}
+
+// timeoutMiddleware bounds each request by HANDLER_TIMEOUT, so a hung
+// upstream can't hold a handler forever.
+func timeoutMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := withTimeout(r.Context(), activeTimeouts.Load().handler)
+		defer cancel()
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// newServer builds a listener's server with the connection-level timeouts, so
+// slow clients can't hold connections open: READ_HEADER_TIMEOUT and
+// READ_TIMEOUT bound reading the request, IDLE_TIMEOUT keep-alive connections.
+func newServer(addr string, handler http.Handler) *http.Server {
+	return &http.Server{
+		Addr:              addr,
+		Handler:           handler,
+		ReadHeaderTimeout: viper.GetDuration("READ_HEADER_TIMEOUT"),
+		ReadTimeout:       viper.GetDuration("READ_TIMEOUT"),
+		IdleTimeout:       viper.GetDuration("IDLE_TIMEOUT"),
+	}
+}
+
+// bodyLimitMiddleware caps request bodies at REQUEST_MAX_BYTES; reading past
+// the limit fails with an *http.MaxBytesError.
+func bodyLimitMiddleware(limit int64) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			r.Body = http.MaxBytesReader(w, r.Body, limit)
+			next.ServeHTTP(w, r)
+		})
+	}
+}
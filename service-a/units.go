@@ -0,0 +1,132 @@
+package main
+
+import (
+	"encoding/xml"
+	"math"
+	"net/http"
+	"strconv"
+
+	"goexpert-lab-2-observabilidade/internal/api"
+	"goexpert-lab-2-observabilidade/internal/apperr"
+)
+
+// maxPrecision bounds the precision query parameter.
+const maxPrecision = 6
+
+// unitSelection is the set of scales a client asked for with ?units=.
+type unitSelection struct {
+	celsius, fahrenheit, kelvin bool
+}
+
+var unitSelections = map[string]unitSelection{
+	"metric":   {celsius: true, kelvin: true},
+	"imperial": {fahrenheit: true},
+	"all":      {celsius: true, fahrenheit: true, kelvin: true},
+}
+
+// temperatureFormat is how a single temperature response is shaped, from
+// the units and precision query parameters.
+type temperatureFormat struct {
+	units     unitSelection
+	precision int // decimal places, or -1 to leave values unrounded
+}
+
+// parseTemperatureFormat reads ?units=metric|imperial|all (default all) and
+// ?precision=0..6 (default unrounded).
+func parseTemperatureFormat(r *http.Request) (temperatureFormat, error) {
+	f := temperatureFormat{units: unitSelections["all"], precision: -1}
+	q := r.URL.Query()
+
+	if v := q.Get("units"); v != "" {
+		units, ok := unitSelections[v]
+		if !ok {
+			return f, apperr.ErrInvalidParam.WithFields(apperr.FieldError{Field: "units", Message: "must be metric, imperial or all"})
+		}
+		f.units = units
+	}
+	if v := q.Get("precision"); v != "" {
+		p, err := strconv.Atoi(v)
+		if err != nil || p < 0 || p > maxPrecision {
+			return f, apperr.ErrInvalidParam.WithFields(apperr.FieldError{Field: "precision", Message: "must be an integer from 0 to " + strconv.Itoa(maxPrecision)})
+		}
+		f.precision = p
+	}
+	return f, nil
+}
+
+// temperatureView is the response body for the requested scales only.
+type temperatureView struct {
+	XMLName xml.Name `json:"-" xml:"temperature"`
+	City    string   `json:"city" xml:"city"`
+	TempC   *float64 `json:"temp_C,omitempty" xml:"temp_C,omitempty"`
+	TempF   *float64 `json:"temp_F,omitempty" xml:"temp_F,omitempty"`
+	TempK   *float64 `json:"temp_K,omitempty" xml:"temp_K,omitempty"`
+
+	Condition string `json:"condition,omitempty" xml:"condition,omitempty"`
+	Timezone  string `json:"timezone,omitempty" xml:"timezone,omitempty"`
+	LocalTime string `json:"local_time,omitempty" xml:"local_time,omitempty"`
+
+	ObservedAt  string `json:"observed_at,omitempty" xml:"observed_at,omitempty"`
+	RetrievedAt string `json:"retrieved_at,omitempty" xml:"retrieved_at,omitempty"`
+}
+
+// view derives the requested scales from temp_C, so the others are never computed.
+func (f temperatureFormat) view(z api.Temperature) temperatureView {
+	v := temperatureView{
+		City:        z.City,
+		Condition:   z.Condition,
+		Timezone:    z.Timezone,
+		LocalTime:   z.LocalTime,
+		ObservedAt:  z.ObservedAt,
+		RetrievedAt: z.RetrievedAt,
+	}
+	if f.units.celsius {
+		v.TempC = f.round(z.TempC)
+	}
+	if f.units.fahrenheit {
+		v.TempF = f.round(z.TempC*1.8 + 32)
+	}
+	if f.units.kelvin {
+		v.TempK = f.round(z.TempC + 273)
+	}
+	return v
+}
+
+func (f temperatureFormat) round(v float64) *float64 {
+	if f.precision >= 0 {
+		scale := math.Pow10(f.precision)
+		v = math.Round(v*scale) / scale
+	}
+	return &v
+}
+
+func (v temperatureView) Validate() error {
+	for _, t := range []*float64{v.TempC, v.TempF, v.TempK} {
+		if t != nil && !api.Finite(*t) {
+			return api.ErrNonFinite
+		}
+	}
+	return nil
+}
+
+func (v temperatureView) csvRecords() [][]string {
+	header, row := []string{"city"}, []string{v.City}
+	for _, col := range []struct {
+		name  string
+		value *float64
+	}{{"temp_C", v.TempC}, {"temp_F", v.TempF}, {"temp_K", v.TempK}} {
+		if col.value != nil {
+			header = append(header, col.name)
+			row = append(row, strconv.FormatFloat(*col.value, 'f', -1, 64))
+		}
+	}
+	for _, col := range []struct{ name, value string }{
+		{"condition", v.Condition}, {"timezone", v.Timezone}, {"local_time", v.LocalTime},
+		{"observed_at", v.ObservedAt}, {"retrieved_at", v.RetrievedAt},
+	} {
+		if col.value != "" {
+			header, row = append(header, col.name), append(row, col.value)
+		}
+	}
+	return [][]string{header, row}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"goexpert-lab-2-observabilidade/internal/apperr"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// parseServiceBURL validates SERVICE_B_URL, the base URL service-b is reached
+// at, e.g. http://service-b:8081 or a gateway prefix.
+func parseServiceBURL(raw string) (string, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "", fmt.Errorf("SERVICE_B_URL is not a valid URL: %w", err)
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return "", fmt.Errorf("SERVICE_B_URL %q must be an absolute http or https URL", raw)
+	}
+	return strings.TrimSuffix(u.String(), "/"), nil
+}
+
+// statusError reports an unsuccessful status code returned by an upstream.
+type statusError struct {
+	target string
+	code   int
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("%s responded with status %d", e.target, e.code)
+}
+
+// checkStatus turns a non-2xx upstream response into a statusError.
+func checkStatus(target string, resp *http.Response) error {
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return &statusError{target: target, code: resp.StatusCode}
+	}
+	return nil
+}
+
+type retryClass string
+
+const (
+	retryable    retryClass = "retryable"
+	nonRetryable retryClass = "non_retryable"
+)
+
+var providerErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "provider_errors_total",
+	Help: "Failed upstream calls by target and retry classification.",
+}, []string{"target", "class"})
+
+// classifyRetry decides whether a failed upstream call is worth retrying:
+// timeouts, connection failures, 408, 429 and 5xx (other than 501) are
+// transient; everything else, such as 400, 401 and 404, is permanent.
+func classifyRetry(err error) retryClass {
+	var se *statusError
+	if errors.As(err, &se) {
+		switch {
+		case se.code == http.StatusRequestTimeout,
+			se.code == http.StatusTooManyRequests,
+			se.code >= 500 && se.code != http.StatusNotImplemented:
+			return retryable
+		}
+		return nonRetryable
+	}
+
+	var netErr net.Error
+	if apperr.IsTimeout(err) || errors.As(err, &netErr) {
+		return retryable
+	}
+	return nonRetryable
+}
+
+// observeProviderError classifies err, counts it for target and returns the class.
+func observeProviderError(target string, err error) retryClass {
+	class := classifyRetry(err)
+	providerErrors.WithLabelValues(target, string(class)).Inc()
+	return class
+}
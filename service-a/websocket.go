@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/coder/websocket"
+
+	"goexpert-lab-2-observabilidade/internal/apperr"
+	"goexpert-lab-2-observabilidade/internal/middleware"
+)
+
+// wsWriteTimeout bounds each message write, so a stalled client can't block
+// the goroutines pushing to it.
+const wsWriteTimeout = 10 * time.Second
+
+// errWebSocketClosed is returned by readMessage once the peer closed the
+// connection, and by writeText once it was closed on either side.
+var errWebSocketClosed = errors.New("websocket closed")
+
+// errBinaryMessage is returned by readMessage for a binary message, which
+// closes the connection.
+var errBinaryMessage = errors.New("websocket: only text messages are supported")
+
+// wsConn is an upgraded connection. Writes are serialized, so any goroutine
+// may send; reads must all come from a single goroutine.
+type wsConn struct {
+	conn   *websocket.Conn
+	closed atomic.Bool
+}
+
+// checkWebSocketUpgrade validates the opening handshake of r, so requests
+// that are not a valid upgrade get an error for api.Error, with only headers
+// set, rather than the plain text one of websocket.Accept. Cross-origin
+// upgrades are only accepted from the origins listed in CORS_ALLOWED_ORIGINS:
+// browsers send cookies and client certificates along with them.
+func checkWebSocketUpgrade(w http.ResponseWriter, r *http.Request, cors *corsPolicy) error {
+	if !middleware.IsWebSocketUpgrade(r) {
+		w.Header().Set("Upgrade", "websocket")
+		return apperr.ErrUpgradeRequired
+	}
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		return apperr.ErrUpgradeRequired.WithFields(apperr.FieldError{Field: "Sec-WebSocket-Version", Message: "must be 13"})
+	}
+	if decoded, err := base64.StdEncoding.DecodeString(r.Header.Get("Sec-WebSocket-Key")); err != nil || len(decoded) != 16 {
+		return apperr.ErrInvalidParam.WithFields(apperr.FieldError{Field: "Sec-WebSocket-Key", Message: "must be 16 bytes, base64 encoded"})
+	}
+	if !cors.allowsWebSocket(r) {
+		return apperr.ErrOriginNotAllowed.WithFields(apperr.FieldError{Field: "Origin", Message: "must be listed in CORS_ALLOWED_ORIGINS"})
+	}
+	return nil
+}
+
+// acceptWebSocket completes the opening handshake checked by
+// checkWebSocketUpgrade and takes over the connection. The 101 goes through
+// w, so the middlewares record it and add their headers; when it fails,
+// websocket.Accept has answered the request already.
+func acceptWebSocket(w http.ResponseWriter, r *http.Request, maxMessage int64) (*wsConn, error) {
+	conn, err := websocket.Accept(hijacker{w}, r, &websocket.AcceptOptions{
+		InsecureSkipVerify: true, // the origin was checked by checkWebSocketUpgrade
+	})
+	if err != nil {
+		return nil, err
+	}
+	conn.SetReadLimit(maxMessage)
+	return &wsConn{conn: conn}, nil
+}
+
+// hijacker lets websocket.Accept take over the connection through the
+// middlewares' response writers, which only expose it through Unwrap.
+type hijacker struct {
+	http.ResponseWriter
+}
+
+func (h hijacker) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, brw, err := http.NewResponseController(h.ResponseWriter).Hijack()
+	if err == nil {
+		// the server's read and write deadlines no longer apply
+		conn.SetDeadline(time.Time{})
+	}
+	return conn, brw, err
+}
+
+// allowsWebSocket reports whether r may be upgraded as far as its Origin
+// goes: requests without one, from clients other than browsers, same-origin
+// ones and the origins listed in CORS_ALLOWED_ORIGINS. Unlike CORS, a "*"
+// allows none: it doesn't let browsers send credentials either.
+func (c *corsPolicy) allowsWebSocket(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	if u, err := url.Parse(origin); err == nil && strings.EqualFold(u.Host, r.Host) {
+		return true
+	}
+	return c != nil && c.origins[origin] && origin != "*"
+}
+
+// readMessage returns the next text message; pings are answered and close
+// frames echoed on the way. It returns errWebSocketClosed after a close by
+// the peer, and closes the connection, with 1003 or 1009, on binary or too
+// large messages.
+func (c *wsConn) readMessage(ctx context.Context) ([]byte, error) {
+	typ, msg, err := c.conn.Read(ctx)
+	if err != nil {
+		if websocket.CloseStatus(err) != -1 {
+			return nil, errWebSocketClosed
+		}
+		return nil, err
+	}
+	if typ != websocket.MessageText {
+		c.close(websocket.StatusUnsupportedData, errBinaryMessage.Error())
+		return nil, errBinaryMessage
+	}
+	return msg, nil
+}
+
+// writeText sends msg as a text message.
+func (c *wsConn) writeText(ctx context.Context, msg []byte) error {
+	if c.closed.Load() {
+		return errWebSocketClosed
+	}
+	ctx, cancel := context.WithTimeout(ctx, wsWriteTimeout)
+	defer cancel()
+	return c.conn.Write(ctx, websocket.MessageText, msg)
+}
+
+// close sends a close frame with code, unless one was sent already, and
+// closes the connection. It is safe to call from any goroutine, repeatedly.
+func (c *wsConn) close(code websocket.StatusCode, reason string) {
+	if c.closed.Swap(true) {
+		return
+	}
+	c.conn.Close(code, reason)
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"goexpert-lab-2-observabilidade/internal/api"
+	"goexpert-lab-2-observabilidade/internal/apperr"
+)
+
+// aqiCategories names the US EPA index values, from 1.
+var aqiCategories = []string{"good", "moderate", "unhealthy_for_sensitive_groups", "unhealthy", "very_unhealthy", "hazardous"}
+
+// AirQualityInfo is the part of WeatherAPI's current.json response with aqi=yes we use.
+type AirQualityInfo struct {
+	Current struct {
+		AirQuality struct {
+			PM25       float64 `json:"pm2_5"`
+			PM10       float64 `json:"pm10"`
+			USEPAIndex int     `json:"us-epa-index"`
+		} `json:"air_quality"`
+	} `json:"current"`
+}
+
+// airQualityHandler serves GET /v1/airquality/{cep}, the current particulate
+// matter and AQI in the city of the CEP. Results are cached per CEP for
+// AIRQUALITY_CACHE_TTL.
+func (h *handler) airQualityHandler(w http.ResponseWriter, r *http.Request) {
+	zipCode := normalizeCEP(r.Context(), chi.URLParam(r, "cep"))
+	if len(zipCode) != 8 {
+		api.Error(w, r, apperr.ErrInvalidZip)
+		return
+	}
+
+	result, err := h.lookupAirQuality(r.Context(), zipCode)
+	if err != nil {
+		api.Error(w, r, err)
+		return
+	}
+	api.WriteJSON(w, r, http.StatusOK, result)
+}
+
+// lookupAirQuality returns the air quality for a valid CEP, from the cache
+// when it holds a fresh one.
+func (h *handler) lookupAirQuality(ctx context.Context, zipCode string) (api.AirQuality, error) {
+	cached, result := h.airQuality.Get(ctx, zipCode)
+	trace.SpanFromContext(ctx).SetAttributes(attribute.String("cache.airquality", result))
+	if result == cacheHit {
+		return cached, nil
+	}
+
+	city, err := h.locate(ctx, zipCode)
+	if err != nil {
+		return api.AirQuality{}, err
+	}
+
+	start := time.Now()
+	info, err := h.getAirQuality(ctx, city)
+	h.observeWeatherProvider("weatherapi", time.Since(start), err)
+	if err != nil {
+		return api.AirQuality{}, weatherError(err)
+	}
+
+	aq := info.Current.AirQuality
+	if aq.USEPAIndex < 1 || aq.USEPAIndex > len(aqiCategories) {
+		return api.AirQuality{}, apperr.ErrBadUpstreamPayload.Wrap(fmt.Errorf("us-epa-index %d is out of range", aq.USEPAIndex))
+	}
+	quality := api.AirQuality{
+		City:     city,
+		PM25:     aq.PM25,
+		PM10:     aq.PM10,
+		AQI:      aq.USEPAIndex,
+		Category: aqiCategories[aq.USEPAIndex-1],
+	}
+	h.airQuality.Set(ctx, zipCode, quality, h.degradation.CacheTTL(activeCacheTTLs.Load().airQuality))
+	return quality, nil
+}
+
+func (h *handler) getAirQuality(ctx context.Context, city string) (AirQualityInfo, error) {
+
+	ctx, span := h.tracer.Start(ctx, "Chamada externa: getAirQuality")
+	defer span.End()
+
+	ctx, cancel := withTimeout(ctx, activeTimeouts.Load().weatherAPI)
+	defer cancel()
+
+	completeUrl := fmt.Sprintf("%s/v1/current.json?q=%s&aqi=yes", weatherAPIBaseURL, url.QueryEscape(city))
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, completeUrl, nil)
+	if err != nil {
+		return AirQualityInfo{}, err
+	}
+	resp, err := h.client.Do(req)
+
+	if err != nil {
+		observeProviderError("weatherapi", err)
+		return AirQualityInfo{}, err
+	}
+	defer resp.Body.Close()
+
+	if err := checkWeatherStatus(resp); err != nil {
+		observeProviderError("weatherapi", err)
+		return AirQualityInfo{}, err
+	}
+
+	var info AirQualityInfo
+	if err := json.NewDecoder(resp.Body).Decode(&info); err != nil {
+		return AirQualityInfo{}, err
+	}
+
+	return info, nil
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const apiCEPBaseURL = "https://cdn.apicep.com"
+
+// apiCEPInfo is ApiCEP's CEP response; failures are reported in status, not
+// always with a matching HTTP status.
+type apiCEPInfo struct {
+	Status   int    `json:"status"`
+	City     string `json:"city"`
+	State    string `json:"state"`
+	District string `json:"district"`
+	Address  string `json:"address"` // e.g. "Avenida Paulista - de 612 a 1510 - lado par"
+}
+
+// apiCEPProvider is CEPProvider on ApiCEP's static files, which are keyed by
+// the formatted CEP and have no IBGE codes.
+type apiCEPProvider struct {
+	client *http.Client
+	tracer trace.Tracer
+}
+
+func (p apiCEPProvider) Name() string    { return providerApiCEP }
+func (p apiCEPProvider) BaseURL() string { return apiCEPBaseURL }
+
+func (p apiCEPProvider) Lookup(ctx context.Context, zipCode string) (LocationInfo, error) {
+
+	ctx, span := p.tracer.Start(ctx, "Chamada externa: getLocation", trace.WithAttributes(attribute.String("cep.provider", providerApiCEP)))
+	defer span.End()
+
+	ctx, cancel := withTimeout(ctx, activeTimeouts.Load().viaCEP)
+	defer cancel()
+
+	url := fmt.Sprintf("%s/file/apicep/%s-%s.json", apiCEPBaseURL, zipCode[:5], zipCode[5:])
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return LocationInfo{}, err
+	}
+	resp, err := p.client.Do(req)
+
+	if err != nil {
+		observeProviderError(providerApiCEP, err)
+		return LocationInfo{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return cepUnknown(span, providerApiCEP, zipCode), nil
+	}
+	if err := checkStatus(providerApiCEP, resp); err != nil {
+		observeProviderError(providerApiCEP, err)
+		return LocationInfo{}, err
+	}
+
+	var info apiCEPInfo
+	if err := json.NewDecoder(resp.Body).Decode(&info); err != nil {
+		return LocationInfo{}, err
+	}
+	switch info.Status {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return cepUnknown(span, providerApiCEP, zipCode), nil
+	default:
+		err := &statusError{target: providerApiCEP, code: info.Status}
+		observeProviderError(providerApiCEP, err)
+		return LocationInfo{}, err
+	}
+
+	street, _, _ := strings.Cut(info.Address, " - ")
+	return LocationInfo{
+		Localidade: info.City,
+		Logradouro: street,
+		Bairro:     info.District,
+		UF:         info.State,
+	}, nil
+}
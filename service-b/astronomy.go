@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"goexpert-lab-2-observabilidade/internal/api"
+	"goexpert-lab-2-observabilidade/internal/apperr"
+)
+
+// brasilia is the time zone that picks the default date, as most of Brazil
+// observes it; fixed, so the image needs no tzdata.
+var brasilia = time.FixedZone("BRT", -3*60*60)
+
+// AstronomyInfo is the part of WeatherAPI's astronomy.json response we use.
+type AstronomyInfo struct {
+	Astronomy struct {
+		Astro struct {
+			Sunrise          string `json:"sunrise"`
+			Sunset           string `json:"sunset"`
+			Moonrise         string `json:"moonrise"`
+			Moonset          string `json:"moonset"`
+			MoonPhase        string `json:"moon_phase"`
+			MoonIllumination int    `json:"moon_illumination"`
+		} `json:"astro"`
+	} `json:"astronomy"`
+}
+
+// astronomyHandler serves GET /v1/astronomy/{cep}?date=YYYY-MM-DD, the sun
+// and moon schedule in the city of the CEP on that date, today in Brasília
+// by default. Results are cached per CEP and date for ASTRONOMY_CACHE_TTL.
+func (h *handler) astronomyHandler(w http.ResponseWriter, r *http.Request) {
+	date := time.Now().In(brasilia).Format(time.DateOnly)
+	if v := r.URL.Query().Get("date"); v != "" {
+		if _, err := time.Parse(time.DateOnly, v); err != nil {
+			api.Error(w, r, apperr.ErrInvalidParam.Wrap(err).WithFields(apperr.FieldError{Field: "date", Message: "must be a date like 2024-07-01"}))
+			return
+		}
+		date = v
+	}
+
+	zipCode := normalizeCEP(r.Context(), chi.URLParam(r, "cep"))
+	if len(zipCode) != 8 {
+		api.Error(w, r, apperr.ErrInvalidZip)
+		return
+	}
+
+	result, err := h.lookupAstronomy(r.Context(), zipCode, date)
+	if err != nil {
+		api.Error(w, r, err)
+		return
+	}
+	api.WriteJSON(w, r, http.StatusOK, result)
+}
+
+// lookupAstronomy returns the astronomy of a valid CEP on date, from the
+// cache when it holds a fresh one.
+func (h *handler) lookupAstronomy(ctx context.Context, zipCode, date string) (api.Astronomy, error) {
+	key := zipCode + "/" + date
+	cached, result := h.astronomy.Get(ctx, key)
+	trace.SpanFromContext(ctx).SetAttributes(attribute.String("cache.astronomy", result))
+	if result == cacheHit {
+		return cached, nil
+	}
+
+	city, err := h.locate(ctx, zipCode)
+	if err != nil {
+		return api.Astronomy{}, err
+	}
+
+	start := time.Now()
+	info, err := h.getAstronomy(ctx, city, date)
+	h.observeWeatherProvider("weatherapi", time.Since(start), err)
+	if err != nil {
+		return api.Astronomy{}, weatherError(err)
+	}
+
+	astro := info.Astronomy.Astro
+	astronomy := api.Astronomy{
+		City:             city,
+		Date:             date,
+		Sunrise:          clockTime(astro.Sunrise),
+		Sunset:           clockTime(astro.Sunset),
+		Moonrise:         clockTime(astro.Moonrise),
+		Moonset:          clockTime(astro.Moonset),
+		MoonPhase:        astro.MoonPhase,
+		MoonIllumination: astro.MoonIllumination,
+	}
+	h.astronomy.Set(ctx, key, astronomy, h.degradation.CacheTTL(activeCacheTTLs.Load().astronomy))
+	return astronomy, nil
+}
+
+// clockTime turns a WeatherAPI time such as "06:05 PM" into "18:05". Values
+// that are not a time, like "No moonrise", become empty.
+func clockTime(v string) string {
+	t, err := time.Parse("03:04 PM", v)
+	if err != nil {
+		return ""
+	}
+	return t.Format("15:04")
+}
+
+func (h *handler) getAstronomy(ctx context.Context, city, date string) (AstronomyInfo, error) {
+
+	ctx, span := h.tracer.Start(ctx, "Chamada externa: getAstronomy", trace.WithAttributes(attribute.String("astronomy.date", date)))
+	defer span.End()
+
+	ctx, cancel := withTimeout(ctx, activeTimeouts.Load().weatherAPI)
+	defer cancel()
+
+	completeUrl := fmt.Sprintf("%s/v1/astronomy.json?q=%s&dt=%s", weatherAPIBaseURL, url.QueryEscape(city), date)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, completeUrl, nil)
+	if err != nil {
+		return AstronomyInfo{}, err
+	}
+	resp, err := h.client.Do(req)
+
+	if err != nil {
+		observeProviderError("weatherapi", err)
+		return AstronomyInfo{}, err
+	}
+	defer resp.Body.Close()
+
+	if err := checkWeatherStatus(resp); err != nil {
+		observeProviderError("weatherapi", err)
+		return AstronomyInfo{}, err
+	}
+
+	var info AstronomyInfo
+	if err := json.NewDecoder(resp.Body).Decode(&info); err != nil {
+		return AstronomyInfo{}, err
+	}
+
+	return info, nil
+}
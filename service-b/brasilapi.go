@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const brasilAPIBaseURL = "https://brasilapi.com.br"
+
+// brasilAPIInfo is BrasilAPI's CEP response.
+type brasilAPIInfo struct {
+	City         string `json:"city"`
+	State        string `json:"state"`
+	Neighborhood string `json:"neighborhood"`
+	Street       string `json:"street"`
+}
+
+// brasilAPIProvider is CEPProvider on BrasilAPI's CEP API, which answers
+// unknown CEPs with a 404 and has no IBGE codes.
+type brasilAPIProvider struct {
+	client *http.Client
+	tracer trace.Tracer
+}
+
+func (p brasilAPIProvider) Name() string    { return providerBrasilAPI }
+func (p brasilAPIProvider) BaseURL() string { return brasilAPIBaseURL }
+
+func (p brasilAPIProvider) Lookup(ctx context.Context, zipCode string) (LocationInfo, error) {
+
+	ctx, span := p.tracer.Start(ctx, "Chamada externa: getLocation", trace.WithAttributes(attribute.String("cep.provider", providerBrasilAPI)))
+	defer span.End()
+
+	ctx, cancel := withTimeout(ctx, activeTimeouts.Load().viaCEP)
+	defer cancel()
+
+	url := fmt.Sprintf("%s/api/cep/v1/%s", brasilAPIBaseURL, zipCode)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return LocationInfo{}, err
+	}
+	resp, err := p.client.Do(req)
+
+	if err != nil {
+		observeProviderError(providerBrasilAPI, err)
+		return LocationInfo{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return cepUnknown(span, providerBrasilAPI, zipCode), nil
+	}
+	if err := checkStatus(providerBrasilAPI, resp); err != nil {
+		observeProviderError(providerBrasilAPI, err)
+		return LocationInfo{}, err
+	}
+
+	var info brasilAPIInfo
+	if err := json.NewDecoder(resp.Body).Decode(&info); err != nil {
+		return LocationInfo{}, err
+	}
+
+	return LocationInfo{
+		Localidade: info.City,
+		Logradouro: info.Street,
+		Bairro:     info.Neighborhood,
+		UF:         info.State,
+	}, nil
+}
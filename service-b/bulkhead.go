@@ -0,0 +1,129 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/spf13/viper"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"goexpert-lab-2-observabilidade/internal/apperr"
+)
+
+var (
+	upstreamInFlight = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "upstream_in_flight_requests",
+		Help: "Upstream calls in flight, bodies included, by host.",
+	}, []string{"host"})
+
+	bulkheadRejections = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "bulkhead_rejections_total",
+		Help: "Upstream calls refused because the host had too many in flight, by host.",
+	}, []string{"host"})
+)
+
+type bulkheadConfig struct {
+	limit int           // concurrent calls per host; 0 leaves them unbounded
+	wait  time.Duration // how long a call over the limit waits for a slot
+}
+
+func loadBulkheadConfig() bulkheadConfig {
+	return bulkheadConfig{
+		limit: viper.GetInt("UPSTREAM_MAX_CONCURRENT"),
+		wait:  viper.GetDuration("UPSTREAM_QUEUE_TIMEOUT"),
+	}
+}
+
+// Validate checks that neither the limit nor the wait is negative.
+func (c bulkheadConfig) Validate() error {
+	var errs []error
+	if c.limit < 0 {
+		errs = append(errs, fmt.Errorf("UPSTREAM_MAX_CONCURRENT (%d) must not be negative", c.limit))
+	}
+	if c.wait < 0 {
+		errs = append(errs, fmt.Errorf("UPSTREAM_QUEUE_TIMEOUT (%s) must not be negative", c.wait))
+	}
+	return errors.Join(errs...)
+}
+
+// bulkheadTransport bounds the concurrent calls to each upstream host, so a
+// slow one can't take every goroutine and connection of the service. A call
+// holds its slot until its body is closed; calls over the limit wait for one
+// for up to the configured wait, within their own deadline, and then fail
+// with ErrBulkheadFull.
+type bulkheadTransport struct {
+	base http.RoundTripper
+	cfg  bulkheadConfig
+
+	mu    sync.Mutex
+	slots map[string]chan struct{}
+}
+
+func newBulkheadTransport(base http.RoundTripper, cfg bulkheadConfig) *bulkheadTransport {
+	return &bulkheadTransport{base: base, cfg: cfg, slots: make(map[string]chan struct{})}
+}
+
+func (t *bulkheadTransport) hostSlots(host string) chan struct{} {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	s, ok := t.slots[host]
+	if !ok {
+		s = make(chan struct{}, t.cfg.limit)
+		t.slots[host] = s
+	}
+	return s
+}
+
+func (t *bulkheadTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	if t.cfg.limit == 0 {
+		return t.base.RoundTrip(r)
+	}
+	host := r.URL.Host
+	slots := t.hostSlots(host)
+	select {
+	case slots <- struct{}{}:
+	default:
+		timer := time.NewTimer(t.cfg.wait)
+		defer timer.Stop()
+		select {
+		case slots <- struct{}{}:
+		case <-r.Context().Done():
+			return nil, r.Context().Err()
+		case <-timer.C:
+			bulkheadRejections.WithLabelValues(host).Inc()
+			trace.SpanFromContext(r.Context()).AddEvent("bulkhead rejected", trace.WithAttributes(attribute.String("server.address", host)))
+			return nil, apperr.ErrBulkheadFull.Wrap(fmt.Errorf("%d calls to %s in flight", t.cfg.limit, host))
+		}
+	}
+
+	inFlight := upstreamInFlight.WithLabelValues(host)
+	inFlight.Inc()
+	release := sync.OnceFunc(func() {
+		inFlight.Dec()
+		<-slots
+	})
+	resp, err := t.base.RoundTrip(r)
+	if err != nil {
+		release()
+		return nil, err
+	}
+	resp.Body = releasingBody{ReadCloser: resp.Body, release: release}
+	return resp, nil
+}
+
+// releasingBody gives the slot of a call back once its body is closed.
+type releasingBody struct {
+	io.ReadCloser
+	release func()
+}
+
+func (b releasingBody) Close() error {
+	defer b.release()
+	return b.ReadCloser.Close()
+}
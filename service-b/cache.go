@@ -0,0 +1,107 @@
+package main
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+
+	"goexpert-lab-2-observabilidade/internal/middleware"
+)
+
+// Cache backends, as set in CACHE_BACKEND.
+const (
+	cacheBackendMemory = "memory"
+	cacheBackendRedis  = "redis"
+)
+
+// cache stores values by key for a TTL, in the memory of the instance or on
+// Redis, shared by every replica.
+type cache[V any] interface {
+	// Get returns the value stored under key, unless it is missing or
+	// expired, along with the lookup result: cacheHit, cacheMiss or cacheStale.
+	Get(ctx context.Context, key string) (V, string)
+	// Set stores value under key for ttl.
+	Set(ctx context.Context, key string, value V, ttl time.Duration)
+}
+
+// newCache builds the named cache on Redis when redis is set, or else in
+// memory with up to size entries.
+func newCache[V any](name string, size int, redis *redisClient) cache[V] {
+	if redis != nil {
+		return redisCache[V]{name: name, client: redis}
+	}
+	return newMemoryCache[V](name, size)
+}
+
+// memoryCache is a bounded in-memory cache whose entries expire after the TTL
+// they were stored with. Lookups and evictions are counted under its name on
+// cache_requests_total and cache_evictions_total.
+type memoryCache[V any] struct {
+	name string
+	max  int
+
+	mu      sync.Mutex
+	entries map[string]*list.Element // of *cacheEntry[V], in order
+	order   *list.List               // most recently used first
+}
+
+type cacheEntry[V any] struct {
+	key     string
+	value   V
+	expires time.Time
+}
+
+func newMemoryCache[V any](name string, max int) *memoryCache[V] {
+	return &memoryCache[V]{name: name, max: max, entries: make(map[string]*list.Element), order: list.New()}
+}
+
+// Get returns the value stored under key, unless it is missing or expired,
+// along with the lookup result: cacheHit, cacheMiss or cacheStale.
+func (c *memoryCache[V]) Get(ctx context.Context, key string) (V, string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.entries[key]
+	result := cacheHit
+	switch {
+	case !ok:
+		result = cacheMiss
+	case time.Now().After(el.Value.(*cacheEntry[V]).expires):
+		c.removeLocked(el)
+		result = cacheStale
+	}
+	observeCacheLookup(c.name, result)
+	if result != cacheHit {
+		var zero V
+		return zero, result
+	}
+	c.order.MoveToFront(el)
+	middleware.MarkFeature(ctx, usesCacheMemory)
+	return el.Value.(*cacheEntry[V]).value, result
+}
+
+// Set stores value under key for ttl. When the cache is full, the least
+// recently used entry makes room for it.
+func (c *memoryCache[V]) Set(_ context.Context, key string, value V, ttl time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	expires := time.Now().Add(ttl)
+	if el, ok := c.entries[key]; ok {
+		e := el.Value.(*cacheEntry[V])
+		e.value, e.expires = value, expires
+		c.order.MoveToFront(el)
+		return
+	}
+	if c.order.Len() >= c.max {
+		c.removeLocked(c.order.Back())
+		observeCacheEviction(c.name)
+	}
+	c.entries[key] = c.order.PushFront(&cacheEntry[V]{key: key, value: value, expires: expires})
+}
+
+func (c *memoryCache[V]) removeLocked(el *list.Element) {
+	c.order.Remove(el)
+	delete(c.entries, el.Value.(*cacheEntry[V]).key)
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync/atomic"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// cacheTTLs are how long each cache keeps what it stores.
+type cacheTTLs struct {
+	cep          time.Duration
+	cepNotFound  time.Duration
+	weather      time.Duration
+	weatherStale time.Duration // how long expired weather is kept; 0 drops it
+	forecast     time.Duration
+	city         time.Duration
+	airQuality   time.Duration
+	astronomy    time.Duration
+}
+
+func loadCacheTTLs() cacheTTLs {
+	return cacheTTLs{
+		cep:          viper.GetDuration("CEP_CACHE_TTL"),
+		cepNotFound:  viper.GetDuration("CEP_NOT_FOUND_CACHE_TTL"),
+		weather:      viper.GetDuration("WEATHER_CACHE_TTL"),
+		weatherStale: viper.GetDuration("WEATHER_STALE_TTL"),
+		forecast:     viper.GetDuration("FORECAST_CACHE_TTL"),
+		city:         viper.GetDuration("CITY_CACHE_TTL"),
+		airQuality:   viper.GetDuration("AIRQUALITY_CACHE_TTL"),
+		astronomy:    viper.GetDuration("ASTRONOMY_CACHE_TTL"),
+	}
+}
+
+// Validate checks the TTLs the way validateConfig does at startup.
+func (t cacheTTLs) Validate() error {
+	var errs []error
+	for _, c := range []struct {
+		key string
+		ttl time.Duration
+	}{
+		{"CEP_CACHE_TTL", t.cep},
+		{"CEP_NOT_FOUND_CACHE_TTL", t.cepNotFound},
+		{"WEATHER_CACHE_TTL", t.weather},
+		{"FORECAST_CACHE_TTL", t.forecast},
+		{"CITY_CACHE_TTL", t.city},
+		{"AIRQUALITY_CACHE_TTL", t.airQuality},
+		{"ASTRONOMY_CACHE_TTL", t.astronomy},
+	} {
+		if c.ttl <= 0 {
+			errs = append(errs, fmt.Errorf("%s (%s) must be positive", c.key, c.ttl))
+		}
+	}
+	if t.weatherStale < 0 {
+		errs = append(errs, fmt.Errorf("WEATHER_STALE_TTL (%s) must not be negative", t.weatherStale))
+	}
+	return errors.Join(errs...)
+}
+
+// reloadableCacheTTLs are the keys re-applied by reloadCacheTTLs.
+var reloadableCacheTTLs = []string{
+	"CEP_CACHE_TTL", "CEP_NOT_FOUND_CACHE_TTL", "WEATHER_CACHE_TTL", "WEATHER_STALE_TTL",
+	"FORECAST_CACHE_TTL", "CITY_CACHE_TTL", "AIRQUALITY_CACHE_TTL", "ASTRONOMY_CACHE_TTL",
+}
+
+// activeCacheTTLs holds the TTLs entries are stored with from now on;
+// entries already cached keep the TTL they were stored with.
+var activeCacheTTLs atomic.Pointer[cacheTTLs]
+
+// reloadCacheTTLs swaps in the reloaded TTLs, keeping the current ones if
+// any of the new ones is invalid.
+func reloadCacheTTLs() {
+	t := loadCacheTTLs()
+	if err := t.Validate(); err != nil {
+		slog.Error("ignoring invalid reloaded cache TTLs", "error", err)
+		return
+	}
+	activeCacheTTLs.Store(&t)
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"goexpert-lab-2-observabilidade/internal/middleware"
+)
+
+var (
+	cepNotFound = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "cep_not_found_total",
+		Help: "Well-formed CEPs a provider does not know, by provider.",
+	}, []string{"provider"})
+
+	cepNotFoundLookups = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "cep_not_found_lookups_total",
+		Help: "Lookups of CEPs no provider knows, by postal region (the first digit) and source (provider, cache).",
+	}, []string{"region", "source"})
+
+	cepLookupDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "cep_lookup_duration_seconds",
+		Help:    "CEP lookups by provider and result (found, not_found, error).",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"provider", "result"})
+
+	cepRaceWins = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "cep_race_wins_total",
+		Help: "Raced CEP lookups, by the provider that answered first.",
+	}, []string{"provider"})
+)
+
+// CEPProvider resolves a CEP to its address with one vendor. The result is
+// in the shape of ViaCEP's response, which the other vendors are translated
+// to, with Erro set for the CEPs the vendor doesn't know.
+type CEPProvider interface {
+	Name() string
+	BaseURL() string
+	Lookup(ctx context.Context, cep string) (LocationInfo, error)
+}
+
+// Names of the CEP providers, as listed in CEP_PROVIDERS.
+const (
+	providerViaCEP    = "viacep"
+	providerBrasilAPI = "brasilapi"
+	providerApiCEP    = "apicep"
+)
+
+var cepProviderNames = []string{providerViaCEP, providerBrasilAPI, providerApiCEP}
+
+// CEP lookup modes, as set in CEP_LOOKUP_MODE.
+const (
+	cepModeFailover = "failover"
+	cepModeRace     = "race"
+)
+
+// newCEPProviders builds the named providers, which make their calls with client.
+func newCEPProviders(names []string, client *http.Client, tracer trace.Tracer) map[string]CEPProvider {
+	providers := make(map[string]CEPProvider, len(names))
+	for _, name := range names {
+		switch name {
+		case providerViaCEP:
+			providers[name] = viaCEPProvider{client: client, tracer: tracer}
+		case providerBrasilAPI:
+			providers[name] = brasilAPIProvider{client: client, tracer: tracer}
+		case providerApiCEP:
+			providers[name] = apiCEPProvider{client: client, tracer: tracer}
+		}
+	}
+	return providers
+}
+
+// lookupCEP looks zipCode up on CEP_PROVIDERS, in the order picked by
+// PROVIDER_SELECTION, failing over to the next provider on errors, and
+// returns the location with the provider that answered. With
+// CEP_LOOKUP_MODE=race the providers are raced instead.
+func (h *handler) lookupCEP(ctx context.Context, zipCode string) (LocationInfo, string, error) {
+	if h.cepRace {
+		return h.raceCEP(ctx, zipCode)
+	}
+	var location LocationInfo
+	var provider string
+	err := withFailover(ctx, "cep", h.cepSelector.Order(ctx, h.cepNames), func(name string) error {
+		start := time.Now()
+		found, err := h.cepProviders[name].Lookup(ctx, zipCode)
+		h.cepSelector.Observe(name, time.Since(start), err)
+		h.degradation.ObserveDependency(name, err == nil)
+		observeCEPLookup(name, time.Since(start), found, err)
+		location, provider = found, name
+		return err
+	}, isCEPRejected)
+	trace.SpanFromContext(ctx).SetAttributes(attribute.String("cep.provider", provider))
+	return location, provider, err
+}
+
+// errRaceLost cancels the lookups of a race once one of them has answered.
+var errRaceLost = errors.New("another provider answered first")
+
+// raceCEP looks zipCode up on every CEP provider at once and returns the
+// first answer, an unknown CEP included, cancelling the lookups still
+// running. It only fails when every provider does, with the error of the
+// first one in order.
+func (h *handler) raceCEP(ctx context.Context, zipCode string) (LocationInfo, string, error) {
+	middleware.MarkFeature(ctx, usesHedging)
+	span := trace.SpanFromContext(ctx)
+
+	raceCtx, cancel := context.WithCancelCause(ctx)
+	defer cancel(errRaceLost)
+
+	type answer struct {
+		provider string
+		location LocationInfo
+		err      error
+	}
+	names := h.cepSelector.Order(ctx, h.cepNames)
+	answers := make(chan answer, len(names))
+	for _, name := range names {
+		go func() {
+			start := time.Now()
+			location, err := h.cepProviders[name].Lookup(raceCtx, zipCode)
+			// a loser cancelled by the winner hasn't failed, so it isn't observed
+			if err == nil || context.Cause(raceCtx) != errRaceLost {
+				h.cepSelector.Observe(name, time.Since(start), err)
+				h.degradation.ObserveDependency(name, err == nil)
+				observeCEPLookup(name, time.Since(start), location, err)
+			}
+			answers <- answer{name, location, err}
+		}()
+	}
+
+	errs := make(map[string]error, len(names))
+	for range names {
+		a := <-answers
+		if a.err == nil {
+			cepRaceWins.WithLabelValues(a.provider).Inc()
+			span.SetAttributes(attribute.String("cep.provider", a.provider), attribute.Bool("cep.race", true))
+			return a.location, a.provider, nil
+		}
+		errs[a.provider] = a.err
+	}
+	span.SetAttributes(attribute.String("cep.provider", names[0]), attribute.Bool("cep.race", true))
+	return LocationInfo{}, names[0], errs[names[0]]
+}
+
+// isCEPRejected reports whether a provider rejected the CEP itself, with a
+// 400, which no other provider would accept.
+func isCEPRejected(err error) bool {
+	var se *statusError
+	return errors.As(err, &se) && se.code == http.StatusBadRequest
+}
+
+// observeCEPLookup records a lookup of the named provider on cep_lookup_duration_seconds.
+func observeCEPLookup(provider string, d time.Duration, location LocationInfo, err error) {
+	result := "found"
+	if err != nil {
+		result = "error"
+	} else if location.Erro {
+		result = "not_found"
+	}
+	cepLookupDuration.WithLabelValues(provider, result).Observe(d.Seconds())
+}
+
+// cepUnknown returns the location of a CEP the provider doesn't know, after
+// recording it on span.
+func cepUnknown(span trace.Span, provider, cep string) LocationInfo {
+	span.AddEvent("cep not found", trace.WithAttributes(attribute.String("cep", cep)))
+	cepNotFound.WithLabelValues(provider).Inc()
+	return LocationInfo{Erro: true}
+}
+
+// viaCEPProvider is CEPProvider on ViaCEP.
+type viaCEPProvider struct {
+	client *http.Client
+	tracer trace.Tracer
+}
+
+func (p viaCEPProvider) Name() string    { return providerViaCEP }
+func (p viaCEPProvider) BaseURL() string { return viaCEPBaseURL }
+
+func (p viaCEPProvider) Lookup(ctx context.Context, zipCode string) (LocationInfo, error) {
+
+	ctx, span := p.tracer.Start(ctx, "Chamada externa: getLocation", trace.WithAttributes(attribute.String("cep.provider", providerViaCEP)))
+	defer span.End()
+
+	ctx, cancel := withTimeout(ctx, activeTimeouts.Load().viaCEP)
+	defer cancel()
+
+	url := fmt.Sprintf("%s/ws/%s/json/", viaCEPBaseURL, zipCode)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return LocationInfo{}, err
+	}
+	resp, err := p.client.Do(req)
+
+	if err != nil {
+		observeProviderError("viacep", err)
+		return LocationInfo{}, err
+	}
+	defer resp.Body.Close()
+
+	if err := checkStatus("viacep", resp); err != nil {
+		observeProviderError("viacep", err)
+		return LocationInfo{}, err
+	}
+
+	var location LocationInfo
+	if err := json.NewDecoder(resp.Body).Decode(&location); err != nil {
+		return LocationInfo{}, err
+	}
+	if location.Erro {
+		return cepUnknown(span, providerViaCEP, zipCode), nil
+	}
+
+	return location, nil
+}
+
+// normalizeCEP strips the hyphen and whitespace of formatted CEPs such as
+// "01310-100" before validation, and records the result on the span in ctx.
+func normalizeCEP(ctx context.Context, raw string) string {
+	cep := strings.Map(func(r rune) rune {
+		if r == '-' || unicode.IsSpace(r) {
+			return -1
+		}
+		return r
+	}, raw)
+	trace.SpanFromContext(ctx).SetAttributes(attribute.String("cep.normalized", cep))
+	return cep
+}
+
+// observeCEPNotFound records a lookup of an unknown CEP, answered by a
+// provider or by the cache of not found CEPs. A steady rate of them in one
+// region is what a scanner walking a CEP range looks like.
+func observeCEPNotFound(cep, source string) {
+	cepNotFoundLookups.WithLabelValues(cep[:1], source).Inc()
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/go-chi/chi/v5"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"goexpert-lab-2-observabilidade/internal/api"
+	"goexpert-lab-2-observabilidade/internal/apperr"
+)
+
+// maxCityName bounds the city names accepted, in characters.
+const maxCityName = 100
+
+// states are the Brazilian states by UF, named as WeatherAPI reports them in
+// location.region once folded.
+var states = map[string]string{
+	"AC": "Acre", "AL": "Alagoas", "AP": "Amapá", "AM": "Amazonas", "BA": "Bahia",
+	"CE": "Ceará", "DF": "Distrito Federal", "ES": "Espírito Santo", "GO": "Goiás",
+	"MA": "Maranhão", "MT": "Mato Grosso", "MS": "Mato Grosso do Sul", "MG": "Minas Gerais",
+	"PA": "Pará", "PB": "Paraíba", "PR": "Paraná", "PE": "Pernambuco", "PI": "Piauí",
+	"RJ": "Rio de Janeiro", "RN": "Rio Grande do Norte", "RS": "Rio Grande do Sul",
+	"RO": "Rondônia", "RR": "Roraima", "SC": "Santa Catarina", "SP": "São Paulo",
+	"SE": "Sergipe", "TO": "Tocantins",
+}
+
+// stateUFs lists the UFs in states, sorted.
+func stateUFs() []string {
+	ufs := make([]string, 0, len(states))
+	for uf := range states {
+		ufs = append(ufs, uf)
+	}
+	sort.Strings(ufs)
+	return ufs
+}
+
+// temperatureByCityHandler serves GET /v1/temperature/city/{name}, for callers
+// without a CEP. The name is URL-escaped, e.g. S%C3%A3o%20Paulo, and ?uf=SC
+// picks between cities of the same name in different states. Results are
+// cached per city for CITY_CACHE_TTL.
+func (h *handler) temperatureByCityHandler(w http.ResponseWriter, r *http.Request) {
+	h.serveLookup(w, r, func(ctx context.Context) (api.Temperature, error) {
+		name, uf, err := parseCity(r)
+		if err != nil {
+			return api.Temperature{}, err
+		}
+		trace.SpanFromContext(ctx).SetAttributes(attribute.String("city.name", name), attribute.String("city.uf", uf))
+		return h.lookupCity(ctx, name, uf)
+	})
+}
+
+// parseCity reads the city name from the path and the optional UF, reporting
+// every invalid parameter at once.
+func parseCity(r *http.Request) (name, uf string, err error) {
+	name = chi.URLParam(r, "name")
+	// chi matches on the escaped path when it differs from the decoded one, e.g. for %2F
+	if r.URL.RawPath != "" {
+		if name, err = url.PathUnescape(name); err != nil {
+			return "", "", apperr.ErrInvalidParam.Wrap(err).WithFields(apperr.FieldError{Field: "name", Message: "must be URL-escaped"})
+		}
+	}
+	name = strings.Join(strings.Fields(name), " ")
+	uf = strings.ToUpper(strings.TrimSpace(r.URL.Query().Get("uf")))
+
+	var fields []apperr.FieldError
+	if n := utf8.RuneCountInString(name); n == 0 || n > maxCityName {
+		fields = append(fields, apperr.FieldError{Field: "name", Message: "must have from 1 to 100 characters"})
+	}
+	if _, ok := states[uf]; uf != "" && !ok {
+		fields = append(fields, apperr.FieldError{Field: "uf", Message: "must be the UF of a Brazilian state, e.g. SP"})
+	}
+	if len(fields) > 0 {
+		return "", "", apperr.ErrInvalidParam.WithFields(fields...)
+	}
+	return name, uf, nil
+}
+
+// lookupCity fetches the current temperature in the Brazilian city name, in
+// the state uf when given, from the cache when it holds a fresh one.
+func (h *handler) lookupCity(ctx context.Context, name, uf string) (api.Temperature, error) {
+	key := foldName(name) + "/" + uf
+	cached, result := h.cities.Get(ctx, key)
+	trace.SpanFromContext(ctx).SetAttributes(attribute.String("cache.city", result))
+	if result == cacheHit {
+		cached.Cached = true
+		return cached, nil
+	}
+
+	query := name + ", Brazil"
+	if uf != "" {
+		query = name + ", " + states[uf] + ", Brazil"
+	}
+	weather, err := h.currentWeather(ctx, query)
+	if err != nil {
+		// WeatherAPI answers 400 when no location matches the query
+		var se *statusError
+		if errors.As(err, &se) && se.code == http.StatusBadRequest {
+			return api.Temperature{}, apperr.ErrCityNotFound.Wrap(err)
+		}
+		return api.Temperature{}, err
+	}
+	// the closest match may be a city elsewhere, which is not the one asked for
+	// (providers without states report no region, and are trusted on it)
+	region := weather.Location.Region
+	if weather.Location.Country != "Brazil" || (uf != "" && region != "" && foldName(region) != foldName(states[uf])) {
+		return api.Temperature{}, apperr.ErrCityNotFound
+	}
+
+	temperature := h.temperature(weather.Location.Name, weather)
+	h.cities.Set(ctx, key, temperature, h.degradation.CacheTTL(activeCacheTTLs.Load().city))
+	return temperature, nil
+}
+
+// foldName lowercases s and strips the diacritics of Portuguese, so that
+// "São Paulo" and "sao paulo" compare equal.
+func foldName(s string) string {
+	return nameFolder.Replace(strings.ToLower(s))
+}
+
+var nameFolder = strings.NewReplacer(
+	"á", "a", "à", "a", "â", "a", "ã", "a",
+	"é", "e", "ê", "e",
+	"í", "i",
+	"ó", "o", "ô", "o", "õ", "o",
+	"ú", "u", "ü", "u",
+	"ç", "c",
+)
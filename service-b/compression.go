@@ -0,0 +1,20 @@
+package main
+
+import (
+	"net/http"
+
+	"goexpert-lab-2-observabilidade/internal/middleware"
+)
+
+// compressMiddleware compresses JSON responses for callers that accept it;
+// service-a asks for gzip on the internal hop when INTERNAL_COMPRESSION is
+// enabled.
+func compressMiddleware(next http.Handler) http.Handler {
+	compressed := middleware.Compress("application/json", "application/problem+json")(next)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if middleware.AcceptedEncoding(r) != "" {
+			middleware.MarkFeature(r.Context(), usesCompression)
+		}
+		compressed.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"strings"
+
+	"goexpert-lab-2-observabilidade/internal/apperr"
+	"goexpert-lab-2-observabilidade/internal/httpclient"
+
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+)
+
+// requiredSettings must be set for the service to start, with what each is for.
+var requiredSettings = []struct {
+	key, purpose string
+}{
+	{"OTEL_SERVICE_NAME", "service name reported on traces, logs and metrics"},
+	{"OTEL_EXPORTER_OTLP_ENDPOINT", "host:port of the OTLP/HTTP collector, e.g. otel-collector:4318"},
+}
+
+// settingFlags are named flags for the settings most often tweaked in ad-hoc
+// runs and integration tests, bound to their keys.
+var settingFlags = []struct {
+	name, key, usage string
+}{
+	{"port", "PORT", "API listener port"},
+	{"admin-port", "ADMIN_PORT", "admin listener port"},
+	{"log-level", "LOG_LEVEL", "debug, info, warn or error"},
+	{"otlp-endpoint", "OTEL_EXPORTER_OTLP_ENDPOINT", "host:port of the OTLP/HTTP collector"},
+}
+
+// configSummaryKeys are the settings worth seeing at a glance while triaging.
+var configSummaryKeys = []string{
+	"OTEL_SERVICE_NAME",
+	"OTEL_EXPORTER_OTLP_ENDPOINT",
+	"PORT",
+	"ADMIN_PORT",
+	"LOG_LEVEL",
+	"ERROR_FORMAT",
+	"PROVIDER_SELECTION",
+	"CEP_LOOKUP_MODE",
+	"CACHE_BACKEND",
+	"WEATHER_HEDGE_AFTER",
+	"INTERNAL_COMPRESSION",
+	"PREWARM_ENABLED",
+	"ENABLE_PPROF",
+	"MAX_CITY_LABELS",
+}
+
+// loadConfig layers the settings on top of the defaults set in init, from
+// highest precedence: flags (settingFlags and --set), environment variables, the config file
+// (--config, or ./config.yaml when present), the dotenv file (--env-file,
+// default ./.env), then the defaults. Keys are the environment variable
+// names, case-insensitive.
+func loadConfig(args []string) error {
+	flags := pflag.NewFlagSet(args[0], pflag.ContinueOnError)
+	configFile := flags.String("config", "", "config file (default ./config.yaml when present)")
+	envFile := flags.String("env-file", ".env", "dotenv file, read when present")
+	overrides := flags.StringArray("set", nil, "override a setting as KEY=value; repeatable")
+	for _, f := range settingFlags {
+		flags.String(f.name, "", f.usage+" ("+f.key+")")
+	}
+	if err := flags.Parse(args[1:]); errors.Is(err, pflag.ErrHelp) {
+		os.Exit(0)
+	} else if err != nil {
+		return err
+	}
+	for _, f := range settingFlags {
+		if err := viper.BindPFlag(f.key, flags.Lookup(f.name)); err != nil {
+			return err
+		}
+	}
+
+	if err := mergeConfigFile(*envFile, flags.Changed("env-file")); err != nil {
+		return err
+	}
+	if *configFile != "" {
+		if err := mergeConfigFile(*configFile, true); err != nil {
+			return err
+		}
+	} else if err := mergeConfigFile("config.yaml", false); err != nil {
+		return err
+	}
+
+	for _, kv := range *overrides {
+		key, value, ok := strings.Cut(kv, "=")
+		if !ok || key == "" {
+			return fmt.Errorf("invalid --set %q, expected KEY=value", kv)
+		}
+		viper.Set(key, value)
+	}
+	return nil
+}
+
+// unsetSettings are the settings without a default; viper only knows keys
+// that have a default or come from a file or flag, so these are listed to
+// show up in /debug/config even when unset.
+var unsetSettings = []string{
+	"OTEL_SERVICE_NAME",
+	"OTEL_EXPORTER_OTLP_ENDPOINT",
+	"REQUEST_NAME_OTEL",
+	"WEATHER_API_KEY",
+	"WEATHER_API_KEY_FILE",
+	"LOKI_URL",
+	"LOKI_LABELS",
+	"PUSHGATEWAY_URL",
+}
+
+// configFiles are the config files loaded, in merge order.
+var configFiles []string
+
+// mergeConfigFile merges path into the config, its format taken from the
+// extension; a missing file is only an error when required.
+func mergeConfigFile(path string, required bool) error {
+	if path == "" {
+		return nil
+	}
+	if _, err := os.Stat(path); errors.Is(err, fs.ErrNotExist) && !required {
+		return nil
+	}
+	viper.SetConfigFile(path)
+	if err := viper.MergeInConfig(); err != nil {
+		return fmt.Errorf("failed to read config file %s: %w", path, err)
+	}
+	configFiles = append(configFiles, path)
+	return nil
+}
+
+// validateConfig checks the whole configuration at startup and reports every
+// problem at once, so a bad deploy fails fast with the full list to fix
+// instead of one setting per restart or at the first request.
+func validateConfig() error {
+	var errs []error
+	for _, s := range requiredSettings {
+		if strings.TrimSpace(viper.GetString(s.key)) == "" {
+			errs = append(errs, fmt.Errorf("%s is required: %s", s.key, s.purpose))
+		}
+	}
+	switch f := viper.GetString("ERROR_FORMAT"); f {
+	case apperr.FormatText, apperr.FormatProblem:
+	default:
+		errs = append(errs, fmt.Errorf("ERROR_FORMAT %q must be %q or %q", f, apperr.FormatText, apperr.FormatProblem))
+	}
+	if _, err := parseProviders("CEP_PROVIDERS", cepProviderNames); err != nil {
+		errs = append(errs, err)
+	}
+	switch m := viper.GetString("CEP_LOOKUP_MODE"); m {
+	case cepModeFailover, cepModeRace:
+	default:
+		errs = append(errs, fmt.Errorf("CEP_LOOKUP_MODE %q must be %q or %q", m, cepModeFailover, cepModeRace))
+	}
+	providers, err := parseProviders("WEATHER_PROVIDERS", weatherProviderNames)
+	if err != nil {
+		errs = append(errs, err)
+	}
+	if _, err := parseHedgePolicy(viper.GetString("WEATHER_HEDGE_AFTER")); err != nil {
+		errs = append(errs, err)
+	}
+	for _, p := range providers {
+		switch p {
+		case providerWeatherAPI:
+			if key, err := weatherAPIKey(); err != nil {
+				errs = append(errs, err)
+			} else if key == "" {
+				errs = append(errs, errors.New("WEATHER_API_KEY or WEATHER_API_KEY_FILE is required: the WeatherAPI key used for temperature lookups"))
+			}
+		case providerOpenWeatherMap:
+			if viper.GetString("OPENWEATHERMAP_API_KEY") == "" {
+				errs = append(errs, errors.New("OPENWEATHERMAP_API_KEY is required with openweathermap in WEATHER_PROVIDERS"))
+			}
+		}
+	}
+	for _, key := range []string{"WEATHER_STALE_TTL", "WEATHER_REVALIDATE_TIMEOUT", "DNS_CACHE_TTL"} {
+		if d := viper.GetDuration(key); d < 0 {
+			errs = append(errs, fmt.Errorf("%s (%s) must not be negative", key, d))
+		}
+	}
+	switch b := viper.GetString("CACHE_BACKEND"); b {
+	case cacheBackendMemory:
+	case cacheBackendRedis:
+		errs = append(errs, loadRedisConfig().Validate())
+	default:
+		errs = append(errs, fmt.Errorf("CACHE_BACKEND %q must be %q or %q", b, cacheBackendMemory, cacheBackendRedis))
+	}
+	for _, c := range []string{"CEP", "CEP_NOT_FOUND", "WEATHER", "FORECAST", "CITY", "AIRQUALITY", "ASTRONOMY"} {
+		if ttl := viper.GetDuration(c + "_CACHE_TTL"); ttl <= 0 {
+			errs = append(errs, fmt.Errorf("%s_CACHE_TTL (%s) must be positive", c, ttl))
+		}
+		if n := viper.GetInt(c + "_CACHE_SIZE"); n < 1 {
+			errs = append(errs, fmt.Errorf("%s_CACHE_SIZE (%d) must be at least 1", c, n))
+		}
+	}
+	for _, key := range []string{"OBSERVATION_INTERVAL", "OBSERVATION_RETENTION"} {
+		if d := viper.GetDuration(key); d <= 0 {
+			errs = append(errs, fmt.Errorf("%s (%s) must be positive", key, d))
+		}
+	}
+	if n := viper.GetInt("OBSERVATION_MAX_CITIES"); n < 1 {
+		errs = append(errs, fmt.Errorf("OBSERVATION_MAX_CITIES (%d) must be at least 1", n))
+	}
+	errs = append(errs, loadTimeouts().Validate())
+	errs = append(errs, loadRetryConfig().Validate())
+	errs = append(errs, loadBreakerConfig().Validate())
+	errs = append(errs, loadBulkheadConfig().Validate())
+	errs = append(errs, httpclient.LoadPool().Validate())
+	return errors.Join(errs...)
+}
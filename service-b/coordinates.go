@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"goexpert-lab-2-observabilidade/internal/api"
+	"goexpert-lab-2-observabilidade/internal/apperr"
+)
+
+// temperatureByCoordinatesHandler serves GET /v1/temperature?lat=..&lon=..,
+// in decimal degrees. There is no CEP to resolve, so WeatherAPI is queried
+// directly and the city is the one it places the coordinates in.
+func (h *handler) temperatureByCoordinatesHandler(w http.ResponseWriter, r *http.Request) {
+	h.serveLookup(w, r, func(ctx context.Context) (api.Temperature, error) {
+		lat, lon, err := parseCoordinates(r)
+		if err != nil {
+			return api.Temperature{}, err
+		}
+		trace.SpanFromContext(ctx).SetAttributes(attribute.Float64("geo.lat", lat), attribute.Float64("geo.lon", lon))
+
+		weather, err := h.currentWeather(ctx, strconv.FormatFloat(lat, 'f', -1, 64)+","+strconv.FormatFloat(lon, 'f', -1, 64))
+		if err != nil {
+			return api.Temperature{}, err
+		}
+		return h.temperature(weather.Location.Name, weather), nil
+	})
+}
+
+// parseCoordinates reads lat and lon, both required, reporting every invalid
+// parameter at once.
+func parseCoordinates(r *http.Request) (lat, lon float64, err error) {
+	q := r.URL.Query()
+	var fields []apperr.FieldError
+	if q.Has("zipcode") {
+		fields = append(fields, apperr.FieldError{Field: "zipcode", Message: "must not be sent with lat and lon"})
+	}
+	lat, ok := parseDegrees(q.Get("lat"), 90)
+	if !ok {
+		fields = append(fields, apperr.FieldError{Field: "lat", Message: "must be a number from -90 to 90"})
+	}
+	lon, ok = parseDegrees(q.Get("lon"), 180)
+	if !ok {
+		fields = append(fields, apperr.FieldError{Field: "lon", Message: "must be a number from -180 to 180"})
+	}
+	if len(fields) > 0 {
+		return 0, 0, apperr.ErrInvalidParam.WithFields(fields...)
+	}
+	return lat, lon, nil
+}
+
+// parseDegrees parses v as an angle within [-limit, limit].
+func parseDegrees(v string, limit float64) (float64, bool) {
+	d, err := strconv.ParseFloat(v, 64)
+	if err != nil || !api.Finite(d) || d < -limit || d > limit {
+		return 0, false
+	}
+	return d, true
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"goexpert-lab-2-observabilidade/internal/api"
+	"goexpert-lab-2-observabilidade/internal/apperr"
+)
+
+const (
+	defaultForecastDays = 3
+	// maxForecastDays is the longest forecast WeatherAPI serves.
+	maxForecastDays = 14
+)
+
+// ForecastInfo is the part of WeatherAPI's forecast.json response we use.
+type ForecastInfo struct {
+	Forecast struct {
+		ForecastDay []struct {
+			Date string `json:"date"`
+			Day  struct {
+				MaxTempC  float64 `json:"maxtemp_c"`
+				MinTempC  float64 `json:"mintemp_c"`
+				Condition struct {
+					Text string `json:"text"`
+				} `json:"condition"`
+			} `json:"day"`
+		} `json:"forecastday"`
+	} `json:"forecast"`
+}
+
+// forecastHandler serves GET /v1/forecast/{cep}?days=3, the daily forecast
+// for the city of the CEP. Forecasts are cached per CEP and number of days
+// for FORECAST_CACHE_TTL, and the endpoint is shed at the critical level.
+func (h *handler) forecastHandler(w http.ResponseWriter, r *http.Request) {
+	if !h.degradation.Allows(featureForecast) {
+		api.Error(w, r, apperr.ErrFeatureShed)
+		return
+	}
+
+	days := defaultForecastDays
+	if v := r.URL.Query().Get("days"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxForecastDays {
+			api.Error(w, r, apperr.ErrInvalidParam.WithFields(apperr.FieldError{
+				Field: "days", Message: "must be an integer from 1 to " + strconv.Itoa(maxForecastDays),
+			}))
+			return
+		}
+		days = n
+	}
+
+	zipCode := normalizeCEP(r.Context(), chi.URLParam(r, "cep"))
+	if len(zipCode) != 8 {
+		api.Error(w, r, apperr.ErrInvalidZip)
+		return
+	}
+
+	result, err := h.lookupForecast(r.Context(), zipCode, days)
+	if err != nil {
+		api.Error(w, r, err)
+		return
+	}
+	api.WriteJSON(w, r, http.StatusOK, result)
+}
+
+// lookupForecast returns the forecast for a valid CEP, from the cache when
+// it holds a fresh one.
+func (h *handler) lookupForecast(ctx context.Context, zipCode string, days int) (api.Forecast, error) {
+	key := zipCode + "/" + strconv.Itoa(days)
+	cached, result := h.forecasts.Get(ctx, key)
+	trace.SpanFromContext(ctx).SetAttributes(attribute.String("cache.forecast", result))
+	if result == cacheHit {
+		return cached, nil
+	}
+
+	city, err := h.locate(ctx, zipCode)
+	if err != nil {
+		return api.Forecast{}, err
+	}
+
+	start := time.Now()
+	info, err := h.getForecast(ctx, city, days)
+	h.observeWeatherProvider("weatherapi", time.Since(start), err)
+	if err != nil {
+		return api.Forecast{}, weatherError(err)
+	}
+
+	forecast := api.Forecast{City: city, Days: make([]api.ForecastDay, 0, len(info.Forecast.ForecastDay))}
+	for _, d := range info.Forecast.ForecastDay {
+		forecast.Days = append(forecast.Days, api.ForecastDay{
+			Date:      d.Date,
+			MinTempC:  d.Day.MinTempC,
+			MaxTempC:  d.Day.MaxTempC,
+			MinTempF:  d.Day.MinTempC*1.8 + 32,
+			MaxTempF:  d.Day.MaxTempC*1.8 + 32,
+			Condition: d.Day.Condition.Text,
+		})
+	}
+	h.forecasts.Set(ctx, key, forecast, h.degradation.CacheTTL(activeCacheTTLs.Load().forecast))
+	return forecast, nil
+}
+
+func (h *handler) getForecast(ctx context.Context, city string, days int) (ForecastInfo, error) {
+
+	ctx, span := h.tracer.Start(ctx, "Chamada externa: getForecast", trace.WithAttributes(attribute.Int("forecast.days", days)))
+	defer span.End()
+
+	ctx, cancel := withTimeout(ctx, activeTimeouts.Load().weatherAPI)
+	defer cancel()
+
+	completeUrl := fmt.Sprintf("%s/v1/forecast.json?q=%s&days=%d", weatherAPIBaseURL, url.QueryEscape(city), days)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, completeUrl, nil)
+	if err != nil {
+		return ForecastInfo{}, err
+	}
+	resp, err := h.client.Do(req)
+
+	if err != nil {
+		observeProviderError("weatherapi", err)
+		return ForecastInfo{}, err
+	}
+	defer resp.Body.Close()
+
+	if err := checkWeatherStatus(resp); err != nil {
+		observeProviderError("weatherapi", err)
+		return ForecastInfo{}, err
+	}
+
+	var forecast ForecastInfo
+	if err := json.NewDecoder(resp.Body).Decode(&forecast); err != nil {
+		return ForecastInfo{}, err
+	}
+
+	return forecast, nil
+}
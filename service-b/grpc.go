@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"runtime/debug"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc/filters"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	_ "google.golang.org/grpc/encoding/gzip" // for service-a with INTERNAL_COMPRESSION
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/reflection"
+	"google.golang.org/grpc/status"
+
+	"goexpert-lab-2-observabilidade/internal/apperr"
+	"goexpert-lab-2-observabilidade/internal/logging"
+	"goexpert-lab-2-observabilidade/internal/middleware"
+	"goexpert-lab-2-observabilidade/internal/temperaturepb"
+)
+
+// temperatureServer serves the gRPC API with the same lookup as the HTTP one.
+type temperatureServer struct {
+	temperaturepb.UnimplementedTemperatureServiceServer
+	h *handler
+}
+
+func (s *temperatureServer) GetTemperatureByZipcode(ctx context.Context, req *temperaturepb.GetTemperatureByZipcodeRequest) (*temperaturepb.GetTemperatureByZipcodeResponse, error) {
+	ctx, cancel := withTimeout(ctx, activeTimeouts.Load().handler)
+	defer cancel()
+
+	result, err := s.h.lookupPostalTemperature(ctx, req.GetCountry(), req.GetZipcode())
+	if err != nil {
+		return nil, err
+	}
+	return &temperaturepb.GetTemperatureByZipcodeResponse{
+		City:      result.City,
+		TempC:     result.TempC,
+		TempF:     result.TempF,
+		TempK:     result.TempK,
+		Condition: result.Condition,
+		Timezone:  result.Timezone,
+		LocalTime: result.LocalTime,
+
+		ObservedAt:  result.ObservedAt,
+		RetrievedAt: result.RetrievedAt,
+		Cached:      result.Cached,
+		Stale:       result.Stale,
+	}, nil
+}
+
+// newGRPCServer returns the gRPC API server, instrumented like the HTTP routes:
+// otelgrpc continues the caller's trace in a server span per call and records
+// the rpc.server.* metrics on reg, leaving out the health checks like /healthz.
+// It also serves grpc.health.v1, for load balancers, and reflection, so
+// grpcurl can explore it without the proto files; call health.Shutdown to
+// report NOT_SERVING while draining.
+func newGRPCServer(h *handler, reg prometheus.Registerer) (*grpc.Server, *health.Server, error) {
+	meterProvider, err := middleware.RPCMeterProvider(reg)
+	if err != nil {
+		return nil, nil, err
+	}
+	server := grpc.NewServer(
+		grpc.StatsHandler(otelgrpc.NewServerHandler(
+			otelgrpc.WithMeterProvider(meterProvider),
+			otelgrpc.WithFilter(filters.Not(filters.HealthCheck())),
+		)),
+		grpc.ChainUnaryInterceptor(
+			requestIDInterceptor,
+			errorInterceptor,
+			recoverInterceptor, // inside the others, so panics are answered and logged like errors
+		),
+	)
+	temperaturepb.RegisterTemperatureServiceServer(server, &temperatureServer{h: h})
+
+	healthServer := health.NewServer()
+	healthServer.SetServingStatus(temperaturepb.TemperatureService_ServiceDesc.ServiceName, healthpb.HealthCheckResponse_SERVING)
+	healthpb.RegisterHealthServer(server, healthServer)
+	reflection.Register(server)
+	return server, healthServer, nil
+}
+
+// listenAndServeGRPC is srv.Serve on addr, in the shape serve expects.
+func listenAndServeGRPC(srv *grpc.Server, addr string) func() error {
+	return func() error {
+		lis, err := net.Listen("tcp", addr)
+		if err != nil {
+			return err
+		}
+		if err := srv.Serve(lis); err != nil {
+			return err
+		}
+		return http.ErrServerClosed // Serve returns nil once the server was stopped
+	}
+}
+
+// drainGRPCServer is admin.DrainServer for the gRPC listener: it waits up to
+// timeout for in-flight calls, then closes the remaining connections.
+func drainGRPCServer(srv *grpc.Server, timeout time.Duration) {
+	done := make(chan struct{})
+	go func() {
+		srv.GracefulStop()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(timeout):
+		slog.Error("graceful shutdown timed out, closing connections", "listener", "grpc")
+		srv.Stop()
+	}
+}
+
+// requestIDInterceptor is middleware.RequestID for gRPC: the ID comes in the
+// x-request-id metadata and is sent back in the response header.
+func requestIDInterceptor(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	var id string
+	md, _ := metadata.FromIncomingContext(ctx)
+	if v := md.Get(middleware.RequestIDHeader); len(v) > 0 {
+		id = v[0]
+	}
+	if !middleware.ValidRequestID(id) {
+		id = middleware.NewRequestID()
+	}
+	grpc.SetHeader(ctx, metadata.Pairs(middleware.RequestIDHeader, id))
+	trace.SpanFromContext(ctx).SetAttributes(attribute.String("http.request_id", id))
+	return handler(middleware.WithRequestID(ctx, id), req)
+}
+
+// errorInterceptor is api.Error for gRPC: it counts and records the error,
+// logs it sampled per type and returns it with its type attached.
+func errorInterceptor(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	resp, err := handler(ctx, req)
+	if err == nil {
+		return resp, nil
+	}
+	var appErr *apperr.Error
+	if _, isStatus := status.FromError(err); isStatus && !errors.As(err, &appErr) {
+		return nil, err // already a gRPC status, e.g. from the health service
+	}
+	e := apperr.Record(ctx, err)
+	if ok, suppressed := logging.ErrorSampler.Allow(e.Type); ok {
+		slog.WarnContext(ctx, "request failed",
+			"method", info.FullMethod, "type", e.Type, "status", e.Status, "error", e.Error(), "suppressed", suppressed)
+	}
+	return nil, e
+}
+
+// recoverInterceptor is middleware.Recover for gRPC, which would otherwise
+// let a handler panic take the process down.
+func recoverInterceptor(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp any, err error) {
+	defer func() {
+		v := recover()
+		if v == nil {
+			return
+		}
+		middleware.PanicsRecovered.WithLabelValues(info.FullMethod).Inc()
+		slog.ErrorContext(ctx, "handler panicked",
+			"method", info.FullMethod, "panic", fmt.Sprint(v), "stack", string(debug.Stack()))
+		err = apperr.ErrInternal.Wrap(fmt.Errorf("panic: %v", v))
+	}()
+	return handler(ctx, req)
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"goexpert-lab-2-observabilidade/internal/middleware"
+)
+
+var weatherHedges = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "weather_hedges_total",
+	Help: "Weather lookups hedged with a second request, by the request that answered first (primary, hedge, none).",
+}, []string{"winner"})
+
+// hedgeP95 is the WEATHER_HEDGE_AFTER that hedges after the p95 latency seen
+// from the provider.
+const hedgeP95 = "p95"
+
+// hedgePolicy is WEATHER_HEDGE_AFTER: how long the first weather provider has
+// to answer before a second request is sent.
+type hedgePolicy struct {
+	adaptive bool          // after the p95 latency of the provider, once there is one
+	after    time.Duration // otherwise; 0 disables hedging
+}
+
+func parseHedgePolicy(v string) (hedgePolicy, error) {
+	if v == hedgeP95 {
+		return hedgePolicy{adaptive: true}, nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d < 0 {
+		return hedgePolicy{}, fmt.Errorf("WEATHER_HEDGE_AFTER %q must be %q or a duration that isn't negative", v, hedgeP95)
+	}
+	return hedgePolicy{after: d}, nil
+}
+
+func (p hedgePolicy) enabled() bool { return p.adaptive || p.after > 0 }
+
+// delay is how long provider has before the hedge, or 0 when there is no
+// latency estimate for it yet.
+func (p hedgePolicy) delay(s *providerSelector, provider string) time.Duration {
+	if p.adaptive {
+		return s.P95(provider)
+	}
+	return p.after
+}
+
+// hedgeWeather asks the first of the ordered providers for the weather and,
+// when it hasn't answered within the hedge delay, the next one too, or the
+// same one again when it is the only one. The first answer wins, no match
+// included, and the other request is cancelled. A primary failing before the
+// delay fails over to the next provider right away; when both fail, the error
+// of the primary is returned.
+func (h *handler) hedgeWeather(ctx context.Context, query string, order []string, delay time.Duration) (WeatherInfo, string, error) {
+	span := trace.SpanFromContext(ctx)
+	primary, hedge := order[0], order[0]
+	if len(order) > 1 {
+		hedge = order[1]
+	}
+
+	hedgeCtx, cancel := context.WithCancelCause(ctx)
+	defer cancel(errRaceLost)
+
+	type answer struct {
+		provider string
+		hedge    bool
+		weather  WeatherInfo
+		err      error
+	}
+	answers := make(chan answer, 2)
+	call := func(name string, isHedge bool) {
+		go func() {
+			start := time.Now()
+			weather, err := h.weatherProviders[name].GetCurrent(hedgeCtx, query)
+			// the request cancelled by the winner hasn't failed, so it isn't observed
+			if err == nil || context.Cause(hedgeCtx) != errRaceLost {
+				h.observeWeatherProvider(name, time.Since(start), err)
+			}
+			answers <- answer{name, isHedge, weather, err}
+		}()
+	}
+
+	call(primary, false)
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	// sent is whether the second request went out, hedged whether it did
+	// because the primary was slow rather than failed
+	sent, hedged, pending := false, false, 1
+	send := func() {
+		sent = true
+		pending++
+		call(hedge, true)
+	}
+
+	var primaryErr error
+	for pending > 0 {
+		select {
+		case <-timer.C:
+			if !sent {
+				hedged = true
+				middleware.MarkFeature(ctx, usesHedging)
+				span.AddEvent("weather hedge", trace.WithAttributes(
+					attribute.String("provider.from", primary),
+					attribute.String("provider.to", hedge),
+					attribute.Int64("hedge.after_ms", delay.Milliseconds()),
+				))
+				send()
+			}
+		case a := <-answers:
+			pending--
+			if a.err == nil || isNoMatch(a.err) {
+				if hedged {
+					winner := "primary"
+					if a.hedge {
+						winner = "hedge"
+					}
+					weatherHedges.WithLabelValues(winner).Inc()
+					span.SetAttributes(attribute.String("weather.hedge.winner", winner))
+				}
+				return a.weather, a.provider, a.err
+			}
+			if !a.hedge {
+				primaryErr = a.err
+			}
+			if !sent && hedge != primary && ctx.Err() == nil {
+				recordFailover(ctx, "weather", primary, hedge, a.err)
+				send()
+			}
+		}
+	}
+	if hedged {
+		weatherHedges.WithLabelValues("none").Inc()
+	}
+	return WeatherInfo{}, primary, primaryErr
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"goexpert-lab-2-observabilidade/internal/api"
+	"goexpert-lab-2-observabilidade/internal/apperr"
+)
+
+// Sources of the history responses.
+const (
+	historyWeatherAPI   = "weatherapi"
+	historyObservations = "observations"
+)
+
+var historyLookups = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "history_lookups_total",
+	Help: "History lookups served, by source (weatherapi or observations).",
+}, []string{"source"})
+
+// HistoryInfo is the part of WeatherAPI's history.json response we use.
+type HistoryInfo struct {
+	Forecast struct {
+		ForecastDay []struct {
+			Day struct {
+				MaxTempC  float64 `json:"maxtemp_c"`
+				MinTempC  float64 `json:"mintemp_c"`
+				AvgTempC  float64 `json:"avgtemp_c"`
+				Condition struct {
+					Text string `json:"text"`
+				} `json:"condition"`
+			} `json:"day"`
+			Hour []struct {
+				Time  string  `json:"time"` // "2024-07-01 13:00"
+				TempC float64 `json:"temp_c"`
+			} `json:"hour"`
+		} `json:"forecastday"`
+	} `json:"forecast"`
+}
+
+// historyHandler serves GET /v1/history/{cep}?date=YYYY-MM-DD, the weather of
+// a past day, or today so far, in the city of the CEP. It comes from
+// WeatherAPI's history when the plan covers the date, and otherwise from the
+// temperatures this instance served for the city that day, when it has any.
+func (h *handler) historyHandler(w http.ResponseWriter, r *http.Request) {
+	day, err := time.ParseInLocation(time.DateOnly, r.URL.Query().Get("date"), brasilia)
+	if err != nil {
+		api.Error(w, r, apperr.ErrInvalidParam.Wrap(err).WithFields(apperr.FieldError{Field: "date", Message: "must be a date like 2024-07-01"}))
+		return
+	}
+	if day.After(time.Now()) {
+		api.Error(w, r, apperr.ErrInvalidParam.WithFields(apperr.FieldError{Field: "date", Message: "must not be in the future"}))
+		return
+	}
+
+	zipCode := normalizeCEP(r.Context(), chi.URLParam(r, "cep"))
+	if len(zipCode) != 8 {
+		api.Error(w, r, apperr.ErrInvalidZip)
+		return
+	}
+
+	result, err := h.lookupHistory(r.Context(), zipCode, day)
+	if err != nil {
+		api.Error(w, r, err)
+		return
+	}
+	api.WriteJSON(w, r, http.StatusOK, result)
+}
+
+// lookupHistory returns the weather of a valid CEP on day.
+func (h *handler) lookupHistory(ctx context.Context, zipCode string, day time.Time) (api.History, error) {
+	city, err := h.locate(ctx, zipCode)
+	if err != nil {
+		return api.History{}, err
+	}
+	date := day.Format(time.DateOnly)
+
+	start := time.Now()
+	info, err := h.getHistory(ctx, city, date)
+	h.observeWeatherProvider("weatherapi", time.Since(start), err)
+	if err == nil && len(info.Forecast.ForecastDay) > 0 {
+		historyLookups.WithLabelValues(historyWeatherAPI).Inc()
+		trace.SpanFromContext(ctx).SetAttributes(attribute.String("history.source", historyWeatherAPI))
+		return historyFromWeatherAPI(city, date, info), nil
+	}
+
+	obs := h.observations.On(city, day, brasilia)
+	if len(obs) == 0 {
+		if err == nil {
+			return api.History{}, apperr.ErrHistoryNotFound
+		}
+		e := weatherError(err)
+		if errors.Is(e, apperr.ErrWeatherQuota) || errors.Is(e, apperr.ErrWeatherUnauthorized) {
+			return api.History{}, e
+		}
+		// WeatherAPI refuses dates outside of what the plan covers with a 400 or 403
+		var se *statusError
+		if errors.As(err, &se) && (se.code == http.StatusBadRequest || se.code == http.StatusForbidden) {
+			return api.History{}, apperr.ErrHistoryNotFound.Wrap(err)
+		}
+		return api.History{}, e
+	}
+	if err != nil {
+		slog.DebugContext(ctx, "serving history from stored observations", "city", city, "date", date, "error", err)
+	}
+	historyLookups.WithLabelValues(historyObservations).Inc()
+	trace.SpanFromContext(ctx).SetAttributes(attribute.String("history.source", historyObservations))
+	return historyFromObservations(city, date, obs), nil
+}
+
+func historyFromWeatherAPI(city, date string, info HistoryInfo) api.History {
+	fd := info.Forecast.ForecastDay[0]
+	history := api.History{
+		City:      city,
+		Date:      date,
+		MinTempC:  fd.Day.MinTempC,
+		MaxTempC:  fd.Day.MaxTempC,
+		AvgTempC:  fd.Day.AvgTempC,
+		Condition: fd.Day.Condition.Text,
+		Source:    historyWeatherAPI,
+		Hours:     make([]api.HistoryHour, 0, len(fd.Hour)),
+	}
+	for _, hour := range fd.Hour {
+		_, clock, _ := strings.Cut(hour.Time, " ")
+		history.Hours = append(history.Hours, api.HistoryHour{Time: clock, TempC: hour.TempC})
+	}
+	return history
+}
+
+func historyFromObservations(city, date string, obs []observation) api.History {
+	history := api.History{
+		City:     city,
+		Date:     date,
+		MinTempC: obs[0].tempC,
+		MaxTempC: obs[0].tempC,
+		Source:   historyObservations,
+		Hours:    make([]api.HistoryHour, 0, len(obs)),
+	}
+	var total float64
+	for _, o := range obs {
+		history.MinTempC = min(history.MinTempC, o.tempC)
+		history.MaxTempC = max(history.MaxTempC, o.tempC)
+		total += o.tempC
+		history.Hours = append(history.Hours, api.HistoryHour{Time: o.at.In(brasilia).Format("15:04"), TempC: o.tempC})
+	}
+	history.AvgTempC = total / float64(len(obs))
+	return history
+}
+
+func (h *handler) getHistory(ctx context.Context, city, date string) (HistoryInfo, error) {
+
+	ctx, span := h.tracer.Start(ctx, "Chamada externa: getHistory", trace.WithAttributes(attribute.String("history.date", date)))
+	defer span.End()
+
+	ctx, cancel := withTimeout(ctx, activeTimeouts.Load().weatherAPI)
+	defer cancel()
+
+	completeUrl := fmt.Sprintf("%s/v1/history.json?q=%s&dt=%s", weatherAPIBaseURL, url.QueryEscape(city), date)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, completeUrl, nil)
+	if err != nil {
+		return HistoryInfo{}, err
+	}
+	resp, err := h.client.Do(req)
+
+	if err != nil {
+		observeProviderError("weatherapi", err)
+		return HistoryInfo{}, err
+	}
+	defer resp.Body.Close()
+
+	if err := checkWeatherStatus(resp); err != nil {
+		observeProviderError("weatherapi", err)
+		return HistoryInfo{}, err
+	}
+
+	var info HistoryInfo
+	if err := json.NewDecoder(resp.Body).Decode(&info); err != nil {
+		return HistoryInfo{}, err
+	}
+
+	return info, nil
+}
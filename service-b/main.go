@@ -2,27 +2,39 @@
 package main
 
 import (
+	"cmp"
 	"context"
-	"crypto/tls"
-	"encoding/json"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
-	"net/url"
 	"os"
 	"os/signal"
+	"strings"
+	"syscall"
 	"time"
 
-	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/go-chi/chi/v5"
 	"github.com/spf13/viper"
 	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
 	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
 	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
 	"go.opentelemetry.io/otel/propagation"
 	"go.opentelemetry.io/otel/sdk/resource"
 	sdktrace "go.opentelemetry.io/otel/sdk/trace"
 	semconv "go.opentelemetry.io/otel/semconv/v1.21.0"
 	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+
+	"goexpert-lab-2-observabilidade/internal/admin"
+	"goexpert-lab-2-observabilidade/internal/api"
+	"goexpert-lab-2-observabilidade/internal/apperr"
+	"goexpert-lab-2-observabilidade/internal/config"
+	"goexpert-lab-2-observabilidade/internal/degradation"
+	"goexpert-lab-2-observabilidade/internal/httpclient"
+	"goexpert-lab-2-observabilidade/internal/logging"
+	"goexpert-lab-2-observabilidade/internal/middleware"
 )
 
 type TemperatureResponse struct {
@@ -78,160 +90,647 @@ func initProvider(serviceName, collectorURL string) (func(context.Context) error
 // load env vars cfg
 func init() {
 	viper.AutomaticEnv()
+	viper.SetDefault("PORT", "8081")
+	viper.SetDefault("GRPC_PORT", "50051")
+	viper.SetDefault("ADMIN_PORT", "9090")
+	viper.SetDefault("LOG_LEVEL", "info")
+	viper.SetDefault("LOG_FORMAT", "json")
+	viper.SetDefault("ERROR_FORMAT", "text")
+	viper.SetDefault("ACCESS_LOG_EXCLUDE", "/metrics,/healthz,/readyz")
+	viper.SetDefault("LOG_SAMPLE_INTERVAL", "1s")
+	viper.SetDefault("LOG_SAMPLE_BURST", 10)
+	viper.SetDefault("LOG_SAMPLE_EVERY", 100)
+	viper.SetDefault("DEGRADED_LATENCY", "1s")
+	viper.SetDefault("CRITICAL_LATENCY", "3s")
+	viper.SetDefault("DEGRADED_ERROR_RATIO", 0.2)
+	viper.SetDefault("CRITICAL_ERROR_RATIO", 0.5)
+	viper.SetDefault("MAX_CITY_LABELS", 100)
+	viper.SetDefault("PREWARM_ENABLED", true)
+	viper.SetDefault("PREWARM_IDLE", "60s")
+	viper.SetDefault("PROVIDER_SELECTION", "priority")
+	viper.SetDefault("WEATHER_PROVIDERS", providerWeatherAPI)
+	viper.SetDefault("CEP_PROVIDERS", providerViaCEP)
+	viper.SetDefault("CEP_LOOKUP_MODE", cepModeFailover)
+	viper.SetDefault("WEATHER_HEDGE_AFTER", "0s")
+	viper.SetDefault("CACHE_BACKEND", cacheBackendMemory)
+	viper.SetDefault("REDIS_DB", 0)
+	viper.SetDefault("REDIS_TLS", false)
+	viper.SetDefault("REDIS_KEY_PREFIX", "service-b:")
+	viper.SetDefault("REDIS_TIMEOUT", "100ms")
+	viper.SetDefault("REDIS_POOL_SIZE", 10)
+	viper.SetDefault("CEP_CACHE_TTL", "24h")
+	viper.SetDefault("CEP_CACHE_SIZE", 10000)
+	viper.SetDefault("CEP_NOT_FOUND_CACHE_TTL", "168h")
+	viper.SetDefault("CEP_NOT_FOUND_CACHE_SIZE", 10000)
+	viper.SetDefault("WEATHER_CACHE_TTL", "60s")
+	viper.SetDefault("WEATHER_CACHE_SIZE", 1000)
+	viper.SetDefault("WEATHER_STALE_TTL", "10m")
+	viper.SetDefault("WEATHER_REVALIDATE_TIMEOUT", "300ms")
+	viper.SetDefault("FORECAST_CACHE_TTL", "30m")
+	viper.SetDefault("FORECAST_CACHE_SIZE", 1000)
+	viper.SetDefault("CITY_CACHE_TTL", "5m")
+	viper.SetDefault("CITY_CACHE_SIZE", 1000)
+	viper.SetDefault("AIRQUALITY_CACHE_TTL", "15m")
+	viper.SetDefault("AIRQUALITY_CACHE_SIZE", 1000)
+	viper.SetDefault("ASTRONOMY_CACHE_TTL", "24h")
+	viper.SetDefault("ASTRONOMY_CACHE_SIZE", 1000)
+	viper.SetDefault("OBSERVATION_INTERVAL", "10m")
+	viper.SetDefault("OBSERVATION_RETENTION", "168h")
+	viper.SetDefault("OBSERVATION_MAX_CITIES", 1000)
+	viper.SetDefault("INTERNAL_COMPRESSION", false)
+	viper.SetDefault("INTERNAL_HTTP2", false)
+	viper.SetDefault("SHUTDOWN_DRAIN_DELAY", "0s")
+	viper.SetDefault("SHUTDOWN_TIMEOUT", "10s")
+	viper.SetDefault("READINESS_CACHE_TTL", "10s")
+	viper.SetDefault("READINESS_TIMEOUT", "2s")
+	viper.SetDefault("ENABLE_PPROF", false)
+	viper.SetDefault("ENABLE_DOCS", false)
+	viper.SetDefault("REQUEST_MAX_BYTES", 16<<10)
+	viper.SetDefault("READ_HEADER_TIMEOUT", "5s")
+	viper.SetDefault("READ_TIMEOUT", "10s")
+	viper.SetDefault("IDLE_TIMEOUT", "120s")
+	viper.SetDefault("HANDLER_TIMEOUT", "5s")
+	viper.SetDefault("VIACEP_TIMEOUT", "2s")
+	viper.SetDefault("WEATHERAPI_TIMEOUT", "2s")
+	viper.SetDefault("UPSTREAM_RETRY_MAX_ATTEMPTS", 3)
+	viper.SetDefault("UPSTREAM_RETRY_BACKOFF", "100ms")
+	viper.SetDefault("UPSTREAM_RETRY_MAX_BACKOFF", "1s")
+	viper.SetDefault("UPSTREAM_RETRY_STATUS_CODES", "408,429,500,502,503,504")
+	viper.SetDefault("CIRCUIT_BREAKER_FAILURES", 5)
+	viper.SetDefault("CIRCUIT_BREAKER_OPEN_TIMEOUT", "30s")
+	viper.SetDefault("UPSTREAM_MAX_CONCURRENT", 32)
+	viper.SetDefault("UPSTREAM_QUEUE_TIMEOUT", "100ms")
+	viper.SetDefault("DIAL_TIMEOUT", "500ms")
+	viper.SetDefault("HTTP_MAX_IDLE_CONNS", 100)
+	viper.SetDefault("HTTP_MAX_IDLE_CONNS_PER_HOST", 10)
+	viper.SetDefault("HTTP_MAX_CONNS_PER_HOST", 0)
+	viper.SetDefault("HTTP_IDLE_CONN_TIMEOUT", "90s")
+	viper.SetDefault("DNS_CACHE_TTL", "60s")
+	viper.SetDefault("TLS_HANDSHAKE_TIMEOUT", "1s")
 }
 
 type handler struct {
-	tracer trace.Tracer
+	tracer      trace.Tracer
+	degradation *degradation.Tracker
+	cityLabels  *labelGuard
+	client      *http.Client
+	requestName string // REQUEST_NAME_OTEL
+
+	cepSelector     *providerSelector
+	weatherSelector *providerSelector
+
+	cepNames         []string // CEP_PROVIDERS, in priority order
+	cepProviders     map[string]CEPProvider
+	cepRace          bool     // CEP_LOOKUP_MODE=race
+	weatherNames     []string // WEATHER_PROVIDERS, in priority order
+	weatherProviders map[string]WeatherProvider
+	weatherHedge     hedgePolicy // WEATHER_HEDGE_AFTER
+
+	// the TTLs of the caches are in activeCacheTTLs
+	ceps         cache[LocationInfo]
+	cepsNotFound cache[struct{}] // the CEPs no provider knows
+	weather      cache[cachedWeather]
+	weatherSWR   staleWhileRevalidate
+	forecasts    cache[api.Forecast]
+	cities       cache[api.Temperature]
+
+	airQuality cache[api.AirQuality]
+	astronomy  cache[api.Astronomy]
+
+	observations *observationStore
 }
 
 func main() {
 
-	sigCh := make(chan os.Signal, 1)
-	signal.Notify(sigCh, os.Interrupt)
-
-	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
 	defer cancel()
 
+	if err := loadConfig(os.Args); err != nil {
+		logging.Fatal("failed to load configuration", err)
+	}
+
+	logger, closeLogs := logging.NewLogger(viper.GetString("OTEL_SERVICE_NAME"))
+	defer closeLogs()
+	slog.SetDefault(logger)
+	logging.ErrorSampler = logging.NewSampler(viper.GetDuration("LOG_SAMPLE_INTERVAL"), viper.GetInt("LOG_SAMPLE_BURST"), viper.GetInt("LOG_SAMPLE_EVERY"))
+
+	if err := validateConfig(); err != nil {
+		logging.Fatal("invalid configuration", err)
+	}
+	if err := apperr.SetFormat(viper.GetString("ERROR_FORMAT")); err != nil {
+		logging.Fatal("invalid ERROR_FORMAT", err)
+	}
+	timeouts := loadTimeouts()
+	activeTimeouts.Store(&timeouts)
+	ttls := loadCacheTTLs()
+	activeCacheTTLs.Store(&ttls)
+
 	shutdown, err := initProvider(viper.GetString("OTEL_SERVICE_NAME"), viper.GetString("OTEL_EXPORTER_OTLP_ENDPOINT"))
 	if err != nil {
-		log.Fatal(err)
+		logging.Fatal("failed to initialize TracerProvider", err)
 	}
 	defer func() {
-		if err := shutdown(ctx); err != nil {
-			log.Fatal("failed to shutdown TracerProvider: %w", err)
+		//flush the spans of the drained requests; ctx is already canceled here
+		flushCtx, flushCancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer flushCancel()
+		if err := shutdown(flushCtx); err != nil {
+			slog.Error("failed to shutdown TracerProvider", "error", err)
 		}
 	}()
 
 	tracer := otel.Tracer("service-b")
 
+	apiKey, err := weatherAPIKey()
+	if err != nil {
+		logging.Fatal("failed to load the WeatherAPI key", err)
+	}
+
+	cepNames, err := parseProviders("CEP_PROVIDERS", cepProviderNames)
+	if err != nil {
+		logging.Fatal("invalid configuration", err)
+	}
+	weatherNames, err := parseProviders("WEATHER_PROVIDERS", weatherProviderNames)
+	if err != nil {
+		logging.Fatal("invalid configuration", err)
+	}
+
+	var transport http.RoundTripper = newAPIKeyTransport(newUpstreamTransport("upstream", timeouts), weatherAPIBaseURL, "key", apiKey)
+	transport = newAPIKeyTransport(transport, openWeatherMapBaseURL, "appid", viper.GetString("OPENWEATHERMAP_API_KEY"))
+	//a client span per attempt, above the keys so they stay out of its URL
+	retrying := retryTransport{base: otelhttp.NewTransport(transport), tracer: tracer, cfg: loadRetryConfig()}
+	breakers := newBreakerTransport(retrying, loadBreakerConfig())
+	bulkheads := newBulkheadTransport(breakers, loadBulkheadConfig())
+	upstreamTargets := map[string]string{
+		"viacep":     viaCEPBaseURL,
+		"weatherapi": weatherAPIBaseURL,
+	}
+	upstream := httpclient.NewPrewarmer(bulkheads, tracer, viper.GetDuration("PREWARM_IDLE"), upstreamTargets)
+	cepProviders := newCEPProviders(cepNames, upstream.Client, tracer)
+	for name, p := range cepProviders {
+		upstreamTargets[name] = p.BaseURL()
+	}
+	weatherProviders := newWeatherProviders(weatherNames, upstream.Client, tracer)
+	for name, p := range weatherProviders {
+		upstreamTargets[name] = p.BaseURL()
+	}
+	if viper.GetBool("PREWARM_ENABLED") {
+		go upstream.Run(ctx)
+	}
+
+	policy, err := parseSelectionPolicy(viper.GetString("PROVIDER_SELECTION"))
+	if err != nil {
+		logging.Fatal("invalid configuration", err)
+	}
+	hedge, err := parseHedgePolicy(viper.GetString("WEATHER_HEDGE_AFTER"))
+	if err != nil {
+		logging.Fatal("invalid configuration", err)
+	}
+
+	var redis *redisClient
+	if viper.GetString("CACHE_BACKEND") == cacheBackendRedis {
+		if redis, err = newRedisClient(loadRedisConfig()); err != nil {
+			logging.Fatal("failed to instrument the redis client", err)
+		}
+		defer redis.Close()
+		pingCtx, cancel := context.WithTimeout(ctx, time.Second)
+		if err := redis.Ping(pingCtx).Err(); err != nil {
+			slog.Warn("redis is unreachable, caches will miss until it answers", "error", err)
+		}
+		cancel()
+	}
+
 	h := &handler{
-		tracer: tracer,
+		tracer:      tracer,
+		degradation: degradation.New(shedAt),
+		cityLabels:  newLabelGuard(viper.GetInt("MAX_CITY_LABELS")),
+		client:      upstream.Client,
+		requestName: viper.GetString("REQUEST_NAME_OTEL"),
+
+		cepSelector:     newProviderSelector(policy),
+		weatherSelector: newProviderSelector(policy),
+
+		cepNames:         cepNames,
+		cepProviders:     cepProviders,
+		cepRace:          viper.GetString("CEP_LOOKUP_MODE") == cepModeRace,
+		weatherNames:     weatherNames,
+		weatherProviders: weatherProviders,
+		weatherHedge:     hedge,
+
+		ceps:         newCache[LocationInfo]("cep", viper.GetInt("CEP_CACHE_SIZE"), redis),
+		cepsNotFound: newCache[struct{}]("cep_not_found", viper.GetInt("CEP_NOT_FOUND_CACHE_SIZE"), redis),
+		weather:      newCache[cachedWeather]("weather", viper.GetInt("WEATHER_CACHE_SIZE"), redis),
+		weatherSWR: staleWhileRevalidate{
+			wait:     viper.GetDuration("WEATHER_REVALIDATE_TIMEOUT"),
+			inFlight: make(map[string]*weatherRefresh),
+		},
+		forecasts: newCache[api.Forecast]("forecast", viper.GetInt("FORECAST_CACHE_SIZE"), redis),
+		cities:    newCache[api.Temperature]("city", viper.GetInt("CITY_CACHE_SIZE"), redis),
+
+		airQuality: newCache[api.AirQuality]("airquality", viper.GetInt("AIRQUALITY_CACHE_SIZE"), redis),
+		astronomy:  newCache[api.Astronomy]("astronomy", viper.GetInt("ASTRONOMY_CACHE_SIZE"), redis),
+
+		observations: newObservationStore(viper.GetDuration("OBSERVATION_INTERVAL"), viper.GetDuration("OBSERVATION_RETENTION"), viper.GetInt("OBSERVATION_MAX_CITIES")),
 	}
 
-	http.Handle("/metrics", promhttp.Handler())
-	http.Handle("/zipcode", otelhttp.NewHandler(http.HandlerFunc(h.temperatureHandler), "TemperatureHandler"))
-	log.Fatal(http.ListenAndServe(":8081", nil))
+	//operational endpoints live on a separate listener
+	requestStats := admin.NewStats()
+
+	diag := admin.NewDiagnostics(configSummaryKeys...)
+	diag.Register("dependencies", func() any { return h.degradation.Snapshot() })
+	diag.Register("stats", func() any { return requestStats.Snapshot() })
+	reg := newRegistry()
+	if pushURL := viper.GetString("PUSHGATEWAY_URL"); pushURL != "" {
+		job := viper.GetString("OTEL_SERVICE_NAME")
+		defer func() {
+			if err := pushMetrics(reg, pushURL, job); err != nil {
+				slog.Error("failed to push metrics", "error", err)
+			}
+		}()
+	}
 
-	select {
-	case <-sigCh:
-		log.Println("Shutting down gracefully, CTRL+C pressed...")
-	case <-ctx.Done():
-		log.Println("Shutting down due to other reason...")
+	drain := admin.NewDrainer(viper.GetDuration("SHUTDOWN_TIMEOUT"))
+	excluded := logging.AccessLogExclusions(viper.GetString("ACCESS_LOG_EXCLUDE"))
+
+	//each middleware wraps the ones listed after it
+	router := chi.NewRouter()
+	router.Use(
+		drain.Middleware,
+		middleware.RequestID,
+		logging.AccessLog(excluded),
+		middleware.RequestMetrics,
+		requestStats.Middleware,
+		h.degradation.Middleware,
+		timeoutMiddleware,
+		bodyLimitMiddleware(viper.GetInt64("REQUEST_MAX_BYTES")),
+	)
+	temperature := router.With(
+		otelhttp.NewMiddleware("TemperatureHandler"),
+		middleware.Recover(api.Error), // inside tracing, so panics land on the request span
+		logging.CaptureTraceID,
+		middleware.TagRequestID,
+		middleware.Features(featureNames...),
+	)
+	if viper.GetBool("INTERNAL_COMPRESSION") {
+		temperature = temperature.With(compressMiddleware)
+	}
+	temperature.Get("/v1/temperature", h.temperatureHandler)
+	temperature.Post("/v1/temperature", h.temperatureByBodyHandler)
+	temperature.Get("/v1/temperature/city/{name}", h.temperatureByCityHandler)
+	temperature.Get("/v1/forecast/{cep}", h.forecastHandler)
+	temperature.Get("/v1/airquality/{cep}", h.airQualityHandler)
+	temperature.Get("/v1/astronomy/{cep}", h.astronomyHandler)
+	temperature.Get("/v1/history/{cep}", h.historyHandler)
+	temperature.With(middleware.Deprecated("/zipcode", "/v1/temperature")).Get("/zipcode", h.temperatureHandler)
+	temperature.With(middleware.Deprecated("/zipcode", "/v1/temperature")).Post("/zipcode", h.temperatureByBodyHandler)
+	router.MethodNotAllowed(middleware.MethodNotAllowed(router, api.Error))
+	router.Get("/openapi.json", openAPIHandler())
+	if viper.GetBool("ENABLE_DOCS") {
+		router.Get("/docs", api.Docs("service-b"))
 	}
 
-	// Create a timeout context for the graceful shutdown
-	_, shutdownCancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer shutdownCancel()
+	//readiness checks use their own untraced client, apart from the request path
+	ready := admin.NewReadiness(&http.Client{Transport: newUpstreamTransport("readiness", timeouts)}, upstreamTargets, viper.GetDuration("READINESS_CACHE_TTL"), viper.GetDuration("READINESS_TIMEOUT"), drain)
+
+	adminRouter := chi.NewRouter()
+	adminRouter.Use(logging.AccessLog(excluded))
+	adminRouter.Get("/healthz", admin.Liveness(admin.StartTime))
+	adminRouter.Method(http.MethodGet, "/readyz", ready)
+	adminRouter.Method(http.MethodGet, "/drain", drain)
+	adminRouter.Method(http.MethodPost, "/drain", drain)
+	adminRouter.Method(http.MethodGet, "/metrics", metricsHandler(reg))
+	adminRouter.Method(http.MethodGet, "/stats", requestStats)
+	adminRouter.Method(http.MethodGet, "/admin/diagnostics", diag)
+	adminRouter.Get("/loglevel", logging.LevelHandler(api.Error))
+	adminRouter.Put("/loglevel", logging.LevelHandler(api.Error))
+	adminRouter.Get("/debug/config", config.Handler(unsetSettings))
+	if viper.GetBool("ENABLE_PPROF") {
+		admin.RegisterPprof(adminRouter)
+	}
+
+	adminServer := newServer(":"+viper.GetString("ADMIN_PORT"), adminRouter)
+	go admin.Serve("admin", adminServer.ListenAndServe)
+
+	var api http.Handler = router
+	if viper.GetBool("INTERNAL_HTTP2") {
+		//cleartext HTTP/2 for service-a; HTTP/1.1 clients keep working
+		api = h2c.NewHandler(router, &http2.Server{})
+	}
+	server := newServer(":"+viper.GetString("PORT"), api)
+	go admin.Serve("api", server.ListenAndServe)
+
+	grpcServer, grpcHealth, err := newGRPCServer(h, reg)
+	if err != nil {
+		logging.Fatal("failed to configure the gRPC server", err)
+	}
+	go admin.Serve("grpc", listenAndServeGRPC(grpcServer, ":"+viper.GetString("GRPC_PORT")))
+
+	//settings that can be changed by editing the config file
+	config.OnChange(func() { logging.Level.Set(logging.ParseLevel(viper.GetString("LOG_LEVEL"))) }, "LOG_LEVEL")
+	config.OnChange(func() {
+		logging.ErrorSampler.Configure(viper.GetDuration("LOG_SAMPLE_INTERVAL"), viper.GetInt("LOG_SAMPLE_BURST"), viper.GetInt("LOG_SAMPLE_EVERY"))
+	}, "LOG_SAMPLE_INTERVAL", "LOG_SAMPLE_BURST", "LOG_SAMPLE_EVERY")
+	config.OnChange(func() { h.degradation.Reconfigure(degradation.LoadConfig()) },
+		"DEGRADED_LATENCY", "CRITICAL_LATENCY", "DEGRADED_ERROR_RATIO", "CRITICAL_ERROR_RATIO")
+	config.OnChange(reloadTimeouts, reloadableTimeouts...)
+	config.OnChange(reloadCacheTTLs, reloadableCacheTTLs...)
+
+	//read after Watch only through config.Read or a reload callback
+	drainDelay, shutdownTimeout := viper.GetDuration("SHUTDOWN_DRAIN_DELAY"), viper.GetDuration("SHUTDOWN_TIMEOUT")
+	config.Watch(configFiles)
+
+	<-ctx.Done()
+	slog.Info("Shutting down gracefully...")
+	drain.Start()
+	grpcHealth.Shutdown()
+
+	//drain the API first; the admin listener keeps serving metrics meanwhile
+	admin.DrainServer(server, drainDelay, shutdownTimeout)
+	drainGRPCServer(grpcServer, shutdownTimeout)
+	admin.DrainServer(adminServer, 0, shutdownTimeout)
 }
 
+// temperatureHandler serves GET /v1/temperature, with the CEP in the zipcode
+// query parameter, or another country's postal code with its country, or
+// coordinates in lat and lon.
 func (h *handler) temperatureHandler(w http.ResponseWriter, r *http.Request) {
+	if q := r.URL.Query(); q.Has("lat") || q.Has("lon") {
+		h.temperatureByCoordinatesHandler(w, r)
+		return
+	}
+	h.serveTemperature(w, r, func() (api.TemperatureRequest, error) {
+		q := r.URL.Query()
+		return api.TemperatureRequest{CEP: q.Get("zipcode"), Country: q.Get("country")}, nil
+	})
+}
+
+// temperatureByBodyHandler serves POST /v1/temperature, with the CEP in a
+// JSON body like service-a's.
+func (h *handler) temperatureByBodyHandler(w http.ResponseWriter, r *http.Request) {
+	h.serveTemperature(w, r, func() (api.TemperatureRequest, error) {
+		var req api.TemperatureRequest
+		err := api.DecodeJSON(r, &req)
+		return req, err
+	})
+}
+
+// serveTemperature answers with the temperature for the postal code returned
+// by readRequest.
+func (h *handler) serveTemperature(w http.ResponseWriter, r *http.Request, readRequest func() (api.TemperatureRequest, error)) {
+	h.serveLookup(w, r, func(ctx context.Context) (api.Temperature, error) {
+		req, err := readRequest()
+		if err != nil {
+			return api.Temperature{}, err
+		}
+		return h.lookupPostalTemperature(ctx, req.Country, req.CEP)
+	})
+}
+
+// lookupPostalTemperature validates a postal code of country, a CEP when
+// country is empty or BR, and fetches the current temperature of its city,
+// for both the HTTP and the gRPC API.
+func (h *handler) lookupPostalTemperature(ctx context.Context, country, code string) (api.Temperature, error) {
+	country = api.NormalizeCountry(country)
+	code = normalizeCEP(ctx, code)
+	if err := api.ValidatePostalCode(country, code); err != nil {
+		return api.Temperature{}, err
+	}
+	if country != api.DefaultCountry {
+		return h.lookupForeignTemperature(ctx, country, code)
+	}
+	return h.lookupTemperature(ctx, code)
+}
+
+// serveLookup answers with the temperature returned by lookup, however the
+// location was given. Its details are left out unless ?detail=full, and the
+// address of CEPs unless ?include=address.
+func (h *handler) serveLookup(w http.ResponseWriter, r *http.Request, lookup func(ctx context.Context) (api.Temperature, error)) {
+	var full, address bool
+	switch v := r.URL.Query().Get("detail"); v {
+	case "", "basic":
+	case "full":
+		full = true
+	default:
+		api.Error(w, r, apperr.ErrInvalidParam.WithFields(apperr.FieldError{Field: "detail", Message: "must be basic or full"}))
+		return
+	}
+	if v := r.URL.Query().Get("include"); v != "" {
+		for _, part := range strings.Split(v, ",") {
+			if strings.TrimSpace(part) != "address" {
+				api.Error(w, r, apperr.ErrInvalidParam.WithFields(apperr.FieldError{Field: "include", Message: "must be address"}))
+				return
+			}
+		}
+		address = true
+	}
 
 	carrier := propagation.HeaderCarrier(r.Header)
 	ctx := r.Context()
 	ctx = otel.GetTextMapPropagator().Extract(ctx, carrier)
 
-	ctx, spanInicial := h.tracer.Start(ctx, "SPAN_INICIAL "+viper.GetString("REQUEST_NAME_OTEL"))
+	ctx, spanInicial := h.tracer.Start(ctx, "SPAN_INICIAL "+h.requestName)
 	spanInicial.End()
 
 	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(r.Header))
 
-	zipCode := r.URL.Query().Get("zipcode")
-	if len(zipCode) != 8 {
-		http.Error(w, "invalid zipcode", http.StatusPreconditionFailed)
-		return
+	if h.degradation.Level() != degradation.Normal {
+		middleware.MarkFeature(ctx, usesDegradedMode)
 	}
 
-	city, err := h.getLocation(ctx, zipCode)
-	if err != nil || city == "" {
-		http.Error(w, "can not find zipcode", http.StatusNotFound)
+	result, err := lookup(ctx)
+	if err != nil {
+		api.Error(w, r, err)
 		return
 	}
+	if !full {
+		result.Details = nil
+	}
+	if !address {
+		result.Address = nil
+	}
+	w.Header().Set(api.CacheHeader, result.CacheStatus())
+	if age := result.Age(); age != "" {
+		w.Header().Set(api.AgeHeader, age)
+	}
+	api.WriteJSON(w, r, http.StatusOK, result)
+}
 
-	weather, err := h.getWeather(ctx, city)
+// lookupTemperature resolves a valid CEP to its city and fetches its current
+// temperature, for both the HTTP and the gRPC API.
+func (h *handler) lookupTemperature(ctx context.Context, zipCode string) (api.Temperature, error) {
+	location, err := h.resolve(ctx, zipCode)
 	if err != nil {
-		http.Error(w, "failed to get weather info", http.StatusInternalServerError)
-		return
+		return api.Temperature{}, err
 	}
 
+	weather, err := h.currentWeather(ctx, location.Localidade)
+	if err != nil {
+		return api.Temperature{}, err
+	}
+	result := h.temperature(location.Localidade, weather)
+	result.Address = &api.Address{
+		Street:       location.Logradouro,
+		Neighborhood: location.Bairro,
+		UF:           location.UF,
+		IBGECode:     location.IBGE,
+	}
+	return result, nil
+}
+
+// temperature converts the current weather in city to the response, in every
+// scale, and keeps it as an observation for the history.
+func (h *handler) temperature(city string, weather WeatherInfo) api.Temperature {
 	tempC := weather.Current.Temperature
+	cityTemperature.WithLabelValues(h.cityLabels.Value(city)).Set(tempC)
+	h.observations.Record(city, tempC)
 	tempF := tempC*1.8 + 32
 	tempK := tempC + 273
 
-	response2 := LocationInfoAndCity{
-		City:  city,
-		TempC: tempC,
-		TempF: tempF,
-		TempK: tempK,
+	current := weather.Current
+	details := &api.WeatherDetails{
+		Humidity:   current.Humidity,
+		FeelsLikeC: current.FeelsLikeC,
+		FeelsLikeF: current.FeelsLikeC*1.8 + 32,
+		WindKph:    current.WindKph,
+		WindDegree: current.WindDegree,
+		WindDir:    current.WindDir,
+	}
+	if icon := current.Condition.Icon; strings.HasPrefix(icon, "//") {
+		details.IconURL = "https:" + icon
+	} else {
+		details.IconURL = icon
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(response2)
+	return api.Temperature{
+		City:      city,
+		TempC:     tempC,
+		TempF:     tempF,
+		TempK:     tempK,
+		Condition: current.Condition.Text,
+		Timezone:  weather.Location.TzID,
+		LocalTime: localTime(weather),
+
+		ObservedAt:  rfc3339(current.LastUpdated),
+		RetrievedAt: cmp.Or(weather.retrievedAt, time.Now()).UTC().Format(time.RFC3339),
+		Cached:      weather.cached,
+		Stale:       weather.stale,
+		Details:     details,
+	}
 }
 
-type LocationInfoAndCity struct {
-	City  string  `json:"city"`
-	TempC float64 `json:"temp_C"`
-	TempF float64 `json:"temp_F"`
-	TempK float64 `json:"temp_K"`
+// rfc3339 formats a Unix time in UTC, or returns "" for zero.
+func rfc3339(epoch int64) string {
+	if epoch == 0 {
+		return ""
+	}
+	return time.Unix(epoch, 0).UTC().Format(time.RFC3339)
 }
 
-type LocationInfo struct {
-	Localidade string `json:"localidade"`
+// localTime is the time of the weather report in the city's time zone, as
+// RFC 3339. The offset comes from WeatherAPI's wall clock rather than from
+// tz_id, so the image needs no tzdata; empty when either is missing.
+func localTime(weather WeatherInfo) string {
+	loc := weather.Location
+	wall, err := time.Parse("2006-01-02 15:04", loc.Local)
+	if err != nil || loc.Epoch == 0 {
+		return ""
+	}
+	at := time.Unix(loc.Epoch, 0)
+	// the wall clock has no seconds, and offsets are whole quarter hours
+	offset := wall.Sub(at.UTC()).Round(15 * time.Minute)
+	return at.In(time.FixedZone(loc.TzID, int(offset.Seconds()))).Format(time.RFC3339)
 }
 
-type WeatherInfo struct {
-	Current struct {
-		Temperature float64 `json:"temp_c"`
-	} `json:"current"`
+// locate resolves a valid CEP to its city on the configured CEP providers.
+func (h *handler) locate(ctx context.Context, zipCode string) (string, error) {
+	location, err := h.resolve(ctx, zipCode)
+	return location.Localidade, err
 }
 
-func (h *handler) getLocation(ctx context.Context, zipCode string) (string, error) {
-
-	_, span := h.tracer.Start(ctx, "Chamada externa: getLocation")
-	defer span.End()
-
-	tr := &http.Transport{
-		TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+// resolve looks a valid CEP up on the CEP providers, for its city and
+// address, from the cache when it holds a fresh one. The CEPs the providers
+// don't know are cached too, for CEP_NOT_FOUND_CACHE_TTL.
+func (h *handler) resolve(ctx context.Context, zipCode string) (LocationInfo, error) {
+	span := trace.SpanFromContext(ctx)
+	cached, result := h.ceps.Get(ctx, zipCode)
+	span.SetAttributes(attribute.String("cache.cep", result))
+	if result == cacheHit {
+		return cached, nil
+	}
+	_, result = h.cepsNotFound.Get(ctx, zipCode)
+	span.SetAttributes(attribute.String("cache.cep_not_found", result))
+	if result == cacheHit {
+		observeCEPNotFound(zipCode, "cache")
+		return LocationInfo{}, apperr.ErrZipNotFound
 	}
-	client := &http.Client{Transport: tr}
-	url := fmt.Sprintf("https://viacep.com.br/ws/%s/json/", zipCode)
-	resp, err := client.Get(url)
 
+	t := activeTimeouts.Load()
+	lookupCtx, cancel := withStageBudget(ctx, "cep", t.viaCEP, t.weatherAPI)
+	defer cancel()
+	location, provider, err := h.lookupCEP(lookupCtx, zipCode)
 	if err != nil {
-		return "", err
+		return LocationInfo{}, locationError(err)
 	}
-	defer resp.Body.Close()
-
-	var location LocationInfo
-	if err := json.NewDecoder(resp.Body).Decode(&location); err != nil {
-		return "", err
+	if location.Erro {
+		observeCEPNotFound(zipCode, "provider")
+		h.cepsNotFound.Set(ctx, zipCode, struct{}{}, activeCacheTTLs.Load().cepNotFound)
+		return LocationInfo{}, apperr.ErrZipNotFound
 	}
-
-	return location.Localidade, nil
+	// a CEP the provider knows always has a city
+	if location.Localidade == "" {
+		return LocationInfo{}, apperr.ErrBadUpstreamPayload.Wrap(fmt.Errorf("%s answered without a city", provider))
+	}
+	h.ceps.Set(ctx, zipCode, location, activeCacheTTLs.Load().cep)
+	return location, nil
 }
 
-func (h *handler) getWeather(ctx context.Context, city string) (WeatherInfo, error) {
+// LocationInfo is a CEP in the shape of ViaCEP's response, which the other
+// CEP providers are translated to.
+type LocationInfo struct {
+	Localidade string `json:"localidade"`
+	Logradouro string `json:"logradouro"`
+	Bairro     string `json:"bairro"`
+	UF         string `json:"uf"`
+	IBGE       string `json:"ibge"`
 
-	_, span := h.tracer.Start(ctx, "Chamada externa: getWeather")
-	defer span.End()
+	// Erro is set, by ViaCEP with a 200, for CEPs that are well formed but unknown.
+	Erro viaCEPFlag `json:"erro"`
+}
 
-	tr := &http.Transport{
-		TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
-	}
-	client := &http.Client{Transport: tr}
-	encodedCity := url.QueryEscape(city)
-	completeUrl := fmt.Sprintf("https://api.weatherapi.com/v1/current.json?key=6c0e6aefacc44ed0a69130616242705&q=%s", encodedCity)
-	resp, err := client.Get(completeUrl)
+// viaCEPFlag is a boolean that ViaCEP sends either as such or as a string.
+type viaCEPFlag bool
 
-	if err != nil {
-		return WeatherInfo{}, err
-	}
-	defer resp.Body.Close()
+func (f *viaCEPFlag) UnmarshalJSON(b []byte) error {
+	*f = string(b) == "true" || string(b) == `"true"`
+	return nil
+}
 
-	var weather WeatherInfo
-	if err := json.NewDecoder(resp.Body).Decode(&weather); err != nil {
-		return WeatherInfo{}, err
-	}
+type WeatherInfo struct {
+	Location struct {
+		Name    string `json:"name"`
+		Region  string `json:"region"`
+		Country string `json:"country"`
+		TzID    string `json:"tz_id"` // IANA, e.g. America/Sao_Paulo
+		Epoch   int64  `json:"localtime_epoch"`
+		Local   string `json:"localtime"` // wall clock at Epoch, e.g. "2024-07-01 14:32"
+	} `json:"location"`
+	Current struct {
+		Temperature float64 `json:"temp_c"`
+		LastUpdated int64   `json:"last_updated_epoch"`
+		FeelsLikeC  float64 `json:"feelslike_c"`
+		Humidity    int     `json:"humidity"`
+		WindKph     float64 `json:"wind_kph"`
+		WindDegree  int     `json:"wind_degree"`
+		WindDir     string  `json:"wind_dir"`
+		Condition   struct {
+			Text string `json:"text"`
+			Icon string `json:"icon"` // protocol-relative, e.g. //cdn.weatherapi.com/weather/64x64/day/116.png
+		} `json:"condition"`
+	} `json:"current"`
 
-	return weather, nil
+	// retrievedAt is when the weather was fetched, cached whether it came
+	// from the weather cache rather than from a provider, and stale whether
+	// that entry had expired and couldn't be refreshed in time.
+	retrievedAt time.Time
+	cached      bool
+	stale       bool
 }
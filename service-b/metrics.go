@@ -0,0 +1,131 @@
+package main
+
+import (
+	"net/http"
+	"slices"
+	"sync"
+
+	"goexpert-lab-2-observabilidade/internal/admin"
+	"goexpert-lab-2-observabilidade/internal/apperr"
+	"goexpert-lab-2-observabilidade/internal/degradation"
+	"goexpert-lab-2-observabilidade/internal/httpclient"
+	"goexpert-lab-2-observabilidade/internal/logging"
+	"goexpert-lab-2-observabilidade/internal/middleware"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/prometheus/client_golang/prometheus/push"
+)
+
+// appCollectors lists every application metric; they are registered explicitly
+// instead of through the global default registry.
+func appCollectors() []prometheus.Collector {
+	return slices.Concat([]prometheus.Collector{
+		apperr.Collector(),
+		providerErrors,
+		cacheRequests,
+		cacheEvictions,
+		redisCommands,
+		weatherRevalidations,
+		cityTemperature,
+		historyLookups,
+		cepNotFound,
+		cepNotFoundLookups,
+		cepLookupDuration,
+		cepRaceWins,
+		weatherHedges,
+		providerFailovers,
+		upstreamRetries,
+		breakerStateGauge,
+		breakerTransitions,
+		breakerRejections,
+		upstreamInFlight,
+		bulkheadRejections,
+		weatherAPIErrors,
+	}, middleware.Collectors(), logging.Collectors(), httpclient.Collectors(), admin.Collectors(), degradation.Collectors())
+}
+
+// cache lookup results reported on cache_requests_total
+const (
+	cacheHit   = "hit"
+	cacheMiss  = "miss"
+	cacheStale = "stale"
+)
+
+var (
+	cacheRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "cache_requests_total",
+		Help: "Cache lookups by cache and result (hit, miss, stale).",
+	}, []string{"cache", "result"})
+
+	cacheEvictions = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "cache_evictions_total",
+		Help: "Entries evicted from a cache, by cache.",
+	}, []string{"cache"})
+)
+
+// observeCacheLookup records a lookup result for the named cache.
+func observeCacheLookup(cache, result string) {
+	cacheRequests.WithLabelValues(cache, result).Inc()
+}
+
+// observeCacheEviction records an entry evicted from the named cache.
+func observeCacheEviction(cache string) {
+	cacheEvictions.WithLabelValues(cache).Inc()
+}
+
+// newRegistry builds an isolated registry with the process, Go and app collectors.
+func newRegistry() *prometheus.Registry {
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		collectors.NewGoCollector(),
+	)
+	reg.MustRegister(appCollectors()...)
+	return reg
+}
+
+// pushMetrics sends the registry to the Pushgateway at url, for short-lived runs
+// that live too briefly to be scraped.
+func pushMetrics(reg *prometheus.Registry, url, job string) error {
+	return push.New(url, job).Gatherer(reg).Push()
+}
+
+func metricsHandler(reg *prometheus.Registry) http.Handler {
+	return promhttp.HandlerFor(reg, promhttp.HandlerOpts{Registry: reg})
+}
+
+var cityTemperature = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "weather_current_temp_celsius",
+	Help: "Last observed temperature per city; cities past MAX_CITY_LABELS are reported as \"other\".",
+}, []string{"city"})
+
+const otherLabel = "other"
+
+// labelGuard caps how many distinct values a label may take, folding the long
+// tail into "other" so a scan of many CEPs can't explode the series count.
+type labelGuard struct {
+	mu   sync.Mutex
+	max  int
+	seen map[string]struct{}
+}
+
+func newLabelGuard(max int) *labelGuard {
+	return &labelGuard{max: max, seen: make(map[string]struct{})}
+}
+
+// Value returns v while there is room for it, otherwise "other".
+func (g *labelGuard) Value(v string) string {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if _, ok := g.seen[v]; ok {
+		return v
+	}
+	if len(g.seen) >= g.max {
+		return otherLabel
+	}
+	g.seen[v] = struct{}{}
+	return v
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// observation is a temperature served for a city.
+type observation struct {
+	at    time.Time
+	tempC float64
+}
+
+// observationStore keeps the temperatures served per city, at most one every
+// interval and for retention, as the fallback of the history endpoint. Only
+// the first maxCities cities are kept, so callers can't grow it without bound.
+type observationStore struct {
+	interval  time.Duration
+	retention time.Duration
+	maxCities int
+
+	mu     sync.Mutex
+	byCity map[string][]observation // oldest first
+}
+
+func newObservationStore(interval, retention time.Duration, maxCities int) *observationStore {
+	return &observationStore{interval: interval, retention: retention, maxCities: maxCities, byCity: make(map[string][]observation)}
+}
+
+// Record stores tempC for city, unless the latest observation of the city is
+// more recent than interval.
+func (s *observationStore) Record(city string, tempC float64) {
+	now := time.Now()
+	key := foldName(city)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	obs, ok := s.byCity[key]
+	if !ok && len(s.byCity) >= s.maxCities {
+		return
+	}
+	if n := len(obs); n > 0 && now.Sub(obs[n-1].at) < s.interval {
+		return
+	}
+
+	// drop what fell out of the retention window
+	cutoff := now.Add(-s.retention)
+	i := 0
+	for i < len(obs) && obs[i].at.Before(cutoff) {
+		i++
+	}
+	s.byCity[key] = append(obs[i:], observation{at: now, tempC: tempC})
+}
+
+// On returns the observations of city on the given day in loc, oldest first.
+func (s *observationStore) On(city string, day time.Time, loc *time.Location) []observation {
+	start := time.Date(day.Year(), day.Month(), day.Day(), 0, 0, 0, 0, loc)
+	end := start.AddDate(0, 0, 1)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var found []observation
+	for _, o := range s.byCity[foldName(city)] {
+		if !o.at.Before(start) && o.at.Before(end) {
+			found = append(found, o)
+		}
+	}
+	return found
+}
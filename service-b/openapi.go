@@ -0,0 +1,388 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"goexpert-lab-2-observabilidade/internal/admin"
+	"goexpert-lab-2-observabilidade/internal/api"
+	"goexpert-lab-2-observabilidade/internal/apperr"
+)
+
+// object is a node of the OpenAPI document.
+type object = map[string]any
+
+func ref(name string) object {
+	return object{"$ref": "#/components/schemas/" + name}
+}
+
+// errorResponses describes the error statuses an operation can answer with:
+// plain text, or a Problem with ERROR_FORMAT=problem.
+func errorResponses(responses object, statuses ...int) object {
+	for _, status := range statuses {
+		responses[strconv.Itoa(status)] = object{
+			"description": http.StatusText(status),
+			"headers":     object{apperr.TypeHeader: object{"schema": ref("ErrorType")}},
+			"content": object{
+				"text/plain":               object{"schema": object{"type": "string"}},
+				"application/problem+json": object{"schema": ref("Problem")},
+			},
+		}
+	}
+	return responses
+}
+
+// temperatureOperation describes a lookup; op holds what differs between the
+// routes, i.e. how the location is sent.
+func temperatureOperation(summary string, op object) object {
+	params, _ := op["parameters"].([]object)
+	op["parameters"] = append(params, object{
+		"name":        "detail",
+		"in":          "query",
+		"schema":      object{"type": "string", "enum": []string{"basic", "full"}, "default": "basic"},
+		"description": "full adds the details: humidity, feels-like, wind and the condition icon.",
+	}, object{
+		"name":        "include",
+		"in":          "query",
+		"schema":      object{"type": "string", "enum": []string{"address"}},
+		"description": "address adds the ViaCEP address of the CEP; not available for coordinates and city names.",
+	})
+	op["summary"] = summary
+	op["tags"] = []string{"temperature"}
+	op["responses"] = errorResponses(object{
+		"200": object{
+			"description": "City of the CEP and its current temperature in every scale.",
+			"headers": object{
+				api.CacheHeader: object{"schema": object{"type": "string", "enum": []string{"HIT", "MISS", "STALE"}},
+					"description": "Whether the temperature came from a cache, STALE when expired because the weather couldn't be refreshed in time; see retrieved_at for its age."},
+				api.AgeHeader: object{"schema": object{"type": "integer"},
+					"description": "Seconds since a cached temperature was retrieved; only sent with HIT and STALE."},
+			},
+			"content": object{"application/json": object{"schema": ref("Temperature")}},
+		},
+	}, http.StatusBadRequest, http.StatusNotFound, http.StatusPreconditionFailed, http.StatusRequestEntityTooLarge,
+		http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout)
+	return op
+}
+
+// openAPISpec builds the OpenAPI 3 document of the API.
+func openAPISpec() object {
+	byQuery := func(summary string) object {
+		return temperatureOperation(summary, object{
+			"parameters": []object{
+				{
+					"name":        "zipcode",
+					"in":          "query",
+					"schema":      object{"type": "string", "example": "22261040"},
+					"description": "The CEP, or the postal code of country; hyphens and whitespace are stripped first. Required unless lat and lon are sent.",
+				},
+				{
+					"name":        "country",
+					"in":          "query",
+					"schema":      ref("Country"),
+					"description": "The country of a postal code in zipcode, which is then not a CEP.",
+				},
+				{
+					"name":        "lat",
+					"in":          "query",
+					"schema":      object{"type": "number", "format": "double", "minimum": -90, "maximum": 90},
+					"description": "Latitude in decimal degrees, sent with lon instead of a CEP; the city is the one WeatherAPI places the coordinates in.",
+				},
+				{
+					"name":        "lon",
+					"in":          "query",
+					"schema":      object{"type": "number", "format": "double", "minimum": -180, "maximum": 180},
+					"description": "Longitude in decimal degrees, sent with lat.",
+				},
+			},
+		})
+	}
+	byBody := func(summary string) object {
+		return temperatureOperation(summary, object{
+			"requestBody": object{
+				"required": true,
+				"content":  object{"application/json": object{"schema": ref("TemperatureRequest")}},
+			},
+		})
+	}
+	deprecatedGet, deprecatedPost := byQuery("Deprecated alias of GET /v1/temperature"), byBody("Deprecated alias of POST /v1/temperature")
+	deprecatedGet["deprecated"], deprecatedPost["deprecated"] = true, true
+
+	temperature := object{"type": "number", "format": "double"}
+	clock := object{"type": "string", "pattern": `^\d{2}:\d{2}$`, "example": "06:05",
+		"description": "HH:MM in the city's time zone; absent when it does not happen that day."}
+
+	return object{
+		"openapi": "3.0.3",
+		"info": object{
+			"title":       "service-b",
+			"description": "Resolves a CEP to its city with ViaCEP and returns the current temperature, or the forecast, there from WeatherAPI.",
+			"version":     admin.Version(),
+		},
+		"paths": object{
+			"/v1/temperature": object{
+				"get":  byQuery("Temperature at a CEP or coordinates sent in the query"),
+				"post": byBody("Temperature at a CEP sent in the body"),
+			},
+			"/v1/temperature/city/{name}": object{
+				"get": temperatureOperation("Temperature in a Brazilian city, by name", object{
+					"parameters": []object{
+						{
+							"name":        "name",
+							"in":          "path",
+							"required":    true,
+							"schema":      object{"type": "string", "minLength": 1, "maxLength": maxCityName, "example": "São José"},
+							"description": "The city name, URL-escaped.",
+						},
+						{
+							"name":        "uf",
+							"in":          "query",
+							"schema":      object{"type": "string", "enum": stateUFs(), "example": "SC"},
+							"description": "The state, to pick between cities of the same name.",
+						},
+					},
+				}),
+			},
+			"/zipcode": object{"get": deprecatedGet, "post": deprecatedPost},
+			"/v1/forecast/{cep}": object{"get": object{
+				"summary": "Daily forecast for the city of a CEP",
+				"tags":    []string{"forecast"},
+				"parameters": []object{
+					{"name": "cep", "in": "path", "required": true, "schema": ref("CEP")},
+					{
+						"name":        "days",
+						"in":          "query",
+						"schema":      object{"type": "integer", "minimum": 1, "maximum": maxForecastDays, "default": defaultForecastDays},
+						"description": "Number of days, starting today.",
+					},
+				},
+				"responses": errorResponses(object{
+					"200": object{
+						"description": "City of the CEP and its forecast, one entry per day.",
+						"content":     object{"application/json": object{"schema": ref("Forecast")}},
+					},
+				}, http.StatusBadRequest, http.StatusNotFound, http.StatusPreconditionFailed,
+					http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout),
+			}},
+			"/v1/airquality/{cep}": object{"get": object{
+				"summary":    "Current air quality in the city of a CEP",
+				"tags":       []string{"airquality"},
+				"parameters": []object{{"name": "cep", "in": "path", "required": true, "schema": ref("CEP")}},
+				"responses": errorResponses(object{
+					"200": object{
+						"description": "City of the CEP with its particulate matter and AQI.",
+						"content":     object{"application/json": object{"schema": ref("AirQuality")}},
+					},
+				}, http.StatusNotFound, http.StatusPreconditionFailed,
+					http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout),
+			}},
+			"/v1/astronomy/{cep}": object{"get": object{
+				"summary": "Sun and moon schedule in the city of a CEP",
+				"tags":    []string{"astronomy"},
+				"parameters": []object{
+					{"name": "cep", "in": "path", "required": true, "schema": ref("CEP")},
+					{
+						"name":        "date",
+						"in":          "query",
+						"schema":      object{"type": "string", "format": "date", "example": "2024-07-01"},
+						"description": "The day, today in Brasília when omitted.",
+					},
+				},
+				"responses": errorResponses(object{
+					"200": object{
+						"description": "City of the CEP with the sun and moon times of the day.",
+						"content":     object{"application/json": object{"schema": ref("Astronomy")}},
+					},
+				}, http.StatusBadRequest, http.StatusNotFound, http.StatusPreconditionFailed,
+					http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout),
+			}},
+			"/v1/history/{cep}": object{"get": object{
+				"summary": "Weather of a past day in the city of a CEP",
+				"tags":    []string{"history"},
+				"parameters": []object{
+					{"name": "cep", "in": "path", "required": true, "schema": ref("CEP")},
+					{
+						"name":        "date",
+						"in":          "query",
+						"required":    true,
+						"schema":      object{"type": "string", "format": "date", "example": "2024-07-01"},
+						"description": "The day, in Brasília time; today gives the day so far.",
+					},
+				},
+				"responses": errorResponses(object{
+					"200": object{
+						"description": "City of the CEP with the day's temperatures, from WeatherAPI or, when it has none for the date, from the temperatures served that day.",
+						"content":     object{"application/json": object{"schema": ref("History")}},
+					},
+				}, http.StatusBadRequest, http.StatusNotFound, http.StatusPreconditionFailed,
+					http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout),
+			}},
+		},
+		"components": object{
+			"schemas": object{
+				"CEP": object{"type": "string", "pattern": `^\d{5}-?\d{3}$`, "example": "22261040"},
+				"TemperatureRequest": object{
+					"type":                 "object",
+					"required":             []string{"cep"},
+					"additionalProperties": false,
+					"properties": object{
+						"cep": object{"type": "string", "example": "22261040",
+							"description": "A CEP, or with country the postal code of another country; hyphens and whitespace are stripped first."},
+						"country": ref("Country"),
+					},
+				},
+				"Country": object{"type": "string", "enum": api.Countries(), "default": api.DefaultCountry,
+					"description": "The country of the postal code, as ISO 3166-1 alpha-2; CEPs are BR and the others are looked up on Zippopotam.us."},
+				"Temperature": object{
+					"type":     "object",
+					"required": []string{"city", "temp_C", "temp_F", "temp_K"},
+					"properties": object{
+						"city":   object{"type": "string", "example": "Rio de Janeiro"},
+						"temp_C": temperature,
+						"temp_F": temperature,
+						"temp_K": temperature,
+						"condition": object{"type": "string", "example": "Partly cloudy",
+							"description": "The weather as described by WeatherAPI."},
+						"timezone": object{"type": "string", "example": "America/Sao_Paulo",
+							"description": "The IANA time zone of the city."},
+						"local_time": object{"type": "string", "format": "date-time", "example": "2024-07-01T14:32:00-03:00",
+							"description": "When the weather was reported, in the time zone of the city."},
+						"observed_at": object{"type": "string", "format": "date-time", "example": "2024-07-01T17:30:00Z",
+							"description": "When WeatherAPI last updated the weather."},
+						"retrieved_at": object{"type": "string", "format": "date-time", "example": "2024-07-01T17:32:05Z",
+							"description": "When service-b fetched the weather from WeatherAPI; older than the request when cached."},
+						"details": ref("WeatherDetails"),
+						"address": ref("Address"),
+					},
+				},
+				"Address": object{
+					"type":        "object",
+					"description": "Only sent with include=address.",
+					"required":    []string{"uf"},
+					"properties": object{
+						"street":       object{"type": "string", "example": "Avenida Paulista", "description": "Absent for CEPs covering a whole city."},
+						"neighborhood": object{"type": "string", "example": "Bela Vista"},
+						"uf":           object{"type": "string", "example": "SP"},
+						"ibge_code":    object{"type": "string", "example": "3550308", "description": "IBGE code of the city; absent when the CEP provider has none."},
+					},
+				},
+				"WeatherDetails": object{
+					"type":        "object",
+					"description": "Only sent with detail=full.",
+					"required":    []string{"humidity", "feels_like_C", "feels_like_F", "wind_kph", "wind_degree", "wind_dir"},
+					"properties": object{
+						"humidity":     object{"type": "integer", "minimum": 0, "maximum": 100, "description": "Relative humidity, in percent."},
+						"feels_like_C": temperature,
+						"feels_like_F": temperature,
+						"wind_kph":     object{"type": "number", "format": "double"},
+						"wind_degree":  object{"type": "integer", "minimum": 0, "maximum": 360, "description": "Where the wind comes from, clockwise from north."},
+						"wind_dir":     object{"type": "string", "example": "SSW"},
+						"icon_url":     object{"type": "string", "format": "uri", "example": "https://cdn.weatherapi.com/weather/64x64/day/116.png"},
+					},
+				},
+				"Forecast": object{
+					"type":     "object",
+					"required": []string{"city", "days"},
+					"properties": object{
+						"city": object{"type": "string", "example": "Rio de Janeiro"},
+						"days": object{"type": "array", "items": ref("ForecastDay")},
+					},
+				},
+				"ForecastDay": object{
+					"type":     "object",
+					"required": []string{"date", "min_temp_C", "max_temp_C", "min_temp_F", "max_temp_F"},
+					"properties": object{
+						"date":       object{"type": "string", "format": "date", "example": "2024-07-01"},
+						"min_temp_C": temperature,
+						"max_temp_C": temperature,
+						"min_temp_F": temperature,
+						"max_temp_F": temperature,
+						"condition": object{"type": "string", "example": "Patchy rain nearby",
+							"description": "The day's weather as described by WeatherAPI."},
+					},
+				},
+				"AirQuality": object{
+					"type":     "object",
+					"required": []string{"city", "pm2_5", "pm10", "aqi", "category"},
+					"properties": object{
+						"city":     object{"type": "string", "example": "Rio de Janeiro"},
+						"pm2_5":    object{"type": "number", "format": "double", "description": "Fine particulate matter, in µg/m³."},
+						"pm10":     object{"type": "number", "format": "double", "description": "Coarse particulate matter, in µg/m³."},
+						"aqi":      object{"type": "integer", "minimum": 1, "maximum": len(aqiCategories), "description": "US EPA air quality index."},
+						"category": object{"type": "string", "enum": aqiCategories},
+					},
+				},
+				"Astronomy": object{
+					"type":     "object",
+					"required": []string{"city", "date", "moon_phase", "moon_illumination"},
+					"properties": object{
+						"city":              object{"type": "string", "example": "Rio de Janeiro"},
+						"date":              object{"type": "string", "format": "date", "example": "2024-07-01"},
+						"sunrise":           clock,
+						"sunset":            clock,
+						"moonrise":          clock,
+						"moonset":           clock,
+						"moon_phase":        object{"type": "string", "example": "Waxing Crescent"},
+						"moon_illumination": object{"type": "integer", "minimum": 0, "maximum": 100, "description": "Lit share of the moon, in percent."},
+					},
+				},
+				"History": object{
+					"type":     "object",
+					"required": []string{"city", "date", "min_temp_C", "max_temp_C", "avg_temp_C", "source", "hours"},
+					"properties": object{
+						"city":       object{"type": "string", "example": "Rio de Janeiro"},
+						"date":       object{"type": "string", "format": "date", "example": "2024-07-01"},
+						"min_temp_C": temperature,
+						"max_temp_C": temperature,
+						"avg_temp_C": temperature,
+						"condition": object{"type": "string", "example": "Sunny",
+							"description": "The day's weather as described by WeatherAPI; absent for stored observations."},
+						"source": object{"type": "string", "enum": []string{historyWeatherAPI, historyObservations}},
+						"hours": object{"type": "array", "items": object{
+							"type":     "object",
+							"required": []string{"time", "temp_C"},
+							"properties": object{
+								"time":   object{"type": "string", "pattern": `^\d{2}:\d{2}$`, "example": "13:00"},
+								"temp_C": temperature,
+							},
+						}},
+					},
+				},
+				"ErrorType": object{"type": "string", "enum": apperr.Types()},
+				"FieldError": object{
+					"type":     "object",
+					"required": []string{"field", "message"},
+					"properties": object{
+						"field":   object{"type": "string"},
+						"message": object{"type": "string"},
+					},
+				},
+				"Problem": object{
+					"type":     "object",
+					"required": []string{"type", "title", "status"},
+					"properties": object{
+						"type":     object{"type": "string", "example": "/errors/zipcode_not_found"},
+						"title":    object{"type": "string"},
+						"status":   object{"type": "integer"},
+						"detail":   object{"type": "string"},
+						"instance": object{"type": "string", "example": "urn:trace:4bf92f3577b34da6a3ce929d0e0e4736"},
+						"errors":   object{"type": "array", "items": ref("FieldError")},
+					},
+				},
+			},
+		},
+	}
+}
+
+// openAPIHandler serves the OpenAPI document, built once at startup.
+func openAPIHandler() http.HandlerFunc {
+	body, err := json.Marshal(openAPISpec())
+	if err != nil {
+		panic(err)
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(body)
+	}
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	openMeteoBaseURL          = "https://api.open-meteo.com"
+	openMeteoGeocodingBaseURL = "https://geocoding-api.open-meteo.com"
+)
+
+// openMeteoPlace is a result of Open-Meteo's geocoding search.
+type openMeteoPlace struct {
+	Name      string  `json:"name"`
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+	Country   string  `json:"country"`
+	Admin1    string  `json:"admin1"` // the state
+}
+
+// openMeteoInfo is the part of Open-Meteo's forecast response we use, with
+// the current conditions only.
+type openMeteoInfo struct {
+	Timezone string `json:"timezone"`
+	Offset   int    `json:"utc_offset_seconds"`
+	Current  struct {
+		Time          string  `json:"time"` // local, e.g. "2024-07-01T14:30"
+		Temperature   float64 `json:"temperature_2m"`
+		Humidity      int     `json:"relative_humidity_2m"`
+		FeelsLike     float64 `json:"apparent_temperature"`
+		WeatherCode   int     `json:"weather_code"`
+		WindSpeed     float64 `json:"wind_speed_10m"` // km/h
+		WindDirection int     `json:"wind_direction_10m"`
+	} `json:"current"`
+}
+
+// weatherCodes describes the WMO weather codes Open-Meteo reports, in the
+// words of WeatherAPI's conditions where there is one.
+var weatherCodes = map[int]string{
+	0: "Clear", 1: "Mainly clear", 2: "Partly cloudy", 3: "Overcast",
+	45: "Fog", 48: "Freezing fog",
+	51: "Light drizzle", 53: "Drizzle", 55: "Heavy drizzle",
+	56: "Light freezing drizzle", 57: "Heavy freezing drizzle",
+	61: "Light rain", 63: "Moderate rain", 65: "Heavy rain",
+	66: "Light freezing rain", 67: "Heavy freezing rain",
+	71: "Light snow", 73: "Moderate snow", 75: "Heavy snow", 77: "Snow grains",
+	80: "Light rain shower", 81: "Moderate rain shower", 82: "Torrential rain shower",
+	85: "Light snow showers", 86: "Heavy snow showers",
+	95: "Thunderstorm", 96: "Thunderstorm with light hail", 99: "Thunderstorm with heavy hail",
+}
+
+// openMeteoProvider is WeatherProvider on Open-Meteo, which needs no API key.
+// City names are resolved to coordinates with its geocoding API first; it
+// has no reverse geocoding, so coordinates stand in for the city name of
+// coordinate lookups.
+type openMeteoProvider struct {
+	client *http.Client
+	tracer trace.Tracer
+}
+
+func (p openMeteoProvider) Name() string    { return providerOpenMeteo }
+func (p openMeteoProvider) BaseURL() string { return openMeteoBaseURL }
+
+func (p openMeteoProvider) GetCurrent(ctx context.Context, query string) (WeatherInfo, error) {
+
+	ctx, span := p.tracer.Start(ctx, "Chamada externa: getWeather", trace.WithAttributes(attribute.String("weather.provider", providerOpenMeteo)))
+	defer span.End()
+
+	ctx, cancel := withTimeout(ctx, activeTimeouts.Load().weatherAPI)
+	defer cancel()
+
+	var weather WeatherInfo
+	q := parseWeatherQuery(query)
+	if q.coordinates {
+		weather.Location.Name = query
+		weather.Location.Country = "Brazil"
+	} else {
+		place, err := p.geocode(ctx, q)
+		if err != nil {
+			return WeatherInfo{}, err
+		}
+		q.lat, q.lon = place.Latitude, place.Longitude
+		weather.Location.Name = place.Name
+		weather.Location.Region = place.Admin1
+		weather.Location.Country = place.Country
+	}
+
+	params := url.Values{
+		"latitude":  {strconv.FormatFloat(q.lat, 'f', -1, 64)},
+		"longitude": {strconv.FormatFloat(q.lon, 'f', -1, 64)},
+		"current":   {"temperature_2m,relative_humidity_2m,apparent_temperature,weather_code,wind_speed_10m,wind_direction_10m"},
+		"timezone":  {"auto"},
+	}
+	var info openMeteoInfo
+	if err := p.get(ctx, openMeteoBaseURL+"/v1/forecast?"+params.Encode(), &info); err != nil {
+		return WeatherInfo{}, err
+	}
+
+	current := info.Current
+	if local, err := time.Parse("2006-01-02T15:04", current.Time); err == nil {
+		weather.Location.TzID = info.Timezone
+		weather.Location.Epoch = local.Unix() - int64(info.Offset)
+		weather.Location.Local = strings.Replace(current.Time, "T", " ", 1)
+		weather.Current.LastUpdated = weather.Location.Epoch
+	}
+	weather.Current.Temperature = current.Temperature
+	weather.Current.FeelsLikeC = current.FeelsLike
+	weather.Current.Humidity = current.Humidity
+	weather.Current.WindKph = current.WindSpeed
+	weather.Current.WindDegree = current.WindDirection
+	weather.Current.WindDir = compass(current.WindDirection)
+	weather.Current.Condition.Text = weatherCodes[current.WeatherCode]
+	return weather, nil
+}
+
+// geocode finds the Brazilian city of q, in its state when q has one.
+func (p openMeteoProvider) geocode(ctx context.Context, q weatherQuery) (openMeteoPlace, error) {
+	ctx, span := p.tracer.Start(ctx, "Chamada externa: geocode")
+	defer span.End()
+
+	params := url.Values{"name": {q.name}, "countryCode": {"BR"}, "language": {"en"}, "count": {"10"}}
+	var found struct {
+		Results []openMeteoPlace `json:"results"` // absent when nothing matches
+	}
+	if err := p.get(ctx, openMeteoGeocodingBaseURL+"/v1/search?"+params.Encode(), &found); err != nil {
+		return openMeteoPlace{}, err
+	}
+	for _, place := range found.Results {
+		if q.region == "" || foldName(place.Admin1) == foldName(q.region) {
+			return place, nil
+		}
+	}
+	return openMeteoPlace{}, fmt.Errorf("%w: %q", errNoLocation, q.name)
+}
+
+func (p openMeteoProvider) get(ctx context.Context, url string, v any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := p.client.Do(req)
+
+	if err != nil {
+		observeProviderError(providerOpenMeteo, err)
+		return err
+	}
+	defer resp.Body.Close()
+
+	if err := checkStatus(providerOpenMeteo, resp); err != nil {
+		observeProviderError(providerOpenMeteo, err)
+		return err
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
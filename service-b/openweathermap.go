@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"unicode"
+	"unicode/utf8"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const openWeatherMapBaseURL = "https://api.openweathermap.org"
+
+// openWeatherMapInfo is the part of OpenWeatherMap's current weather
+// response we use.
+type openWeatherMapInfo struct {
+	Name    string `json:"name"`
+	Dt      int64  `json:"dt"`
+	Offset  int    `json:"timezone"` // seconds east of UTC
+	Weather []struct {
+		Description string `json:"description"`
+		Icon        string `json:"icon"`
+	} `json:"weather"`
+	Main struct {
+		Temp      float64 `json:"temp"`
+		FeelsLike float64 `json:"feels_like"`
+		Humidity  int     `json:"humidity"`
+	} `json:"main"`
+	Wind struct {
+		Speed float64 `json:"speed"` // m/s
+		Deg   int     `json:"deg"`
+	} `json:"wind"`
+	Sys struct {
+		Country string `json:"country"` // ISO 3166 code
+	} `json:"sys"`
+}
+
+// openWeatherMapProvider is WeatherProvider on OpenWeatherMap's current
+// weather API, with the key from OPENWEATHERMAP_API_KEY. It has no states,
+// so state names in the query are dropped and results carry no region.
+type openWeatherMapProvider struct {
+	client *http.Client
+	tracer trace.Tracer
+}
+
+func (p openWeatherMapProvider) Name() string    { return providerOpenWeatherMap }
+func (p openWeatherMapProvider) BaseURL() string { return openWeatherMapBaseURL }
+
+func (p openWeatherMapProvider) GetCurrent(ctx context.Context, query string) (WeatherInfo, error) {
+
+	ctx, span := p.tracer.Start(ctx, "Chamada externa: getWeather", trace.WithAttributes(attribute.String("weather.provider", providerOpenWeatherMap)))
+	defer span.End()
+
+	ctx, cancel := withTimeout(ctx, activeTimeouts.Load().weatherAPI)
+	defer cancel()
+
+	params := url.Values{"units": {"metric"}}
+	if q := parseWeatherQuery(query); q.coordinates {
+		params.Set("lat", strconv.FormatFloat(q.lat, 'f', -1, 64))
+		params.Set("lon", strconv.FormatFloat(q.lon, 'f', -1, 64))
+	} else {
+		params.Set("q", q.name+",BR")
+	}
+	completeUrl := fmt.Sprintf("%s/data/2.5/weather?%s", openWeatherMapBaseURL, params.Encode())
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, completeUrl, nil)
+	if err != nil {
+		return WeatherInfo{}, err
+	}
+	resp, err := p.client.Do(req)
+
+	if err != nil {
+		observeProviderError(providerOpenWeatherMap, err)
+		return WeatherInfo{}, err
+	}
+	defer resp.Body.Close()
+
+	if err := checkStatus(providerOpenWeatherMap, resp); err != nil {
+		observeProviderError(providerOpenWeatherMap, err)
+		if resp.StatusCode == http.StatusNotFound {
+			return WeatherInfo{}, fmt.Errorf("%w: %w", errNoLocation, err)
+		}
+		return WeatherInfo{}, err
+	}
+
+	var info openWeatherMapInfo
+	if err := json.NewDecoder(resp.Body).Decode(&info); err != nil {
+		return WeatherInfo{}, err
+	}
+
+	var weather WeatherInfo
+	weather.Location.Name = info.Name
+	if info.Sys.Country == "BR" {
+		weather.Location.Country = "Brazil"
+	} else {
+		weather.Location.Country = info.Sys.Country
+	}
+	weather.Location.Epoch = info.Dt
+	weather.Location.Local = wallClock(info.Dt, info.Offset)
+	weather.Current.Temperature = info.Main.Temp
+	weather.Current.LastUpdated = info.Dt
+	weather.Current.FeelsLikeC = info.Main.FeelsLike
+	weather.Current.Humidity = info.Main.Humidity
+	weather.Current.WindKph = info.Wind.Speed * 3.6
+	weather.Current.WindDegree = info.Wind.Deg
+	weather.Current.WindDir = compass(info.Wind.Deg)
+	if len(info.Weather) > 0 {
+		weather.Current.Condition.Text = capitalize(info.Weather[0].Description)
+		weather.Current.Condition.Icon = "https://openweathermap.org/img/wn/" + info.Weather[0].Icon + "@2x.png"
+	}
+	return weather, nil
+}
+
+// capitalize turns OpenWeatherMap's lowercase descriptions, e.g. "light
+// rain", into the sentence case WeatherAPI uses.
+func capitalize(s string) string {
+	r, n := utf8.DecodeRuneInString(s)
+	return string(unicode.ToUpper(r)) + s[n:]
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/redis/go-redis/extra/redisotel/v9"
+	"github.com/redis/go-redis/v9"
+	"github.com/spf13/viper"
+
+	"goexpert-lab-2-observabilidade/internal/middleware"
+)
+
+var redisCommands = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+	Name:    "redis_command_duration_seconds",
+	Help:    "Commands sent to the Redis cache, by command and result (ok, error).",
+	Buckets: []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25},
+}, []string{"command", "result"})
+
+type redisConfig struct {
+	addr     string
+	password string
+	db       int
+	tls      bool
+	prefix   string        // of every key, so services can share a server
+	timeout  time.Duration // of each command, connecting included
+	poolSize int           // connections kept to the server
+}
+
+func loadRedisConfig() redisConfig {
+	return redisConfig{
+		addr:     viper.GetString("REDIS_ADDR"),
+		password: viper.GetString("REDIS_PASSWORD"),
+		db:       viper.GetInt("REDIS_DB"),
+		tls:      viper.GetBool("REDIS_TLS"),
+		prefix:   viper.GetString("REDIS_KEY_PREFIX"),
+		timeout:  viper.GetDuration("REDIS_TIMEOUT"),
+		poolSize: viper.GetInt("REDIS_POOL_SIZE"),
+	}
+}
+
+// Validate checks that the address is a host:port, the database isn't
+// negative, the timeout is positive and at least one connection is allowed.
+func (c redisConfig) Validate() error {
+	var errs []error
+	if _, _, err := net.SplitHostPort(c.addr); err != nil {
+		errs = append(errs, fmt.Errorf("REDIS_ADDR %q must be a host:port with CACHE_BACKEND=redis", c.addr))
+	}
+	if c.db < 0 {
+		errs = append(errs, fmt.Errorf("REDIS_DB (%d) must not be negative", c.db))
+	}
+	if c.timeout <= 0 {
+		errs = append(errs, fmt.Errorf("REDIS_TIMEOUT (%s) must be positive", c.timeout))
+	}
+	if c.poolSize < 1 {
+		errs = append(errs, fmt.Errorf("REDIS_POOL_SIZE (%d) must be at least 1", c.poolSize))
+	}
+	return errors.Join(errs...)
+}
+
+// redisClient is the connection pool the Redis caches share, with its
+// commands traced as client spans by redisotel and timed on
+// redis_command_duration_seconds.
+type redisClient struct {
+	*redis.Client
+	prefix string
+}
+
+func newRedisClient(cfg redisConfig) (*redisClient, error) {
+	opts := &redis.Options{
+		Addr:                  cfg.addr,
+		Password:              cfg.password,
+		DB:                    cfg.db,
+		PoolSize:              cfg.poolSize,
+		DialTimeout:           cfg.timeout,
+		ReadTimeout:           cfg.timeout,
+		WriteTimeout:          cfg.timeout,
+		PoolTimeout:           cfg.timeout,
+		ContextTimeoutEnabled: true,
+	}
+	if cfg.tls {
+		host, _, _ := net.SplitHostPort(cfg.addr)
+		opts.TLSConfig = &tls.Config{ServerName: host}
+	}
+	client := redis.NewClient(opts)
+	if err := redisotel.InstrumentTracing(client); err != nil {
+		return nil, err
+	}
+	client.AddHook(redisMetrics{})
+	return &redisClient{Client: client, prefix: cfg.prefix}, nil
+}
+
+// redisMetrics times every command on redis_command_duration_seconds; a
+// missing key is an answer, not an error.
+type redisMetrics struct{}
+
+func (redisMetrics) DialHook(next redis.DialHook) redis.DialHook {
+	return next
+}
+
+func (redisMetrics) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		start := time.Now()
+		err := next(ctx, cmd)
+		result := "ok"
+		if err != nil && !errors.Is(err, redis.Nil) {
+			result = "error"
+		}
+		redisCommands.WithLabelValues(strings.ToUpper(cmd.Name()), result).Observe(time.Since(start).Seconds())
+		return err
+	}
+}
+
+func (redisMetrics) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return next
+}
+
+// redisCache is a cache on Redis, whose values are stored as JSON under
+// REDIS_KEY_PREFIX, the name of the cache and the key, and expired by the
+// server. Redis failing is a miss, so the lookup goes on to the providers.
+type redisCache[V any] struct {
+	name   string
+	client *redisClient
+}
+
+func (c redisCache[V]) key(key string) string {
+	return c.client.prefix + c.name + ":" + key
+}
+
+func (c redisCache[V]) Get(ctx context.Context, key string) (V, string) {
+	var value V
+	b, err := c.client.Get(ctx, c.key(key)).Bytes()
+	if err == nil {
+		err = json.Unmarshal(b, &value)
+	}
+	if err != nil && !errors.Is(err, redis.Nil) {
+		slog.WarnContext(ctx, "redis cache lookup failed", "cache", c.name, "error", err)
+	}
+	if err != nil {
+		observeCacheLookup(c.name, cacheMiss)
+		var zero V
+		return zero, cacheMiss
+	}
+	observeCacheLookup(c.name, cacheHit)
+	middleware.MarkFeature(ctx, usesCacheRedis)
+	return value, cacheHit
+}
+
+func (c redisCache[V]) Set(ctx context.Context, key string, value V, ttl time.Duration) {
+	b, err := json.Marshal(value)
+	if err == nil {
+		err = c.client.Set(ctx, c.key(key), b, max(ttl, time.Millisecond)).Err()
+	}
+	if err != nil {
+		slog.WarnContext(ctx, "redis cache store failed", "cache", c.name, "error", err)
+	}
+}
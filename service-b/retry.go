@@ -0,0 +1,144 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/spf13/viper"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+
+	"goexpert-lab-2-observabilidade/internal/middleware"
+)
+
+var upstreamRetries = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "upstream_retries_total",
+	Help: "Upstream calls retried after a transient failure, by host.",
+}, []string{"host"})
+
+// retryConfig is the retry policy of upstream calls.
+type retryConfig struct {
+	maxAttempts int
+	backoff     time.Duration // before the first retry, doubled before each next one
+	maxBackoff  time.Duration
+	statuses    map[int]bool // retried status codes
+	invalid     []string     // entries of UPSTREAM_RETRY_STATUS_CODES that aren't status codes
+}
+
+func loadRetryConfig() retryConfig {
+	c := retryConfig{
+		maxAttempts: viper.GetInt("UPSTREAM_RETRY_MAX_ATTEMPTS"),
+		backoff:     viper.GetDuration("UPSTREAM_RETRY_BACKOFF"),
+		maxBackoff:  viper.GetDuration("UPSTREAM_RETRY_MAX_BACKOFF"),
+		statuses:    map[int]bool{},
+	}
+	for _, v := range strings.Split(viper.GetString("UPSTREAM_RETRY_STATUS_CODES"), ",") {
+		if v = strings.TrimSpace(v); v == "" {
+			continue
+		}
+		code, err := strconv.Atoi(v)
+		if err != nil || code < 100 || code > 599 {
+			c.invalid = append(c.invalid, v)
+			continue
+		}
+		c.statuses[code] = true
+	}
+	return c
+}
+
+// Validate checks that there is at least one attempt, that the backoff is
+// positive and bounded by its maximum, and that only status codes are retried.
+func (c retryConfig) Validate() error {
+	var errs []error
+	if c.maxAttempts < 1 {
+		errs = append(errs, fmt.Errorf("UPSTREAM_RETRY_MAX_ATTEMPTS (%d) must be at least 1", c.maxAttempts))
+	}
+	if c.backoff <= 0 {
+		errs = append(errs, fmt.Errorf("UPSTREAM_RETRY_BACKOFF (%s) must be positive", c.backoff))
+	} else if c.maxBackoff < c.backoff {
+		errs = append(errs, fmt.Errorf("UPSTREAM_RETRY_MAX_BACKOFF (%s) must be at least UPSTREAM_RETRY_BACKOFF (%s)", c.maxBackoff, c.backoff))
+	}
+	for _, v := range c.invalid {
+		errs = append(errs, fmt.Errorf("UPSTREAM_RETRY_STATUS_CODES has %q, which is not an HTTP status code", v))
+	}
+	return errors.Join(errs...)
+}
+
+// shouldRetry reports whether an attempt failed transiently: with a retried
+// status code, or with a timeout or connection failure.
+func (c retryConfig) shouldRetry(resp *http.Response, err error) bool {
+	if err != nil {
+		return classifyRetry(err) == retryable
+	}
+	return c.statuses[resp.StatusCode]
+}
+
+// delay is the backoff before retrying a failed attempt: exponential, capped
+// at maxBackoff, with full jitter so callers failing together don't retry
+// together.
+func (c retryConfig) delay(attempt int) time.Duration {
+	d := c.backoff << (attempt - 1)
+	if d <= 0 || d > c.maxBackoff {
+		d = c.maxBackoff
+	}
+	return rand.N(d + 1)
+}
+
+// retryTransport retries the GET requests of upstream calls that fail
+// transiently, within the deadline of the call, tracing each attempt as a
+// child span. Other methods are sent once.
+type retryTransport struct {
+	base   http.RoundTripper
+	tracer trace.Tracer
+	cfg    retryConfig
+}
+
+func (t retryTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	if r.Method != http.MethodGet {
+		return t.base.RoundTrip(r)
+	}
+	ctx := r.Context()
+	for attempt := 1; ; attempt++ {
+		resp, err := t.attempt(r, attempt)
+		if attempt >= t.cfg.maxAttempts || ctx.Err() != nil || !t.cfg.shouldRetry(resp, err) {
+			return resp, err
+		}
+		if resp != nil {
+			io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+			resp.Body.Close()
+		}
+
+		upstreamRetries.WithLabelValues(r.URL.Host).Inc()
+		middleware.MarkFeature(ctx, usesRetry)
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(t.cfg.delay(attempt)):
+		}
+	}
+}
+
+func (t retryTransport) attempt(r *http.Request, attempt int) (*http.Response, error) {
+	ctx, span := t.tracer.Start(r.Context(), "upstream attempt", trace.WithAttributes(
+		attribute.Int("retry.attempt", attempt),
+		attribute.String("server.address", r.URL.Host),
+	))
+	defer span.End()
+
+	resp, err := t.base.RoundTrip(r.WithContext(ctx))
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+	span.SetAttributes(attribute.Int("http.response.status_code", resp.StatusCode))
+	return resp, nil
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/spf13/viper"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"goexpert-lab-2-observabilidade/internal/middleware"
+)
+
+// selectionPolicy decides the order in which interchangeable providers are tried.
+type selectionPolicy string
+
+const (
+	policyPriority   selectionPolicy = "priority"
+	policyLatency    selectionPolicy = "latency"
+	policyRoundRobin selectionPolicy = "round-robin"
+)
+
+// unhealthyAfter is the number of consecutive failures that marks a provider unhealthy.
+const unhealthyAfter = 3
+
+// p95Step scales the steps of the p95 estimates, relative to the estimate.
+const p95Step = 0.1
+
+func parseSelectionPolicy(v string) (selectionPolicy, error) {
+	switch p := selectionPolicy(v); p {
+	case policyPriority, policyLatency, policyRoundRobin:
+		return p, nil
+	}
+	return "", fmt.Errorf("unknown provider selection policy %q", v)
+}
+
+// parseProviders parses the setting key, a comma-separated list of
+// providers in priority order, each one of known.
+func parseProviders(key string, known []string) ([]string, error) {
+	var names []string
+	for _, name := range strings.Split(viper.GetString(key), ",") {
+		name = strings.TrimSpace(name)
+		if !slices.Contains(known, name) {
+			return nil, fmt.Errorf("unknown provider %q in %s, expected some of %s", name, key, strings.Join(known, ", "))
+		}
+		if slices.Contains(names, name) {
+			return nil, fmt.Errorf("provider %q is listed twice in %s", name, key)
+		}
+		names = append(names, name)
+	}
+	return names, nil
+}
+
+type providerState struct {
+	p95      float64 // decayed estimate of the p95 latency, in seconds
+	failures int     // consecutive failures
+}
+
+// providerSelector orders providers by the configured policy. Healthy
+// providers always come first; unhealthy ones stay at the end as a last resort.
+type providerSelector struct {
+	policy selectionPolicy
+
+	mu     sync.Mutex
+	states map[string]*providerState
+	next   int // round-robin cursor
+}
+
+func newProviderSelector(policy selectionPolicy) *providerSelector {
+	return &providerSelector{policy: policy, states: make(map[string]*providerState)}
+}
+
+func (s *providerSelector) state(name string) *providerState {
+	st, ok := s.states[name]
+	if !ok {
+		st = &providerState{}
+		s.states[name] = st
+	}
+	return st
+}
+
+// Observe records the latency and outcome of a call to the named provider.
+func (s *providerSelector) Observe(name string, dur time.Duration, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	st := s.state(name)
+	if err != nil {
+		st.failures++
+		return
+	}
+	st.failures = 0
+
+	// stochastic quantile tracking: move up by 95% of the step when a sample
+	// lands above the estimate and down by 5% when below
+	x := dur.Seconds()
+	if st.p95 == 0 {
+		st.p95 = x
+		return
+	}
+	step := p95Step * st.p95
+	if x > st.p95 {
+		st.p95 += step * 0.95
+	} else {
+		st.p95 -= step * 0.05
+	}
+}
+
+// P95 returns the decayed p95 latency of the named provider, or 0 before
+// its first success.
+func (s *providerSelector) P95(name string) time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return time.Duration(s.state(name).p95 * float64(time.Second))
+}
+
+// Order returns providers, given in priority order, in the order they should
+// be tried, and records the decision on the span in ctx.
+func (s *providerSelector) Order(ctx context.Context, providers []string) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ordered := append([]string(nil), providers...)
+	switch s.policy {
+	case policyLatency:
+		sort.SliceStable(ordered, func(i, j int) bool {
+			return s.state(ordered[i]).p95 < s.state(ordered[j]).p95
+		})
+	case policyRoundRobin:
+		if n := len(ordered); n > 0 {
+			k := s.next % n
+			ordered = append(ordered[k:], ordered[:k]...)
+			s.next++
+		}
+	}
+	sort.SliceStable(ordered, func(i, j int) bool {
+		return s.state(ordered[i]).failures < unhealthyAfter && s.state(ordered[j]).failures >= unhealthyAfter
+	})
+
+	trace.SpanFromContext(ctx).SetAttributes(
+		attribute.String("provider.selection.policy", string(s.policy)),
+		attribute.StringSlice("provider.selection.order", ordered),
+	)
+	return ordered
+}
+
+var providerFailovers = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "provider_failovers_total",
+	Help: "Lookups moved on to the next provider after one failed, by kind (cep, weather) and providers.",
+}, []string{"kind", "from", "to"})
+
+// withFailover calls call with each of the providers, in order, until one
+// succeeds or fails with an error final reports the next ones can't fix,
+// such as an unknown location, and returns the last error. Each move to the
+// next provider is recorded as a span event and on provider_failovers_total.
+func withFailover(ctx context.Context, kind string, providers []string, call func(name string) error, final func(error) bool) error {
+	var err error
+	for i, name := range providers {
+		err = call(name)
+		if err == nil || final(err) || ctx.Err() != nil || i == len(providers)-1 {
+			return err
+		}
+		recordFailover(ctx, kind, name, providers[i+1], err)
+	}
+	return err
+}
+
+// recordFailover records the move from one provider to the next after err.
+func recordFailover(ctx context.Context, kind, from, to string, err error) {
+	middleware.MarkFeature(ctx, usesProviderFallback)
+	providerFailovers.WithLabelValues(kind, from, to).Inc()
+	trace.SpanFromContext(ctx).AddEvent("provider failover", trace.WithAttributes(
+		attribute.String("provider.kind", kind),
+		attribute.String("provider.from", from),
+		attribute.String("provider.to", to),
+		attribute.String("error", err.Error()),
+	))
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/spf13/viper"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// timeoutConfig holds the timeout hierarchy; zero means unbounded.
+type timeoutConfig struct {
+	handler      time.Duration
+	viaCEP       time.Duration
+	weatherAPI   time.Duration
+	dial         time.Duration
+	tlsHandshake time.Duration
+}
+
+func loadTimeouts() timeoutConfig {
+	return timeoutConfig{
+		handler:      viper.GetDuration("HANDLER_TIMEOUT"),
+		viaCEP:       viper.GetDuration("VIACEP_TIMEOUT"),
+		weatherAPI:   viper.GetDuration("WEATHERAPI_TIMEOUT"),
+		dial:         viper.GetDuration("DIAL_TIMEOUT"),
+		tlsHandshake: viper.GetDuration("TLS_HANDSHAKE_TIMEOUT"),
+	}
+}
+
+// Validate checks that each level of the hierarchy outlasts the one below it:
+// handler timeout > sum of the sequential stage budgets > each client timeout
+// > dial plus TLS handshake. Misordered timeouts surface as confusing 500s,
+// so inconsistent configs are refused at startup.
+func (t timeoutConfig) Validate() error {
+	stages := sum(t.viaCEP, t.weatherAPI)
+	connect := sum(t.dial, t.tlsHandshake)
+	return errors.Join(
+		outlasts("HANDLER_TIMEOUT", t.handler, "VIACEP_TIMEOUT+WEATHERAPI_TIMEOUT", stages),
+		outlasts("VIACEP_TIMEOUT", t.viaCEP, "DIAL_TIMEOUT+TLS_HANDSHAKE_TIMEOUT", connect),
+		outlasts("WEATHERAPI_TIMEOUT", t.weatherAPI, "DIAL_TIMEOUT+TLS_HANDSHAKE_TIMEOUT", connect),
+	)
+}
+
+// sum adds the durations, staying unbounded if any of them is.
+func sum(ds ...time.Duration) time.Duration {
+	var total time.Duration
+	for _, d := range ds {
+		if d <= 0 {
+			return 0
+		}
+		total += d
+	}
+	return total
+}
+
+// outlasts checks parent > child, where zero means unbounded.
+func outlasts(parentName string, parent time.Duration, childName string, child time.Duration) error {
+	switch {
+	case parent <= 0:
+		return nil
+	case child <= 0:
+		return fmt.Errorf("%s (%s) is bounded but %s is not", parentName, parent, childName)
+	case parent <= child:
+		return fmt.Errorf("%s (%s) must be greater than %s (%s)", parentName, parent, childName, child)
+	}
+	return nil
+}
+
+// reloadableTimeouts are the keys re-applied by reloadTimeouts.
+var reloadableTimeouts = []string{"HANDLER_TIMEOUT", "VIACEP_TIMEOUT", "WEATHERAPI_TIMEOUT"}
+
+// activeTimeouts holds the timeouts in effect. The handler and per-call
+// budgets are reloadable; the transport ones apply from startup.
+var activeTimeouts atomic.Pointer[timeoutConfig]
+
+// reloadTimeouts swaps in the reloaded timeouts, keeping the current ones if
+// the new hierarchy is inconsistent.
+func reloadTimeouts() {
+	t := loadTimeouts()
+	if err := t.Validate(); err != nil {
+		slog.Error("ignoring inconsistent reloaded timeouts", "error", err)
+		return
+	}
+	activeTimeouts.Store(&t)
+}
+
+// withTimeout bounds ctx by d, where zero means unbounded.
+func withTimeout(ctx context.Context, d time.Duration) (context.Context, context.CancelFunc) {
+	if d <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, d)
+}
+
+// withStageBudget bounds the first stage of a request, the location lookup,
+// by its share of what is left of the deadline of ctx, in proportion to its
+// timeout and that of the stage after it, so that a slow lookup on a short
+// budget leaves the weather call the time it needs instead of all of it
+// running out. The share is recorded on the span as <stage>.budget_ms when
+// it is shorter than the timeout of the stage, which bounds it otherwise.
+func withStageBudget(ctx context.Context, stage string, timeout, next time.Duration) (context.Context, context.CancelFunc) {
+	deadline, ok := ctx.Deadline()
+	if !ok || timeout <= 0 || next <= 0 {
+		return context.WithCancel(ctx)
+	}
+	share := time.Duration(float64(time.Until(deadline)) * float64(timeout) / float64(timeout+next))
+	if share >= timeout {
+		return context.WithCancel(ctx)
+	}
+	trace.SpanFromContext(ctx).SetAttributes(attribute.Int64(stage+".budget_ms", share.Milliseconds()))
+	return context.WithTimeout(ctx, max(share, 0))
+}
+
+// deadlineHeader carries the remaining budget of the caller, in milliseconds;
+// service-a sends it so work it has given up on is abandoned here too.
+const deadlineHeader = "X-Request-Timeout"
+
+// maxCallerBudgetMs is the longest budget deadlineHeader can carry.
+const maxCallerBudgetMs = math.MaxInt64 / int64(time.Millisecond)
+
+// timeoutMiddleware bounds each request by HANDLER_TIMEOUT, so a hung
+// upstream can't hold a handler forever, or by the caller's remaining budget
+// in deadlineHeader when that is shorter.
+func timeoutMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		budget := activeTimeouts.Load().handler
+		if ms, err := strconv.ParseInt(r.Header.Get(deadlineHeader), 10, 64); err == nil && ms > 0 {
+			// clamped, as a huge budget would overflow into a negative, unbounded one
+			caller := time.Duration(min(ms, maxCallerBudgetMs)) * time.Millisecond
+			if caller > 0 && (budget <= 0 || caller < budget) {
+				budget = caller
+			}
+		}
+		ctx, cancel := withTimeout(r.Context(), budget)
+		defer cancel()
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// newServer builds a listener's server with the connection-level timeouts, so
+// slow clients can't hold connections open: READ_HEADER_TIMEOUT and
+// READ_TIMEOUT bound reading the request, IDLE_TIMEOUT keep-alive connections.
+func newServer(addr string, handler http.Handler) *http.Server {
+	return &http.Server{
+		Addr:              addr,
+		Handler:           handler,
+		ReadHeaderTimeout: viper.GetDuration("READ_HEADER_TIMEOUT"),
+		ReadTimeout:       viper.GetDuration("READ_TIMEOUT"),
+		IdleTimeout:       viper.GetDuration("IDLE_TIMEOUT"),
+	}
+}
+
+// bodyLimitMiddleware caps request bodies at REQUEST_MAX_BYTES; reading past
+// the limit fails with an *http.MaxBytesError.
+func bodyLimitMiddleware(limit int64) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			r.Body = http.MaxBytesReader(w, r.Body, limit)
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"goexpert-lab-2-observabilidade/internal/apperr"
+	"goexpert-lab-2-observabilidade/internal/httpclient"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/spf13/viper"
+)
+
+const (
+	viaCEPBaseURL     = "https://viacep.com.br"
+	weatherAPIBaseURL = "https://api.weatherapi.com"
+)
+
+// newUpstreamTransport is shared by every outbound call so connections and
+// TLS sessions are reused across requests; name labels its connection metrics.
+// Certificates are verified against the system roots.
+func newUpstreamTransport(name string, timeouts timeoutConfig) http.RoundTripper {
+	cfg := httpclient.LoadPool().Transport(name)
+	cfg.DNSCacheTTL = viper.GetDuration("DNS_CACHE_TTL")
+	cfg.DialTimeout = timeouts.dial
+	cfg.TLSHandshakeTimeout = timeouts.tlsHandshake
+	cfg.TLS = &tls.Config{MinVersion: tls.VersionTLS12}
+	return httpclient.NewTransport(cfg)
+}
+
+// weatherAPIKey returns WEATHER_API_KEY, or the content of WEATHER_API_KEY_FILE
+// when the key is mounted as a secret.
+func weatherAPIKey() (string, error) {
+	if path := viper.GetString("WEATHER_API_KEY_FILE"); path != "" {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return "", fmt.Errorf("failed to read WEATHER_API_KEY_FILE: %w", err)
+		}
+		return strings.TrimSpace(string(b)), nil
+	}
+	return viper.GetString("WEATHER_API_KEY"), nil
+}
+
+// apiKeyTransport adds an API key to requests for its host, as the query
+// parameter param, below the client, so the key never appears in the request
+// URLs that end up in errors, logs and spans.
+type apiKeyTransport struct {
+	base  http.RoundTripper
+	host  string
+	param string
+	key   string
+}
+
+func newAPIKeyTransport(base http.RoundTripper, baseURL, param, key string) apiKeyTransport {
+	u, _ := url.Parse(baseURL)
+	return apiKeyTransport{base: base, host: u.Host, param: param, key: key}
+}
+
+func (t apiKeyTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	if r.URL.Host != t.host {
+		return t.base.RoundTrip(r)
+	}
+	r = r.Clone(r.Context())
+	q := r.URL.Query()
+	q.Set(t.param, t.key)
+	r.URL.RawQuery = q.Encode()
+	return t.base.RoundTrip(r)
+}
+
+// statusError reports an unsuccessful status code returned by an upstream.
+type statusError struct {
+	target string
+	code   int
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("%s responded with status %d", e.target, e.code)
+}
+
+// checkStatus turns a non-2xx upstream response into a statusError.
+func checkStatus(target string, resp *http.Response) error {
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return &statusError{target: target, code: resp.StatusCode}
+	}
+	return nil
+}
+
+// locationError maps a failed ViaCEP lookup: ViaCEP rejects malformed CEPs
+// with a 400, which means the zipcode can't be found rather than an outage.
+func locationError(err error) *apperr.Error {
+	if isCEPRejected(err) {
+		return apperr.ErrZipNotFound.Wrap(err)
+	}
+	return apperr.Upstream(apperr.ErrViaCEP, err)
+}
+
+type retryClass string
+
+const (
+	retryable    retryClass = "retryable"
+	nonRetryable retryClass = "non_retryable"
+)
+
+var providerErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "provider_errors_total",
+	Help: "Failed upstream calls by target and retry classification.",
+}, []string{"target", "class"})
+
+// classifyRetry decides whether a failed upstream call is worth retrying:
+// timeouts, connection failures, 408, 429 and 5xx (other than 501) are
+// transient; everything else, such as 400, 401 and 404, is permanent.
+func classifyRetry(err error) retryClass {
+	var se *statusError
+	if errors.As(err, &se) {
+		switch {
+		case se.code == http.StatusRequestTimeout,
+			se.code == http.StatusTooManyRequests,
+			se.code >= 500 && se.code != http.StatusNotImplemented:
+			return retryable
+		}
+		return nonRetryable
+	}
+
+	var netErr net.Error
+	if apperr.IsTimeout(err) || errors.As(err, &netErr) {
+		return retryable
+	}
+	return nonRetryable
+}
+
+// observeProviderError classifies err, counts it for target and returns the class.
+func observeProviderError(target string, err error) retryClass {
+	class := classifyRetry(err)
+	providerErrors.WithLabelValues(target, string(class)).Inc()
+	return class
+}
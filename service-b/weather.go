@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// WeatherProvider fetches the current weather from one vendor. The query is
+// a city name, optionally followed by ", <state>, Brazil" or ", Brazil", or
+// "lat,lon" coordinates; the result is in the shape of WeatherAPI's
+// current.json, which the other vendors are translated to.
+type WeatherProvider interface {
+	Name() string
+	BaseURL() string
+	GetCurrent(ctx context.Context, query string) (WeatherInfo, error)
+}
+
+// Names of the weather providers, as listed in WEATHER_PROVIDERS.
+const (
+	providerWeatherAPI     = "weatherapi"
+	providerOpenWeatherMap = "openweathermap"
+	providerOpenMeteo      = "openmeteo"
+)
+
+var weatherProviderNames = []string{providerWeatherAPI, providerOpenWeatherMap, providerOpenMeteo}
+
+// errNoLocation is returned by providers when no location matches the query.
+var errNoLocation = errors.New("no location matches the query")
+
+// newWeatherProviders builds the named providers, which make their calls with
+// client; the API keys are added to the requests by its transport.
+func newWeatherProviders(names []string, client *http.Client, tracer trace.Tracer) map[string]WeatherProvider {
+	providers := make(map[string]WeatherProvider, len(names))
+	for _, name := range names {
+		switch name {
+		case providerWeatherAPI:
+			providers[name] = weatherAPIProvider{client: client, tracer: tracer}
+		case providerOpenWeatherMap:
+			providers[name] = openWeatherMapProvider{client: client, tracer: tracer}
+		case providerOpenMeteo:
+			providers[name] = openMeteoProvider{client: client, tracer: tracer}
+		}
+	}
+	return providers
+}
+
+// fetchWeather fetches the current weather at query from WEATHER_PROVIDERS,
+// in the order picked by PROVIDER_SELECTION, failing over to the next
+// provider on errors. With WEATHER_HEDGE_AFTER set, a slow first provider is
+// hedged instead.
+func (h *handler) fetchWeather(ctx context.Context, query string) (WeatherInfo, error) {
+	var weather WeatherInfo
+	var provider string
+	order := h.weatherSelector.Order(ctx, h.weatherNames)
+	if h.weatherHedge.enabled() {
+		if delay := h.weatherHedge.delay(h.weatherSelector, order[0]); delay > 0 {
+			weather, provider, err := h.hedgeWeather(ctx, query, order, delay)
+			trace.SpanFromContext(ctx).SetAttributes(attribute.String("weather.provider", provider))
+			return weather, err
+		}
+	}
+	err := withFailover(ctx, "weather", order, func(name string) error {
+		start := time.Now()
+		found, err := h.weatherProviders[name].GetCurrent(ctx, query)
+		h.observeWeatherProvider(name, time.Since(start), err)
+		weather, provider = found, name
+		return err
+	}, isNoMatch)
+	trace.SpanFromContext(ctx).SetAttributes(attribute.String("weather.provider", provider))
+	return weather, err
+}
+
+// observeWeatherProvider records a call to a weather provider for provider
+// selection and degradation. No match is a healthy answer, as Zippopotam.us's
+// 404s are, so clients asking for unknown cities can't mark a provider down.
+func (h *handler) observeWeatherProvider(name string, latency time.Duration, err error) {
+	if isNoMatch(err) {
+		err = nil
+	}
+	h.weatherSelector.Observe(name, latency, err)
+	h.degradation.ObserveDependency(name, err == nil)
+}
+
+// isNoMatch reports whether a provider found no location for the query, which
+// is an answer rather than a failure.
+func isNoMatch(err error) bool {
+	var we *weatherAPIError
+	return errors.Is(err, errNoLocation) || (errors.As(err, &we) && we.reason() == weatherReasonNoMatch)
+}
+
+// weatherQuery is a provider query taken apart, for the vendors that don't
+// take it as is.
+type weatherQuery struct {
+	name, region string // region is the state, when given
+
+	coordinates bool
+	lat, lon    float64
+}
+
+func parseWeatherQuery(query string) weatherQuery {
+	if latV, lonV, ok := strings.Cut(query, ","); ok {
+		lat, errLat := strconv.ParseFloat(latV, 64)
+		lon, errLon := strconv.ParseFloat(lonV, 64)
+		if errLat == nil && errLon == nil {
+			return weatherQuery{coordinates: true, lat: lat, lon: lon}
+		}
+	}
+	parts := strings.Split(query, ", ")
+	q := weatherQuery{name: parts[0]}
+	if len(parts) == 3 {
+		q.region = parts[1]
+	}
+	return q
+}
+
+// compass turns a wind direction in degrees into one of the 16 compass
+// points, like WeatherAPI's wind_dir.
+func compass(deg int) string {
+	points := []string{"N", "NNE", "NE", "ENE", "E", "ESE", "SE", "SSE", "S", "SSW", "SW", "WSW", "W", "WNW", "NW", "NNW"}
+	return points[((deg%360+360)*2+22)/45%16]
+}
+
+// wallClock is a Unix time as the wall clock of a UTC offset, in the format
+// of WeatherAPI's localtime.
+func wallClock(epoch int64, offsetSeconds int) string {
+	return time.Unix(epoch+int64(offsetSeconds), 0).UTC().Format("2006-01-02 15:04")
+}
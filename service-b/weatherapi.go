@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"goexpert-lab-2-observabilidade/internal/apperr"
+)
+
+// Reasons of weatherapi_errors_total, from the codes of WeatherAPI's error
+// payloads: https://www.weatherapi.com/docs/#intro-error-codes
+const (
+	weatherReasonQuota        = "quota"
+	weatherReasonUnauthorized = "unauthorized"
+	weatherReasonNoMatch      = "no_match"
+	weatherReasonOther        = "other"
+)
+
+var weatherAPIErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "weatherapi_errors_total",
+	Help: "Error responses of WeatherAPI, by reason (quota, unauthorized, no_match, other).",
+}, []string{"reason"})
+
+// weatherAPIError is an error response of WeatherAPI, whose body looks like
+// {"error": {"code": 1006, "message": "No matching location found."}}. It
+// wraps the statusError, so callers matching on the status still can.
+type weatherAPIError struct {
+	status  *statusError
+	code    int
+	message string
+}
+
+func (e *weatherAPIError) Error() string {
+	if e.code == 0 {
+		return e.status.Error()
+	}
+	return fmt.Sprintf("%s: %s (code %d)", e.status.Error(), e.message, e.code)
+}
+
+func (e *weatherAPIError) Unwrap() error {
+	return e.status
+}
+
+func (e *weatherAPIError) reason() string {
+	switch {
+	case e.code == 2007 || e.status.code == http.StatusTooManyRequests:
+		return weatherReasonQuota
+	case e.code == 1002 || e.code == 2006 || e.code == 2008 || e.status.code == http.StatusUnauthorized:
+		return weatherReasonUnauthorized
+	case e.code == 1006:
+		return weatherReasonNoMatch
+	}
+	return weatherReasonOther
+}
+
+// checkWeatherStatus is checkStatus for WeatherAPI: the error payload of a
+// non-2xx response is parsed into a weatherAPIError, which is counted and
+// recorded on the span of the call.
+func checkWeatherStatus(resp *http.Response) error {
+	err := checkStatus("weatherapi", resp)
+	if err == nil {
+		return nil
+	}
+
+	var payload struct {
+		Error struct {
+			Code    int    `json:"code"`
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	// a body that isn't the error payload leaves the code at zero
+	json.NewDecoder(io.LimitReader(resp.Body, 64<<10)).Decode(&payload)
+	we := &weatherAPIError{status: err.(*statusError), code: payload.Error.Code, message: payload.Error.Message}
+
+	reason := we.reason()
+	weatherAPIErrors.WithLabelValues(reason).Inc()
+	trace.SpanFromContext(resp.Request.Context()).SetAttributes(
+		attribute.Int("weatherapi.error.code", we.code),
+		attribute.String("weatherapi.error.reason", reason),
+	)
+	return we
+}
+
+// weatherError maps a failed weather lookup: WeatherAPI's exhausted quota
+// and rejected key get errors of their own, so they aren't mistaken for an
+// outage, and a query no location matches is a city that can't be found.
+func weatherError(err error) *apperr.Error {
+	if errors.Is(err, errNoLocation) {
+		return apperr.ErrCityNotFound.Wrap(err)
+	}
+	var we *weatherAPIError
+	if errors.As(err, &we) {
+		switch we.reason() {
+		case weatherReasonQuota:
+			return apperr.ErrWeatherQuota.Wrap(err)
+		case weatherReasonUnauthorized:
+			return apperr.ErrWeatherUnauthorized.Wrap(err)
+		case weatherReasonNoMatch:
+			return apperr.ErrCityNotFound.Wrap(err)
+		}
+	}
+	return apperr.Upstream(apperr.ErrWeatherAPI, err)
+}
+
+// weatherAPIProvider is WeatherProvider on WeatherAPI's current.json, the
+// shape the other providers are translated to.
+type weatherAPIProvider struct {
+	client *http.Client
+	tracer trace.Tracer
+}
+
+func (p weatherAPIProvider) Name() string    { return providerWeatherAPI }
+func (p weatherAPIProvider) BaseURL() string { return weatherAPIBaseURL }
+
+func (p weatherAPIProvider) GetCurrent(ctx context.Context, query string) (WeatherInfo, error) {
+
+	ctx, span := p.tracer.Start(ctx, "Chamada externa: getWeather", trace.WithAttributes(attribute.String("weather.provider", providerWeatherAPI)))
+	defer span.End()
+
+	ctx, cancel := withTimeout(ctx, activeTimeouts.Load().weatherAPI)
+	defer cancel()
+
+	completeUrl := fmt.Sprintf("%s/v1/current.json?q=%s", weatherAPIBaseURL, url.QueryEscape(query))
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, completeUrl, nil)
+	if err != nil {
+		return WeatherInfo{}, err
+	}
+	resp, err := p.client.Do(req)
+
+	if err != nil {
+		observeProviderError("weatherapi", err)
+		return WeatherInfo{}, err
+	}
+	defer resp.Body.Close()
+
+	if err := checkWeatherStatus(resp); err != nil {
+		observeProviderError("weatherapi", err)
+		return WeatherInfo{}, err
+	}
+
+	var weather WeatherInfo
+	if err := json.NewDecoder(resp.Body).Decode(&weather); err != nil {
+		return WeatherInfo{}, err
+	}
+
+	return weather, nil
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"goexpert-lab-2-observabilidade/internal/middleware"
+)
+
+var weatherRevalidations = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "weather_revalidations_total",
+	Help: "Expired weather cache entries refreshed for a lookup, by outcome: refreshed in time, or served stale because the refresh failed or was slow.",
+}, []string{"outcome"})
+
+// cachedWeather is an entry of the weather cache, in JSON for Redis. It is
+// kept past FreshUntil for WEATHER_STALE_TTL, to be served while it is
+// revalidated.
+type cachedWeather struct {
+	Weather     WeatherInfo `json:"weather"`
+	RetrievedAt time.Time   `json:"retrieved_at"`
+	FreshUntil  time.Time   `json:"fresh_until"`
+}
+
+// staleWhileRevalidate is how the weather cache serves expired entries.
+type staleWhileRevalidate struct {
+	wait time.Duration // how long a lookup waits for the refresh of one
+
+	mu       sync.Mutex
+	inFlight map[string]*weatherRefresh
+}
+
+// weatherRefresh is the refresh of an expired entry, shared by the lookups
+// of its key while it runs.
+type weatherRefresh struct {
+	done    chan struct{}
+	weather WeatherInfo
+	err     error
+}
+
+// currentWeather fetches the current weather at query, a city name or
+// "lat,lon" coordinates, from the weather providers, or from the cache when
+// it holds a fresh entry. An expired entry is revalidated instead.
+func (h *handler) currentWeather(ctx context.Context, query string) (WeatherInfo, error) {
+	key := foldName(strings.TrimSpace(query))
+	cached, result := h.weather.Get(ctx, key)
+	if result == cacheHit && time.Now().After(cached.FreshUntil) {
+		result = cacheStale
+	}
+	trace.SpanFromContext(ctx).SetAttributes(attribute.String("cache.weather", result))
+	switch {
+	case result == cacheHit:
+		return cached.info(), nil
+	case result == cacheStale && !cached.RetrievedAt.IsZero():
+		return h.revalidateWeather(ctx, key, query, cached)
+	}
+
+	weather, err := h.fetchWeather(ctx, query)
+	if err != nil {
+		return WeatherInfo{}, weatherError(err)
+	}
+	return h.storeWeather(ctx, key, weather), nil
+}
+
+func (c cachedWeather) info() WeatherInfo {
+	weather := c.Weather
+	weather.retrievedAt, weather.cached = c.RetrievedAt, true
+	return weather
+}
+
+// storeWeather caches weather just fetched for key, fresh for
+// WEATHER_CACHE_TTL and stale for WEATHER_STALE_TTL after that.
+func (h *handler) storeWeather(ctx context.Context, key string, weather WeatherInfo) WeatherInfo {
+	weather.retrievedAt = time.Now()
+	ttls := activeCacheTTLs.Load()
+	ttl := h.degradation.CacheTTL(ttls.weather)
+	entry := cachedWeather{Weather: weather, RetrievedAt: weather.retrievedAt, FreshUntil: weather.retrievedAt.Add(ttl)}
+	h.weather.Set(ctx, key, entry, ttl+ttls.weatherStale)
+	return weather
+}
+
+// revalidateWeather refreshes the expired entry of key, waiting up to
+// WEATHER_REVALIDATE_TIMEOUT for it. When the refresh fails, or is still
+// running by then, the stale entry is returned, marked as such, and a
+// running refresh stores its result once it is done.
+func (h *handler) revalidateWeather(ctx context.Context, key, query string, stale cachedWeather) (WeatherInfo, error) {
+	refresh := h.refreshWeather(ctx, key, query)
+	timer := time.NewTimer(h.weatherSWR.wait)
+	defer timer.Stop()
+
+	outcome := "slow"
+	select {
+	case <-refresh.done:
+		if refresh.err == nil {
+			weatherRevalidations.WithLabelValues("refreshed").Inc()
+			return refresh.weather, nil
+		}
+		outcome = "failed"
+	case <-timer.C:
+	case <-ctx.Done():
+	}
+
+	weatherRevalidations.WithLabelValues(outcome).Inc()
+	middleware.MarkFeature(ctx, usesStaleCache)
+	trace.SpanFromContext(ctx).AddEvent("stale weather served", trace.WithAttributes(
+		attribute.String("reason", outcome),
+		attribute.Int64("cache.age_s", int64(time.Since(stale.RetrievedAt).Seconds())),
+	))
+	weather := stale.info()
+	weather.stale = true
+	return weather, nil
+}
+
+// refreshWeather starts refreshing the weather of key, unless a refresh of
+// it is already running, which is returned instead, so a popular key gets
+// one upstream call however many lookups find it expired. The refresh is
+// detached from ctx, so it carries on when the lookup stops waiting for it,
+// but bounded by HANDLER_TIMEOUT like the lookups, so a hung provider can't
+// keep the key from being refreshed again.
+func (h *handler) refreshWeather(ctx context.Context, key, query string) *weatherRefresh {
+	swr := &h.weatherSWR
+	swr.mu.Lock()
+	defer swr.mu.Unlock()
+	if r, ok := swr.inFlight[key]; ok {
+		return r
+	}
+	r := &weatherRefresh{done: make(chan struct{})}
+	swr.inFlight[key] = r
+
+	go func() {
+		ctx, cancel := withTimeout(context.WithoutCancel(ctx), activeTimeouts.Load().handler)
+		defer cancel()
+		weather, err := h.fetchWeather(ctx, query)
+		if err == nil {
+			weather = h.storeWeather(ctx, key, weather)
+		}
+		r.weather, r.err = weather, err
+
+		swr.mu.Lock()
+		delete(swr.inFlight, key)
+		swr.mu.Unlock()
+		close(r.done)
+	}()
+	return r
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"goexpert-lab-2-observabilidade/internal/api"
+	"goexpert-lab-2-observabilidade/internal/apperr"
+)
+
+const zippopotamBaseURL = "https://api.zippopotam.us"
+
+// zippopotamPlace is a place of Zippopotam.us's postal code response, whose
+// coordinates are strings.
+type zippopotamPlace struct {
+	Name      string `json:"place name"`
+	State     string `json:"state"`
+	StateCode string `json:"state abbreviation"`
+	Latitude  string `json:"latitude"`
+	Longitude string `json:"longitude"`
+}
+
+// lookupForeignTemperature is lookupTemperature for the postal codes of
+// other countries than Brazil: the code is resolved with Zippopotam.us and
+// the weather fetched at the coordinates of its first place, after which
+// the result is named.
+func (h *handler) lookupForeignTemperature(ctx context.Context, country, code string) (api.Temperature, error) {
+	t := activeTimeouts.Load()
+	lookupCtx, cancel := withStageBudget(ctx, "postal", t.viaCEP, t.weatherAPI)
+	place, err := h.lookupPostalCode(lookupCtx, country, code)
+	cancel()
+	if err != nil {
+		return api.Temperature{}, err
+	}
+	weather, err := h.currentWeather(ctx, place.Latitude+","+place.Longitude)
+	if err != nil {
+		return api.Temperature{}, err
+	}
+	result := h.temperature(place.Name, weather)
+	result.Address = &api.Address{UF: place.StateCode}
+	return result, nil
+}
+
+// lookupPostalCode resolves a valid postal code of country to its first
+// place. Zippopotam.us answers unknown codes with a 404.
+func (h *handler) lookupPostalCode(ctx context.Context, country, code string) (zippopotamPlace, error) {
+
+	ctx, span := h.tracer.Start(ctx, "Chamada externa: getPostalCode", trace.WithAttributes(
+		attribute.String("postal.country", country),
+		attribute.String("postal.code", code),
+	))
+	defer span.End()
+
+	ctx, cancel := withTimeout(ctx, activeTimeouts.Load().viaCEP)
+	defer cancel()
+
+	url := fmt.Sprintf("%s/%s/%s", zippopotamBaseURL, strings.ToLower(country), code)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return zippopotamPlace{}, err
+	}
+	resp, err := h.client.Do(req)
+	h.degradation.ObserveDependency("zippopotam", err == nil && resp.StatusCode < http.StatusInternalServerError)
+
+	if err != nil {
+		observeProviderError("zippopotam", err)
+		return zippopotamPlace{}, apperr.Upstream(apperr.ErrPostalCodeAPI, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		span.AddEvent("postal code not found")
+		return zippopotamPlace{}, apperr.ErrZipNotFound
+	}
+	if err := checkStatus("zippopotam", resp); err != nil {
+		observeProviderError("zippopotam", err)
+		return zippopotamPlace{}, apperr.Upstream(apperr.ErrPostalCodeAPI, err)
+	}
+
+	var info struct {
+		Places []zippopotamPlace `json:"places"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&info); err != nil {
+		return zippopotamPlace{}, apperr.Upstream(apperr.ErrPostalCodeAPI, err)
+	}
+	// a code Zippopotam.us knows always has a place
+	if len(info.Places) == 0 {
+		return zippopotamPlace{}, apperr.ErrBadUpstreamPayload.Wrap(fmt.Errorf("zippopotam answered %s %s without places", country, code))
+	}
+	return info.Places[0], nil
+}